@@ -0,0 +1,214 @@
+// Package ast declares the types of the syntax tree of a blip template.
+//
+// A tree is built by compiler.Parse.  Every node has the span of the template source it was parsed from,
+// Walk and Inspect visit the nodes in source order.
+package ast
+
+import "fmt"
+
+// Pos
+// A position in the template.  Line and Col start at 1, Col counts characters. Offset is in bytes.
+type Pos struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+// String
+// line:col
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// IsValid
+// False for the zero Pos, ex: the end of a block without its @end.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// Span
+// The source of a node, from the @ of the command to just after it, the @end for blocks.
+// Commands that take the rest of the line include the newline.
+type Span struct {
+	Start Pos
+	Stop  Pos
+}
+
+// Pos
+// The start of the node.
+func (s Span) Pos() Pos {
+	return s.Start
+}
+
+// End
+// Just after the node.
+func (s Span) End() Pos {
+	return s.Stop
+}
+
+// Node
+// All nodes of the tree.
+type Node interface {
+	Pos() Pos
+	End() Pos
+}
+
+// Template
+// The root of the tree.  The @import, @arg, @context and @func declarations are collected,
+// Body has the output of the template in source order.
+type Template struct {
+	Span
+	Filename string
+	Name     *Name // nil when the template is named by its file
+	Imports  []*Import
+	Args     []*Arg
+	Context  []*Context
+	Funcs    []*Func
+	Body     []Node
+}
+
+// Text
+// Literal output, written through the escaper of the template in a @text block.
+type Text struct {
+	Span
+	Text string
+}
+
+// Name
+// @name templateName, the render function is named by it in place of the file name.
+type Name struct {
+	Span
+	Name string
+}
+
+// Import
+// @import "path" or @import name "path"
+type Import struct {
+	Span
+	Spec string // As written, ex: "strings"
+}
+
+// Arg
+// @arg name type, a parameter of the render function. @arg a, b string has an Arg for a and one for b.
+type Arg struct {
+	Span
+	Name string
+	Type string
+}
+
+// Context
+// @context name type, or @context name type = value.  A variable read from the context.Context.
+type Context struct {
+	Span
+	Name  string
+	Type  string
+	Value string // The initial value, empty when there is none
+}
+
+// DisplayKind
+// How a display command writes its value.
+type DisplayKind int
+
+const (
+	DisplayEscaped DisplayKind = iota // @= expr @
+	DisplayRaw                        // @== expr @
+	DisplayBool                       // @bool= expr @
+	DisplayInt                        // @int= expr @
+	DisplayInt64                      // @int64= expr @
+)
+
+var displayCommands = [...]string{"@=", "@==", "@bool=", "@int=", "@int64="}
+
+// String
+// The command, ex: @int=
+func (k DisplayKind) String() string {
+	if k < 0 || int(k) >= len(displayCommands) {
+		return fmt.Sprintf("DisplayKind(%d)", int(k))
+	}
+	return displayCommands[k]
+}
+
+// Display
+// Writes the value of a go expression.
+type Display struct {
+	Span
+	Kind    DisplayKind
+	Expr    string
+	ExprPos Pos
+}
+
+// Include
+// @include template args.  Template may be qualified by the package, ex: layout.header
+type Include struct {
+	Span
+	Template string
+	Args     []string
+}
+
+// Extend
+// @extend template args ... @end, calls the template with the content for its @yield.
+type Extend struct {
+	Span
+	Template string
+	Args     []string
+	Contents []*Content
+}
+
+// Content
+// @content name ... @end within an @extend, the output for the @yield of the same name.
+type Content struct {
+	Span
+	Name string
+	Body []Node
+}
+
+// Yield
+// @yield name, where the content given by the calling template is written.
+type Yield struct {
+	Span
+	Name string
+}
+
+// Code
+// @code ... @end, go statements of the render function.
+type Code struct {
+	Span
+	Code    string
+	CodePos Pos // The start of Code
+}
+
+// Func
+// @func ... @end, go declarations placed outside the render function.
+type Func struct {
+	Span
+	Code    string
+	CodePos Pos // The start of Code
+}
+
+// TextBlock
+// @text ... @end, output where the @ commands are not processed.
+type TextBlock struct {
+	Span
+	Text string
+}
+
+// If
+// @if cond ... @else ... @end
+type If struct {
+	Span
+	Cond    string
+	CondPos Pos
+	Then    []Node
+	ElsePos Pos // The @else, not valid when there is none
+	Else    []Node
+}
+
+// For
+// @for name in expr ... @end
+type For struct {
+	Span
+	Var      string
+	Range    string
+	RangePos Pos
+	Body     []Node
+}
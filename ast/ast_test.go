@@ -0,0 +1,104 @@
+package ast_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/samlotti/blip/ast"
+	"github.com/samlotti/blip/compiler"
+	"github.com/stretchr/testify/assert"
+)
+
+const sample = `@import "strings"
+@arg names []string
+@context user string = "guest"
+@extend layout.base "Names"
+	@content body
+	@for n in names
+		<li>@= strings.ToUpper(n) @</li>
+	@end
+	@if len(names) == 0
+		@include empty user
+	@else
+		@int= len(names) @
+	@end
+	@end
+@end
+`
+
+func TestParse(t *testing.T) {
+	tmpl, diags := compiler.Parse(strings.NewReader(sample), "pages/names.blip.html")
+	assert.Equal(t, 0, len(diags))
+	assert.Equal(t, "pages/names.blip.html", tmpl.Filename)
+
+	assert.Equal(t, `"strings"`, tmpl.Imports[0].Spec)
+	assert.Equal(t, "names", tmpl.Args[0].Name)
+	assert.Equal(t, "[]string", tmpl.Args[0].Type)
+	assert.Equal(t, "2:1", tmpl.Args[0].Pos().String())
+	assert.Equal(t, "3:1", tmpl.Args[0].End().String())
+	assert.Equal(t, &ast.Context{Span: tmpl.Context[0].Span, Name: "user", Type: "string", Value: `"guest"`}, tmpl.Context[0])
+
+	extend := tmpl.Body[0].(*ast.Extend)
+	assert.Equal(t, "layout.base", extend.Template)
+	assert.Equal(t, []string{`"Names"`}, extend.Args)
+	assert.Equal(t, "4:1", extend.Pos().String())
+	assert.Equal(t, "15:5", extend.End().String())
+	assert.Equal(t, len(sample)-1, extend.End().Offset)
+
+	content := extend.Contents[0]
+	assert.Equal(t, "body", content.Name)
+
+	var loop *ast.For
+	var cond *ast.If
+	for _, node := range content.Body {
+		switch n := node.(type) {
+		case *ast.For:
+			loop = n
+		case *ast.If:
+			cond = n
+		}
+	}
+	assert.Equal(t, "n", loop.Var)
+	assert.Equal(t, "names", loop.Range)
+	assert.Equal(t, "6:12", loop.RangePos.String())
+	assert.Equal(t, "names", sample[loop.RangePos.Offset:loop.RangePos.Offset+5])
+
+	assert.Equal(t, "len(names) == 0", cond.Cond)
+	assert.Equal(t, "11:2", cond.ElsePos.String())
+	include := cond.Then[1].(*ast.Include)
+	assert.Equal(t, "empty", include.Template)
+	assert.Equal(t, []string{"user"}, include.Args)
+	display := cond.Else[1].(*ast.Display)
+	assert.Equal(t, ast.DisplayInt, display.Kind)
+	assert.Equal(t, "len(names)", display.Expr)
+	assert.Equal(t, "@int= len(names) @", sample[display.Pos().Offset:display.End().Offset])
+}
+
+func TestInspect(t *testing.T) {
+	tmpl, _ := compiler.Parse(strings.NewReader(sample), "pages/names.blip.html")
+
+	var found []string
+	ast.Inspect(tmpl, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.Display:
+			found = append(found, fmt.Sprintf("%s %s %s", n.Pos(), n.Kind, n.Expr))
+		case *ast.Include:
+			found = append(found, fmt.Sprintf("%s @include %s", n.Pos(), n.Template))
+		case *ast.If:
+			// Skip the children
+			return false
+		}
+		return true
+	})
+	assert.Equal(t, []string{"7:7 @= strings.ToUpper(n)"}, found)
+}
+
+func TestParseErrors(t *testing.T) {
+	tmpl, diags := compiler.Parse(strings.NewReader("@for n in names\n\t@= n @\n"), "list.blip.html")
+	assert.Equal(t, 1, len(diags))
+	assert.Equal(t, "@for : block is not closed, expected @end", diags[0].Message)
+	// The tree is built, the block has no end
+	loop := tmpl.Body[0]
+	assert.False(t, loop.End().IsValid())
+}
@@ -0,0 +1,84 @@
+package ast
+
+import "fmt"
+
+// Visitor
+// Visit is called for each node found by Walk.
+// If the visitor w returned is not nil, Walk visits the children of the node with w, followed by w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk
+// Visits the tree in source order, depth first.  The declarations of a Template are visited before its Body.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Template:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		for _, child := range n.Imports {
+			Walk(v, child)
+		}
+		for _, child := range n.Args {
+			Walk(v, child)
+		}
+		for _, child := range n.Context {
+			Walk(v, child)
+		}
+		for _, child := range n.Funcs {
+			Walk(v, child)
+		}
+		walkList(v, n.Body)
+	case *Extend:
+		for _, child := range n.Contents {
+			Walk(v, child)
+		}
+	case *Content:
+		walkList(v, n.Body)
+	case *If:
+		walkList(v, n.Then)
+		walkList(v, n.Else)
+	case *For:
+		walkList(v, n.Body)
+	case *Text, *Name, *Import, *Arg, *Context, *Display, *Include, *Yield, *Code, *Func, *TextBlock:
+		// No children
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkList(v Visitor, list []Node) {
+	for _, child := range list {
+		Walk(v, child)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect
+// Calls f for each node of the tree in source order, the children are skipped when f returns false.
+// f is called with nil after the children of a node.
+//
+//	ast.Inspect(tmpl, func(n ast.Node) bool {
+//		if inc, ok := n.(*ast.Include); ok {
+//			fmt.Printf("%s: includes %s\n", inc.Pos(), inc.Template)
+//		}
+//		return true
+//	})
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -3,31 +3,251 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"strings"
+
 	"github.com/samlotti/blip/internal"
 )
 
 // main
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "check":
+			os.Exit(check(args[1:]))
+		case "fmt":
+			os.Exit(format(args[1:]))
+		case "vet":
+			os.Exit(vet(args[1:]))
+		case "lsp":
+			os.Exit(internal.GteLsp())
+		case "clean":
+			os.Exit(clean(args[1:]))
+		case "dev":
+			os.Exit(dev(args[1:]))
+		default:
+			fmt.Fprintf(os.Stderr, "blip: unknown command %s\n", args[0])
+			fmt.Fprintf(os.Stderr, "commands: check, fmt, vet, lsp, clean, dev\n")
+			os.Exit(2)
+		}
+	}
 
 	var goptions = internal.BlipOptions{}
 	var help bool
 
-	flag.BoolVar(&help, "help", false, "Print help message")
-	flag.StringVar(&goptions.Sdir, "dir", "./template", "The source directory containing templates")
-	flag.BoolVar(&goptions.Rebuild, "rebuild", false, "rebuild all files")
-	flag.BoolVar(&goptions.Watch, "watch", false, "will watch the directory for file names/new files")
-	flag.StringVar(&goptions.SupportBranch, "supportBranch", "github.com/samlotti/blip/blipUtil", "Support branch name for include.")
-	flag.BoolVar(&goptions.RenderLineNumbers, "renderLineNumbers", false, "Render template line numbers in the generated Go code.  defaults false for easier diffing in source control. ex: adding one line will not show all next line numbers as differences ")
+	flags := flag.NewFlagSet("blip", flag.ExitOnError)
+	flags.BoolVar(&help, "help", false, "Print help message")
+	addCommonFlags(flags, &goptions)
+	flags.BoolVar(&goptions.Rebuild, "rebuild", false, "rebuild all files")
+	flags.BoolVar(&goptions.Watch, "watch", false, "Build the templates again as they change, new directories are watched and removed templates pruned")
+	addExecFlag(flags, &goptions)
+	addGenerateFlags(flags, &goptions)
 
-	flag.Parse()
+	flags.Parse(args)
 
 	if help {
 		fmt.Println(internal.Name)
 		fmt.Printf("Blip Processing: Version: %s\n", internal.Version)
-		flag.PrintDefaults()
+		flags.PrintDefaults()
+		fmt.Printf("\nCommands:\n  check [-types] [-stale]\n    \tType check the generated code and compare it with the files on disk without writing, errors are reported at the template line\n")
+		fmt.Printf("  fmt [-w] [-d] [paths]\n    \tFormat the templates to the canonical form\n")
+		fmt.Printf("  vet\n    \tReport unused declarations, unescaped args and mismatched @yield / @content\n")
+		fmt.Printf("  clean [-all]\n    \tRemove the generated files of templates that no longer exist, -all removes every generated file\n")
+		fmt.Printf("  lsp\n    \tRun the language server on stdin / stdout for editors\n")
+		fmt.Printf("  dev [-addr host:port] [-exec command]\n    \tWatch the templates and reload the pages in the browser after each build, the template errors are shown over the page\n")
 		return
 	}
 
-	internal.GteProcess(&goptions)
+	if goptions.Exec != "" && !goptions.Watch {
+		// The exec of blip.yaml is for the -watch builds only, a single build ignores it
+		fmt.Fprintf(os.Stderr, "blip: -exec needs -watch\n")
+		flags.Usage()
+		os.Exit(2)
+	}
+	if !prepareOptions(flags, &goptions) {
+		os.Exit(2)
+	}
+	if internal.GteProcess(&goptions) > 0 {
+		os.Exit(1)
+	}
+}
+
+// addCommonFlags
+// Flags shared by the build and the commands.
+func addCommonFlags(flags *flag.FlagSet, goptions *internal.BlipOptions) {
+	goptions.Sdir = "./template"
+	flags.Var(&rootsFlag{goptions: goptions}, "dir", "The source directory containing templates, repeat or separate with commas for several")
+	flags.StringVar(&goptions.Output, "out", "", "The directory of the generated packages, relative to the go.mod (default blipped)")
+	flags.BoolVar(&goptions.Colocate, "colocate", false, "Generate the go files next to the templates, in the go package of their directory")
+	flags.StringVar(&goptions.SupportBranch, "supportBranch", "github.com/samlotti/blip/blipUtil", "Support branch name for include.")
+	flags.StringVar(&goptions.Format, "format", internal.FormatText, "The format of the errors: text, json or gcc (file:line:col: error: msg)")
+}
+
+// rootsFlag
+// -dir, each use adds source directories.
+type rootsFlag struct {
+	goptions *internal.BlipOptions
+	set      bool
+}
+
+func (f *rootsFlag) String() string {
+	if f.goptions == nil {
+		return ""
+	}
+	return f.goptions.Sdir
+}
+
+func (f *rootsFlag) Set(value string) error {
+	if !f.set {
+		f.goptions.Roots = nil
+		f.goptions.Sdir = ""
+		f.set = true
+	}
+	for _, dir := range strings.Split(value, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			f.goptions.Roots = append(f.goptions.Roots, internal.SourceRoot{Dir: dir})
+		}
+	}
+	return nil
+}
+
+// addExecFlag
+// -exec, for the commands watching the templates.
+func addExecFlag(flags *flag.FlagSet, goptions *internal.BlipOptions) {
+	flags.StringVar(&goptions.Exec, "exec", "", "With -watch, the shell command run after each build without errors, the previous run is stopped first. ex: -exec \"go build -o server . && ./server\"")
+}
+
+// addGenerateFlags
+// Flags changing the generated code, blip check compares with the code generated with the same flags.
+func addGenerateFlags(flags *flag.FlagSet, goptions *internal.BlipOptions) {
+	flags.BoolVar(&goptions.LineDirectives, "lineDirectives", false, "Write //line directives in the generated Go code so compiler errors, panics and coverage report the template file and line.")
+	flags.BoolVar(&goptions.Interpreter, "interpreter", false, "Register the templates for the interpreter, the builds with -tags blipdev render the template files without compiling them again.")
+	flags.StringVar(&goptions.Registry, "registry", "", "Write "+internal.RegistryName+" in each package to render the templates by name: package, or global to write one of all packages in the output directory too")
+	flags.BoolVar(&goptions.RenderLineNumbers, "renderLineNumbers", false, "Render template line numbers in the generated Go code.  defaults false for easier diffing in source control. ex: adding one line will not show all next line numbers as differences ")
+}
+
+// prepareOptions
+// Reports an unknown -format and reads the blip.yaml of the module, the flags that were set take precedence.
+func prepareOptions(flags *flag.FlagSet, goptions *internal.BlipOptions) bool {
+	if err := internal.CheckFormat(goptions.Format); err != nil {
+		fmt.Fprintf(os.Stderr, "blip: %s\n", err)
+		return false
+	}
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if err := internal.LoadConfig(goptions, set); err != nil {
+		fmt.Fprintf(os.Stderr, "blip: %s\n", err)
+		return false
+	}
+	if err := internal.CheckRegistry(goptions.Registry); err != nil {
+		fmt.Fprintf(os.Stderr, "blip: %s\n", err)
+		return false
+	}
+	return true
+}
+
+// check
+// blip check: type checks the generated code and compares it with the files on disk, returns the exit status.
+func check(args []string) int {
+	var goptions = internal.BlipOptions{}
+	var typeCheck, stale bool
+	flags := flag.NewFlagSet("blip check", flag.ExitOnError)
+	addCommonFlags(flags, &goptions)
+	addGenerateFlags(flags, &goptions)
+	flags.BoolVar(&typeCheck, "types", true, "Type check the generated code")
+	flags.BoolVar(&stale, "stale", true, "Compare the generated code with the files on disk, a diff of the out of date files is printed")
+	flags.Parse(args)
 
+	if !prepareOptions(flags, &goptions) {
+		return 2
+	}
+	if internal.GteCheck(&goptions, typeCheck, stale) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// format
+// blip fmt: formats the templates, returns the exit status.
+func format(args []string) int {
+	var goptions = internal.BlipOptions{}
+	var write, diff bool
+	flags := flag.NewFlagSet("blip fmt", flag.ExitOnError)
+	addCommonFlags(flags, &goptions)
+	flags.BoolVar(&write, "w", false, "Write the formatted templates back to the files")
+	flags.BoolVar(&diff, "d", false, "Print a diff of the formatting changes")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of blip fmt [-w] [-d] [paths]:\n%s\n", internal.FormatUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if !prepareOptions(flags, &goptions) {
+		return 2
+	}
+	if internal.GteFormat(&goptions, flags.Args(), write, diff) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// vet
+// blip vet: reports suspicious constructs in the templates, returns the exit status.
+func vet(args []string) int {
+	var goptions = internal.BlipOptions{}
+	flags := flag.NewFlagSet("blip vet", flag.ExitOnError)
+	addCommonFlags(flags, &goptions)
+	flags.Parse(args)
+
+	if !prepareOptions(flags, &goptions) {
+		return 2
+	}
+	if internal.GteVet(&goptions) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// clean
+// blip clean: removes the generated files of removed templates, returns the exit status.
+func clean(args []string) int {
+	var goptions = internal.BlipOptions{}
+	var all bool
+	flags := flag.NewFlagSet("blip clean", flag.ExitOnError)
+	addCommonFlags(flags, &goptions)
+	flags.BoolVar(&all, "all", false, "Remove every generated file and the manifest")
+	flags.Parse(args)
+
+	if !prepareOptions(flags, &goptions) {
+		return 2
+	}
+	if internal.GteClean(&goptions, all) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// dev
+// blip dev: watches the templates and serves the live reload, returns the exit status when it cannot start.
+func dev(args []string) int {
+	var goptions = internal.BlipOptions{}
+	var addr string
+	flags := flag.NewFlagSet("blip dev", flag.ExitOnError)
+	addCommonFlags(flags, &goptions)
+	addGenerateFlags(flags, &goptions)
+	addExecFlag(flags, &goptions)
+	flags.BoolVar(&goptions.Rebuild, "rebuild", false, "rebuild all files")
+	flags.StringVar(&addr, "addr", internal.DefaultDevAddr, "The address of the live reload server, the pages connect to it")
+	flags.Parse(args)
+
+	if !prepareOptions(flags, &goptions) {
+		return 2
+	}
+	if internal.GteDev(&goptions, addr) > 0 {
+		return 1
+	}
+	return 0
 }
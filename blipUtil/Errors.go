@@ -0,0 +1,116 @@
+package blipUtil
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WriteError
+// Returned by a render when the io.Writer fails, ex: the client disconnected.
+// The template itself is fine, the output could not be delivered.
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("blip: write failed: %s", e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// TemplateError
+// Returned by a render when the template code failed, ex: a nil pointer in an @= expression
+// or a missing @context value.
+type TemplateError struct {
+	Template string
+	Err      error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("blip: template %s failed: %s", e.Template, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// IsWriteError
+// Return true if the error was caused by the writer and not by the template.
+func IsWriteError(err error) bool {
+	var we *WriteError
+	return errors.As(err, &we)
+}
+
+// RenderFrame
+// A template location, one entry of the include / extend chain in a RenderError.
+type RenderFrame struct {
+	Template string // The template name, ex: index
+	Source   string // The .blip source file
+	Line     int    // Line in the source file, 0 if not known
+}
+
+func (f RenderFrame) String() string {
+	return fmt.Sprintf("%s (%s:%d)", f.Template, f.Source, f.Line)
+}
+
+// RenderError
+// Returned by generated render functions.
+// Template, Source and Line are where the failure happened,
+// Chain holds the templates that included / extended it, innermost first.
+// The cause is available with errors.Is / errors.As, ex: *WriteError or *TemplateError.
+type RenderError struct {
+	Template string
+	Source   string
+	Line     int
+	Chain    []RenderFrame
+	Err      error
+}
+
+func (e *RenderError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("blip: ")
+	sb.WriteString(e.Frame().String())
+	for _, f := range e.Chain {
+		sb.WriteString(" <- ")
+		sb.WriteString(f.String())
+	}
+	sb.WriteString(": ")
+	sb.WriteString(e.Err.Error())
+	return sb.String()
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// Frame
+// The location where the error happened.
+func (e *RenderError) Frame() RenderFrame {
+	return RenderFrame{Template: e.Template, Source: e.Source, Line: e.Line}
+}
+
+// TemplateInfo
+// Identifies a generated render function, created at the start of each render.
+type TemplateInfo struct {
+	Name   string
+	Source string
+}
+
+// Wrap
+// Attaches the template location to an error.
+// The first template the error passes through becomes the location,
+// each template after that is added to the chain.
+func (ti *TemplateInfo) Wrap(err error, line int) error {
+	if err == nil {
+		return nil
+	}
+	frame := RenderFrame{Template: ti.Name, Source: ti.Source, Line: line}
+	if re, ok := err.(*RenderError); ok {
+		re.Chain = append(re.Chain, frame)
+		return re
+	}
+	return &RenderError{Template: frame.Template, Source: frame.Source, Line: frame.Line, Err: err}
+}
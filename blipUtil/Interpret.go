@@ -0,0 +1,17 @@
+package blipUtil
+
+// TemplateSource
+// A template generated with -interpreter, registered by its generated file.
+// In the builds with -tags blipdev the render function interprets the template file at each call,
+// an edit of the template shows without compiling. The other builds run the generated code.
+type TemplateSource struct {
+	Name     string                 // The template name, ex: index
+	Source   string                 // The template as named in the errors, ex: ./template/pages/index.blip.html
+	File     string                 // The template file, relative to the directory of the generated file
+	Package  string                 // The go package of the generated file
+	FileType string                 // ex: html
+	Escaper  string                 // The escaper of the file type
+	Args     []string               // The @arg names the render function was generated with
+	Imports  []string               // The imports of the generated file, ex: "strings" or h "net/http"
+	Funcs    map[string]interface{} // The functions declared by the @func blocks, by name
+}
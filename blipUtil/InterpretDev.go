@@ -0,0 +1,486 @@
+//go:build blipdev
+// +build blipdev
+
+package blipUtil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samlotti/blip/ast"
+	"github.com/samlotti/blip/compiler"
+)
+
+// Interpreted
+// True in the builds with -tags blipdev, the render functions generated with -interpreter interpret their template.
+const Interpreted = true
+
+// interpTemplate
+// A registered template and its last parsed tree, parsed again when the file changes.
+type interpTemplate struct {
+	render  reflect.Value
+	pkgPath string // The import path of the generated package
+	source  TemplateSource
+	file    string // The template file
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	tree    *ast.Template
+	err     error
+	errLine int
+	parsed  map[string]interface{} // The go expressions and @code of the tree, parsed once
+}
+
+var interpRegistry = struct {
+	sync.RWMutex
+	templates map[string]*interpTemplate // By the qualified name of the render function, ex: example.com/m/blipped/pages.IndexRender
+}{templates: make(map[string]*interpTemplate)}
+
+// renderFuncName
+// The qualified name of the function, ex: example.com/m/blipped/pages.IndexRender
+func renderFuncName(render interface{}) string {
+	fn := reflect.ValueOf(render)
+	if fn.Kind() != reflect.Func {
+		return ""
+	}
+	if f := runtime.FuncForPC(fn.Pointer()); f != nil {
+		return f.Name()
+	}
+	return ""
+}
+
+// RegisterTemplate
+// Registers the render function of a template for the interpreter, called by the init of the generated file.
+// A relative source.File is relative to the directory of the caller, the builds with -trimpath cannot find it.
+func RegisterTemplate(render interface{}, source TemplateSource) {
+	name := renderFuncName(render)
+	if name == "" {
+		panic(fmt.Sprintf("blip: RegisterTemplate of %s: %T is not a function", source.Name, render))
+	}
+	file := filepath.FromSlash(source.File)
+	if !filepath.IsAbs(file) {
+		if _, caller, _, ok := runtime.Caller(1); ok {
+			file = filepath.Join(filepath.Dir(caller), file)
+		}
+	}
+	t := &interpTemplate{
+		render:  reflect.ValueOf(render),
+		pkgPath: name[:strings.LastIndex(name, ".")],
+		source:  source,
+		file:    file,
+	}
+	interpRegistry.Lock()
+	defer interpRegistry.Unlock()
+	interpRegistry.templates[name] = t
+}
+
+func lookupTemplate(name string) *interpTemplate {
+	interpRegistry.RLock()
+	defer interpRegistry.RUnlock()
+	return interpRegistry.templates[name]
+}
+
+// load
+// The tree of the template, parsed again when the file changed.
+func (t *interpTemplate) load() (tree *ast.Template, line int, err error) {
+	fi, err := os.Stat(t.file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("the template cannot be read: %w", err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if (t.tree != nil || t.err != nil) && fi.ModTime().Equal(t.modTime) && fi.Size() == t.size {
+		return t.tree, t.errLine, t.err
+	}
+	src, err := ioutil.ReadFile(t.file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("the template cannot be read: %w", err)
+	}
+	tree, diags := compiler.Parse(bytes.NewReader(src), t.source.Source)
+	t.modTime, t.size, t.parsed = fi.ModTime(), fi.Size(), make(map[string]interface{})
+	t.tree, t.err, t.errLine = tree, nil, 0
+	if len(diags) > 0 {
+		msg := fmt.Sprintf("%s: %s", diags[0].Position(), diags[0].Message)
+		if len(diags) > 1 {
+			msg += fmt.Sprintf(" (and %d more errors)", len(diags)-1)
+		}
+		t.tree, t.err, t.errLine = nil, fmt.Errorf("%s", msg), diags[0].Line
+	}
+	return t.tree, t.errLine, t.err
+}
+
+// cached
+// The parsed form of the source, parse is called once per version of the template.
+func (t *interpTemplate) cached(source string, parse func() (interface{}, error)) (interface{}, error) {
+	t.mu.Lock()
+	parsed, ok := t.parsed[source]
+	t.mu.Unlock()
+	if ok {
+		if err, isErr := parsed.(error); isErr {
+			return nil, err
+		}
+		return parsed, nil
+	}
+	parsed, err := parse()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.parsed[source] = err
+		return nil, err
+	}
+	t.parsed[source] = parsed
+	return parsed, nil
+}
+
+// Interpret
+// Renders the template of the render function from its file, with the args of the call.
+// The errors are returned as by the generated code, at the template line.
+func Interpret(render interface{}, c context.Context, w io.Writer, args ...interface{}) (terror error) {
+	name := renderFuncName(render)
+	t := lookupTemplate(name)
+	if t == nil {
+		return fmt.Errorf("blip: %s is not registered, generate it with -interpreter", name)
+	}
+	start := time.Now()
+	si := Instance()
+	tinfo := si.TemplateInfo(t.source.Name, t.source.Source)
+	escaper := si.GetEscaperFor(t.source.Escaper)
+	in := &interpreter{t: t, si: si, tinfo: tinfo, escaper: escaper, c: c, w: w}
+	defer func() {
+		if err := recover(); err != nil {
+			terror = tinfo.Wrap(si.RecoverError(t.source.Name, err), in.line)
+		}
+		si.RenderComplete(escaper, t.source.Name, t.source.FileType, time.Since(start), terror)
+	}()
+
+	tree, line, err := t.load()
+	if err != nil {
+		return tinfo.Wrap(&TemplateError{Template: t.source.Name, Err: err}, line)
+	}
+	in.tree = tree
+	if err := in.checkArgs(len(args)); err != nil {
+		return tinfo.Wrap(&TemplateError{Template: t.source.Name, Err: err}, 1)
+	}
+
+	sc := newScope(nil)
+	sc.define("c", reflect.ValueOf(&in.c).Elem())
+	sc.define("w", reflect.ValueOf(&in.w).Elem())
+	sc.define("si", reflect.ValueOf(si))
+	sc.define("escaper", reflect.ValueOf(&escaper).Elem())
+	for fname, fn := range t.source.Funcs {
+		sc.define(fname, reflect.ValueOf(fn))
+	}
+	for idx, arg := range tree.Args {
+		sc.define(arg.Name, valueOf(args[idx]))
+	}
+	for _, cv := range tree.Context {
+		in.line = cv.Pos().Line
+		value := c.Value(cv.Name)
+		if value != nil {
+			sc.define(cv.Name, valueOf(value))
+			continue
+		}
+		if cv.Value == "" {
+			return tinfo.Wrap(&TemplateError{Template: t.source.Name, Err: fmt.Errorf("the context has no value %s", cv.Name)}, in.line)
+		}
+		initial, err := in.evalContextValue(cv, sc)
+		if err != nil {
+			return in.fail(err)
+		}
+		sc.define(cv.Name, initial)
+	}
+	return in.execList(tree.Body, sc)
+}
+
+// interpreter
+// The state of a render.
+type interpreter struct {
+	t       *interpTemplate
+	tree    *ast.Template
+	si      *BlipUtil
+	tinfo   *TemplateInfo
+	escaper IBlipEscaper
+	c       context.Context
+	w       io.Writer
+	line    int // The template line being run
+}
+
+// fail
+// An error of the template code at the current line.
+func (in *interpreter) fail(err error) error {
+	return in.tinfo.Wrap(&TemplateError{Template: in.t.source.Name, Err: err}, in.line)
+}
+
+// checkArgs
+// The @arg of the file must be those the render function was generated with.
+func (in *interpreter) checkArgs(count int) error {
+	var names []string
+	for _, arg := range in.tree.Args {
+		names = append(names, arg.Name)
+	}
+	if strings.Join(names, ",") != strings.Join(in.t.source.Args, ",") || count != len(names) {
+		return fmt.Errorf("the @arg changed from (%s) to (%s), run blip to generate the render function again",
+			strings.Join(in.t.source.Args, ", "), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// execList
+// Runs the nodes, the writes and calls that fail return the error at the node line.
+func (in *interpreter) execList(nodes []ast.Node, sc *scope) error {
+	for _, node := range nodes {
+		in.line = node.Pos().Line
+		if err := in.exec(node, sc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *interpreter) exec(node ast.Node, sc *scope) error {
+	switch n := node.(type) {
+	case *ast.Text:
+		return in.tinfo.Wrap(in.si.WriteStr(in.w, n.Text), in.line)
+	case *ast.TextBlock:
+		return in.tinfo.Wrap(in.si.WriteStr(in.w, n.Text), in.line)
+	case *ast.Display:
+		v, err := in.eval(n.Expr, sc)
+		if err != nil {
+			return in.fail(err)
+		}
+		out, err := displayString(n.Kind, v)
+		if err != nil {
+			return in.fail(err)
+		}
+		if n.Kind == ast.DisplayEscaped {
+			return in.tinfo.Wrap(in.si.WriteStrSafe(in.w, out, in.escaper), in.line)
+		}
+		return in.tinfo.Wrap(in.si.WriteStr(in.w, out), in.line)
+	case *ast.Include:
+		return in.call(n.Template, n.Args, in.c, sc)
+	case *ast.Extend:
+		line := in.line
+		ctx := context.WithValue(in.c, "__Blip__", 1)
+		for _, content := range n.Contents {
+			body := content.Body
+			ctx = context.WithValue(ctx, content.Name, func() error {
+				return in.execList(body, newScope(sc))
+			})
+		}
+		in.line = line
+		return in.call(n.Template, n.Args, ctx, sc)
+	case *ast.Yield:
+		return in.tinfo.Wrap(in.si.CallCtxFunc(in.c, n.Name), in.line)
+	case *ast.Code:
+		return in.execCode(n, sc)
+	case *ast.If:
+		cond, err := in.eval(n.Cond, sc)
+		if err != nil {
+			return in.fail(err)
+		}
+		if !cond.IsValid() || cond.Kind() != reflect.Bool {
+			return in.fail(fmt.Errorf("@if %s is not a bool", strings.TrimSpace(n.Cond)))
+		}
+		if cond.Bool() {
+			return in.execList(n.Then, newScope(sc))
+		}
+		return in.execList(n.Else, newScope(sc))
+	case *ast.For:
+		return in.execFor(n, sc)
+	}
+	return in.fail(fmt.Errorf("%T is not supported by the interpreter", node))
+}
+
+// execFor
+// @for name in list, idx is the index as in the generated code.
+func (in *interpreter) execFor(n *ast.For, sc *scope) error {
+	list, err := in.eval(n.Range, sc)
+	if err != nil {
+		return in.fail(err)
+	}
+	line := in.line
+	var failed bool
+	err = rangeOver(list, func(idx reflect.Value, item reflect.Value) error {
+		inner := newScope(sc)
+		inner.define("idx", idx)
+		inner.define(strings.TrimSpace(n.Var), item)
+		err := in.execList(n.Body, inner)
+		in.line = line
+		failed = err != nil
+		return err
+	})
+	if err != nil && !failed {
+		return in.fail(fmt.Errorf("@for: %w", err))
+	}
+	return err
+}
+
+// call
+// @include / @extend: calls the registered render function of the template with the args.
+func (in *interpreter) call(name string, argExprs []string, ctx context.Context, sc *scope) error {
+	fn, err := in.resolve(name)
+	if err != nil {
+		return in.fail(err)
+	}
+	ft := fn.Type()
+	if ft.NumIn()-2 != len(argExprs) {
+		return in.fail(fmt.Errorf("%s expects %d argument(s), found %d", name, ft.NumIn()-2, len(argExprs)))
+	}
+	args := make([]reflect.Value, 0, ft.NumIn())
+	for idx, expr := range argExprs {
+		v, err := in.eval(expr, sc)
+		if err != nil {
+			return in.fail(err)
+		}
+		v, err = assignable(v, ft.In(idx))
+		if err != nil {
+			return in.fail(fmt.Errorf("argument %d of %s: %w", idx+1, name, err))
+		}
+		args = append(args, v)
+	}
+	args = append(args, reflect.ValueOf(&ctx).Elem(), reflect.ValueOf(&in.w).Elem())
+	line := in.line
+	out := fn.Call(args)
+	in.line = line
+	if err, _ := out[0].Interface().(error); err != nil {
+		return in.tinfo.Wrap(err, in.line)
+	}
+	return nil
+}
+
+// resolve
+// The render function of the template, in the package of the template or qualified by an import, ex: layout.base
+func (in *interpreter) resolve(name string) (reflect.Value, error) {
+	pkgPath := in.t.pkgPath
+	funcName := compiler.FunctionName(name)
+	if idx := strings.LastIndex(funcName, "."); idx >= 0 {
+		qualifier := funcName[:idx]
+		funcName = funcName[idx+1:]
+		pkgPath = ""
+		for _, spec := range in.imports() {
+			alias, path := importSpec(spec)
+			if alias == qualifier || (alias == "" && in.isPackage(path, qualifier)) {
+				pkgPath = path
+				break
+			}
+		}
+		if pkgPath == "" {
+			return reflect.Value{}, fmt.Errorf("the package %s of %s is not imported", qualifier, name)
+		}
+	}
+	t := lookupTemplate(pkgPath + "." + funcName)
+	if t == nil {
+		return reflect.Value{}, fmt.Errorf("%s.%s is not registered, generate its package with -interpreter", pkgPath, funcName)
+	}
+	return t.render, nil
+}
+
+// isPackage
+// True when the templates registered with the import path are of the package name.
+func (in *interpreter) isPackage(path string, name string) bool {
+	interpRegistry.RLock()
+	defer interpRegistry.RUnlock()
+	for _, t := range interpRegistry.templates {
+		if t.pkgPath == path && t.source.Package == name {
+			return true
+		}
+	}
+	return false
+}
+
+// imports
+// The @import of the template, which may have changed since it was generated, and those of the generated file.
+func (in *interpreter) imports() []string {
+	var specs []string
+	for _, imp := range in.tree.Imports {
+		specs = append(specs, imp.Spec)
+	}
+	return append(specs, in.t.source.Imports...)
+}
+
+// importSpec
+// The name and path of an import spec, ex: h "net/http". The name is empty when not given.
+func importSpec(spec string) (string, string) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	path, err := strconv.Unquote(fields[len(fields)-1])
+	if err != nil {
+		path = strings.Trim(fields[len(fields)-1], "\"`")
+	}
+	if len(fields) > 1 {
+		return fields[0], path
+	}
+	return "", path
+}
+
+// displayString
+// The text written by the display command.
+func displayString(kind ast.DisplayKind, v reflect.Value) (string, error) {
+	switch kind {
+	case ast.DisplayInt, ast.DisplayInt64:
+		switch {
+		case v.IsValid() && isInt(v.Kind()):
+			return strconv.FormatInt(v.Int(), 10), nil
+		case v.IsValid() && isUint(v.Kind()):
+			return strconv.FormatUint(v.Uint(), 10), nil
+		}
+		return "", fmt.Errorf("%s expects an int, found %s", kind, typeName(v))
+	case ast.DisplayBool:
+		if v.IsValid() && v.Kind() == reflect.Bool {
+			return strconv.FormatBool(v.Bool()), nil
+		}
+		return "", fmt.Errorf("%s expects a bool, found %s", kind, typeName(v))
+	}
+	if v.IsValid() && v.Kind() == reflect.String {
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("%s expects a string, found %s", kind, typeName(v))
+}
+
+// evalContextValue
+// The initial value of @context name type = value, when the context has none.
+func (in *interpreter) evalContextValue(cv *ast.Context, sc *scope) (reflect.Value, error) {
+	v, err := in.eval(cv.Value, sc)
+	if err != nil {
+		return v, err
+	}
+	typ, err := in.parseTypeString(cv.Type)
+	if err != nil {
+		// A type of another package, the value is used as is
+		return v, nil
+	}
+	return assignable(v, typ)
+}
+
+// execCode
+// @code ... @end, the statements declare their variables in the scope of the block.
+func (in *interpreter) execCode(n *ast.Code, sc *scope) error {
+	parsed, err := in.t.cached("@code\x00"+n.Code, func() (interface{}, error) {
+		return parseStatements(n.Code)
+	})
+	if err != nil {
+		return in.fail(err)
+	}
+	block := parsed.(*codeBlock)
+	for _, stmt := range block.stmts {
+		in.line = n.CodePos.Line + block.fset.Position(stmt.Pos()).Line - codeFirstLine
+		if err := in.execStmt(stmt, sc, block); err != nil {
+			return in.fail(err)
+		}
+	}
+	return nil
+}
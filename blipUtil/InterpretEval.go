@@ -0,0 +1,1419 @@
+//go:build blipdev
+// +build blipdev
+
+package blipUtil
+
+import (
+	"errors"
+	"fmt"
+	goast "go/ast"
+	"go/parser"
+	"go/token"
+	"html"
+	"math"
+	"net/url"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// interpPackages
+// The members of the packages the interpreted templates can call, by import path.
+var interpPackages = struct {
+	sync.RWMutex
+	members map[string]map[string]interface{}
+}{members: map[string]map[string]interface{}{
+	"fmt": {"Sprint": fmt.Sprint, "Sprintf": fmt.Sprintf, "Sprintln": fmt.Sprintln, "Errorf": fmt.Errorf},
+	"strings": {
+		"Contains": strings.Contains, "ContainsAny": strings.ContainsAny, "Count": strings.Count, "EqualFold": strings.EqualFold,
+		"Fields": strings.Fields, "HasPrefix": strings.HasPrefix, "HasSuffix": strings.HasSuffix, "Index": strings.Index,
+		"Join": strings.Join, "LastIndex": strings.LastIndex, "Repeat": strings.Repeat, "Replace": strings.Replace,
+		"ReplaceAll": strings.ReplaceAll, "Split": strings.Split, "Title": strings.Title, "ToLower": strings.ToLower,
+		"ToUpper": strings.ToUpper, "Trim": strings.Trim, "TrimLeft": strings.TrimLeft, "TrimPrefix": strings.TrimPrefix,
+		"TrimRight": strings.TrimRight, "TrimSpace": strings.TrimSpace, "TrimSuffix": strings.TrimSuffix,
+	},
+	"strconv": {
+		"Atoi": strconv.Atoi, "FormatBool": strconv.FormatBool, "FormatFloat": strconv.FormatFloat, "FormatInt": strconv.FormatInt,
+		"Itoa": strconv.Itoa, "ParseFloat": strconv.ParseFloat, "ParseInt": strconv.ParseInt, "Quote": strconv.Quote,
+	},
+	"html":         {"EscapeString": html.EscapeString, "UnescapeString": html.UnescapeString},
+	"net/url":      {"PathEscape": url.PathEscape, "QueryEscape": url.QueryEscape},
+	"unicode/utf8": {"RuneCountInString": utf8.RuneCountInString},
+	"math":         {"Abs": math.Abs, "Ceil": math.Ceil, "Floor": math.Floor, "Max": math.Max, "Min": math.Min, "Round": math.Round},
+	"time": {
+		"Now": time.Now, "Since": time.Since, "Unix": time.Unix, "Nanosecond": time.Nanosecond, "Millisecond": time.Millisecond,
+		"Second": time.Second, "Minute": time.Minute, "Hour": time.Hour, "RFC3339": time.RFC3339, "Kitchen": time.Kitchen,
+	},
+}}
+
+// RegisterPackage
+// The functions and values of a package the templates call, by import path. fmt, strings, strconv, html, net/url,
+// unicode/utf8, math and time are registered with their common functions.
+//
+//	blipUtil.RegisterPackage("example.com/m/format", map[string]interface{}{"Price": format.Price})
+func RegisterPackage(importPath string, members map[string]interface{}) {
+	interpPackages.Lock()
+	defer interpPackages.Unlock()
+	all := interpPackages.members[importPath]
+	if all == nil {
+		all = make(map[string]interface{})
+		interpPackages.members[importPath] = all
+	}
+	for name, member := range members {
+		all[name] = member
+	}
+}
+
+// scope
+// The variables of a block, with those of the enclosing blocks.
+type scope struct {
+	vars   map[string]reflect.Value
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: make(map[string]reflect.Value), parent: parent}
+}
+
+// define
+// A new variable, settable so its fields can be assigned.
+func (sc *scope) define(name string, v reflect.Value) {
+	if name == "_" {
+		return
+	}
+	if v.IsValid() && !v.CanSet() {
+		settable := reflect.New(v.Type()).Elem()
+		settable.Set(v)
+		v = settable
+	}
+	sc.vars[name] = v
+}
+
+func (sc *scope) lookup(name string) (reflect.Value, bool) {
+	for s := sc; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// set
+// Assigns the variable of the scope it was defined in.
+func (sc *scope) set(name string, v reflect.Value) error {
+	for s := sc; s != nil; s = s.parent {
+		current, ok := s.vars[name]
+		if !ok {
+			continue
+		}
+		if !current.IsValid() {
+			s.define(name, v)
+			return nil
+		}
+		v, err := assignable(v, current.Type())
+		if err != nil {
+			return err
+		}
+		current.Set(v)
+		return nil
+	}
+	return fmt.Errorf("undefined: %s", name)
+}
+
+// valueOf
+// The value of an interface{}, invalid for nil.
+func valueOf(value interface{}) reflect.Value {
+	if value == nil {
+		return reflect.Value{}
+	}
+	return reflect.ValueOf(value)
+}
+
+// concrete
+// The value held by an interface, invalid for a nil interface.
+func concrete(v reflect.Value) reflect.Value {
+	for v.IsValid() && v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func isInt(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Int64
+}
+
+func isUint(kind reflect.Kind) bool {
+	return kind >= reflect.Uint && kind <= reflect.Uintptr
+}
+
+func isFloat(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
+}
+
+func isNumber(kind reflect.Kind) bool {
+	return isInt(kind) || isUint(kind) || isFloat(kind)
+}
+
+func canBeNil(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+func typeName(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	return v.Type().String()
+}
+
+// assignable
+// The value as the type, the numbers and strings are converted as the untyped constants of go.
+func assignable(v reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	if !v.IsValid() {
+		if canBeNil(typ.Kind()) {
+			return reflect.Zero(typ), nil
+		}
+		return v, fmt.Errorf("cannot use nil as %s", typ)
+	}
+	if v.Type().AssignableTo(typ) {
+		return v, nil
+	}
+	kind := v.Kind()
+	if (isNumber(kind) && isNumber(typ.Kind())) || (kind == reflect.String && typ.Kind() == reflect.String) {
+		return v.Convert(typ), nil
+	}
+	return v, fmt.Errorf("cannot use %s as %s", v.Type(), typ)
+}
+
+var defaultIntType = reflect.TypeOf(0)
+var defaultStringType = reflect.TypeOf("")
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// basicTypes
+// The predeclared types by name.
+var basicTypes = map[string]reflect.Type{
+	"bool": reflect.TypeOf(false), "string": defaultStringType, "error": errorType,
+	"int": defaultIntType, "int8": reflect.TypeOf(int8(0)), "int16": reflect.TypeOf(int16(0)), "int32": reflect.TypeOf(int32(0)), "int64": reflect.TypeOf(int64(0)),
+	"uint": reflect.TypeOf(uint(0)), "uint8": reflect.TypeOf(uint8(0)), "uint16": reflect.TypeOf(uint16(0)), "uint32": reflect.TypeOf(uint32(0)), "uint64": reflect.TypeOf(uint64(0)),
+	"byte": reflect.TypeOf(byte(0)), "rune": reflect.TypeOf(rune(0)), "float32": reflect.TypeOf(float32(0)), "float64": reflect.TypeOf(float64(0)),
+	"interface{}": reflect.TypeOf((*interface{})(nil)).Elem(),
+}
+
+// parseTypeString
+// The reflect type of a go type, only the predeclared types and the slices, maps and pointers of them are known.
+func (in *interpreter) parseTypeString(src string) (reflect.Type, error) {
+	expr, err := parser.ParseExpr(strings.TrimSpace(src))
+	if err != nil {
+		return nil, err
+	}
+	return parseType(expr)
+}
+
+func parseType(expr goast.Expr) (reflect.Type, error) {
+	switch t := expr.(type) {
+	case *goast.Ident:
+		if typ, ok := basicTypes[t.Name]; ok {
+			return typ, nil
+		}
+	case *goast.ParenExpr:
+		return parseType(t.X)
+	case *goast.ArrayType:
+		if t.Len == nil {
+			elem, err := parseType(t.Elt)
+			if err != nil {
+				return nil, err
+			}
+			return reflect.SliceOf(elem), nil
+		}
+	case *goast.MapType:
+		key, err := parseType(t.Key)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := parseType(t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case *goast.StarExpr:
+		elem, err := parseType(t.X)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.PtrTo(elem), nil
+	case *goast.InterfaceType:
+		if t.Methods == nil || len(t.Methods.List) == 0 {
+			return basicTypes["interface{}"], nil
+		}
+	}
+	return nil, fmt.Errorf("the type %s is not known to the interpreter", exprString(expr))
+}
+
+// isType
+// True when the expression names a type the interpreter knows, and no variable.
+func isType(expr goast.Expr, sc *scope) bool {
+	if ident, ok := expr.(*goast.Ident); ok {
+		if _, shadowed := sc.lookup(ident.Name); shadowed {
+			return false
+		}
+	}
+	_, err := parseType(expr)
+	return err == nil
+}
+
+func exprString(expr goast.Expr) string {
+	switch e := expr.(type) {
+	case *goast.Ident:
+		return e.Name
+	case *goast.SelectorExpr:
+		return exprString(e.X) + "." + e.Sel.Name
+	case *goast.StarExpr:
+		return "*" + exprString(e.X)
+	case *goast.ArrayType:
+		return "[]" + exprString(e.Elt)
+	case *goast.MapType:
+		return "map[" + exprString(e.Key) + "]" + exprString(e.Value)
+	}
+	return fmt.Sprintf("%T", expr)
+}
+
+// eval
+// The value of the go expression, invalid for nil.
+func (in *interpreter) eval(src string, sc *scope) (reflect.Value, error) {
+	parsed, err := in.t.cached(src, func() (interface{}, error) {
+		return parser.ParseExpr(strings.TrimSpace(src))
+	})
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return in.evalExpr(parsed.(goast.Expr), sc)
+}
+
+func (in *interpreter) evalExpr(expr goast.Expr, sc *scope) (reflect.Value, error) {
+	switch e := expr.(type) {
+	case *goast.BasicLit:
+		return basicLit(e)
+	case *goast.Ident:
+		if v, ok := sc.lookup(e.Name); ok {
+			return v, nil
+		}
+		switch e.Name {
+		case "true", "false":
+			return reflect.ValueOf(e.Name == "true"), nil
+		case "nil":
+			return reflect.Value{}, nil
+		}
+		return reflect.Value{}, fmt.Errorf("undefined: %s", e.Name)
+	case *goast.ParenExpr:
+		return in.evalExpr(e.X, sc)
+	case *goast.SelectorExpr:
+		if ident, ok := e.X.(*goast.Ident); ok {
+			if _, isVar := sc.lookup(ident.Name); !isVar {
+				return in.packageMember(ident.Name, e.Sel.Name)
+			}
+		}
+		x, err := in.evalExpr(e.X, sc)
+		if err != nil {
+			return x, err
+		}
+		return selectMember(x, e.Sel.Name)
+	case *goast.IndexExpr:
+		x, err := in.evalExpr(e.X, sc)
+		if err != nil {
+			return x, err
+		}
+		index, err := in.evalExpr(e.Index, sc)
+		if err != nil {
+			return index, err
+		}
+		v, _, err := indexValue(x, index)
+		return v, err
+	case *goast.SliceExpr:
+		return in.evalSlice(e, sc)
+	case *goast.StarExpr:
+		x, err := in.evalExpr(e.X, sc)
+		if err != nil {
+			return x, err
+		}
+		if !x.IsValid() || x.Kind() != reflect.Ptr || x.IsNil() {
+			return reflect.Value{}, fmt.Errorf("invalid indirect of %s", exprString(e.X))
+		}
+		return x.Elem(), nil
+	case *goast.UnaryExpr:
+		return in.evalUnary(e, sc)
+	case *goast.BinaryExpr:
+		return in.evalBinary(e, sc)
+	case *goast.CallExpr:
+		results, err := in.evalCall(e, sc)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if len(results) != 1 {
+			return reflect.Value{}, fmt.Errorf("%s() returns %d values, one is expected", exprString(e.Fun), len(results))
+		}
+		return results[0], nil
+	case *goast.TypeAssertExpr:
+		v, _, err := in.evalTypeAssert(e, sc)
+		return v, err
+	case *goast.CompositeLit:
+		if e.Type == nil {
+			return reflect.Value{}, fmt.Errorf("the composite literal has no type")
+		}
+		typ, err := parseType(e.Type)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return in.evalComposite(typ, e, sc)
+	}
+	return reflect.Value{}, fmt.Errorf("%T is not supported by the interpreter", expr)
+}
+
+// evalComposite
+// The literal of a slice or map type, the type of the elements may be omitted.
+func (in *interpreter) evalComposite(typ reflect.Type, lit *goast.CompositeLit, sc *scope) (reflect.Value, error) {
+	element := func(expr goast.Expr, elemType reflect.Type) (reflect.Value, error) {
+		if inner, ok := expr.(*goast.CompositeLit); ok && inner.Type == nil {
+			return in.evalComposite(elemType, inner, sc)
+		}
+		v, err := in.evalExpr(expr, sc)
+		if err != nil {
+			return v, err
+		}
+		return assignable(v, elemType)
+	}
+	switch typ.Kind() {
+	case reflect.Slice:
+		result := reflect.MakeSlice(typ, 0, len(lit.Elts))
+		for _, expr := range lit.Elts {
+			if _, keyed := expr.(*goast.KeyValueExpr); keyed {
+				return reflect.Value{}, fmt.Errorf("the indexed elements of %s are not supported by the interpreter", typ)
+			}
+			v, err := element(expr, typ.Elem())
+			if err != nil {
+				return v, err
+			}
+			result = reflect.Append(result, v)
+		}
+		return result, nil
+	case reflect.Map:
+		result := reflect.MakeMapWithSize(typ, len(lit.Elts))
+		for _, expr := range lit.Elts {
+			kv, ok := expr.(*goast.KeyValueExpr)
+			if !ok {
+				return reflect.Value{}, fmt.Errorf("missing key in the literal of %s", typ)
+			}
+			key, err := element(kv.Key, typ.Key())
+			if err != nil {
+				return key, err
+			}
+			v, err := element(kv.Value, typ.Elem())
+			if err != nil {
+				return v, err
+			}
+			result.SetMapIndex(key, v)
+		}
+		return result, nil
+	}
+	return reflect.Value{}, fmt.Errorf("the literal of %s is not supported by the interpreter", typ)
+}
+
+// errBreak, errContinue
+// break and continue of the for statements of @code.
+var errBreak = errors.New("break is not in a loop")
+var errContinue = errors.New("continue is not in a loop")
+
+// rangeOver
+// Calls body with the index and value of the slices, arrays and strings, the key and value of the maps.
+// Ranging over nil does nothing.
+func rangeOver(list reflect.Value, body func(key reflect.Value, value reflect.Value) error) error {
+	if list.IsValid() && list.Kind() == reflect.Ptr && !list.IsNil() && list.Elem().Kind() == reflect.Array {
+		list = list.Elem()
+	}
+	if !list.IsValid() {
+		return nil
+	}
+	switch list.Kind() {
+	case reflect.Slice, reflect.Array:
+		for idx := 0; idx < list.Len(); idx++ {
+			if err := body(reflect.ValueOf(idx), concrete(list.Index(idx))); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		for idx, r := range list.String() {
+			if err := body(reflect.ValueOf(idx), reflect.ValueOf(r)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := list.MapRange()
+		for iter.Next() {
+			if err := body(concrete(iter.Key()), concrete(iter.Value())); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot range over %s", list.Type())
+	}
+	return nil
+}
+
+// evalMulti
+// The values of the right side of v, ok := m[key], x, err := f() and v, ok := x.(T)
+func (in *interpreter) evalMulti(expr goast.Expr, count int, sc *scope) ([]reflect.Value, error) {
+	if count == 1 {
+		v, err := in.evalExpr(expr, sc)
+		return []reflect.Value{v}, err
+	}
+	switch e := expr.(type) {
+	case *goast.CallExpr:
+		results, err := in.evalCall(e, sc)
+		if err == nil && len(results) != count {
+			err = fmt.Errorf("%s() returns %d values, %d are expected", exprString(e.Fun), len(results), count)
+		}
+		return results, err
+	case *goast.IndexExpr:
+		x, err := in.evalExpr(e.X, sc)
+		if err != nil {
+			return nil, err
+		}
+		index, err := in.evalExpr(e.Index, sc)
+		if err != nil {
+			return nil, err
+		}
+		v, found, err := indexValue(x, index)
+		return []reflect.Value{v, reflect.ValueOf(found)}, err
+	case *goast.TypeAssertExpr:
+		v, ok, err := in.evalTypeAssert(e, sc)
+		return []reflect.Value{v, reflect.ValueOf(ok)}, err
+	}
+	return nil, fmt.Errorf("%d values are expected from %T", count, expr)
+}
+
+func basicLit(lit *goast.BasicLit) (reflect.Value, error) {
+	switch lit.Kind {
+	case token.INT:
+		n, err := strconv.ParseInt(lit.Value, 0, 64)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(int(n)), nil
+	case token.FLOAT:
+		f, err := strconv.ParseFloat(lit.Value, 64)
+		return reflect.ValueOf(f), err
+	case token.STRING:
+		s, err := strconv.Unquote(lit.Value)
+		return reflect.ValueOf(s), err
+	case token.CHAR:
+		r, _, _, err := strconv.UnquoteChar(lit.Value[1:len(lit.Value)-1], '\'')
+		return reflect.ValueOf(r), err
+	}
+	return reflect.Value{}, fmt.Errorf("the literal %s is not supported by the interpreter", lit.Value)
+}
+
+// packageMember
+// pkg.Name of a package imported by the template.
+func (in *interpreter) packageMember(pkgName string, name string) (reflect.Value, error) {
+	importPath := ""
+	for _, spec := range append(in.imports(), `"fmt"`) {
+		alias, p := importSpec(spec)
+		if alias == pkgName || (alias == "" && path.Base(p) == pkgName) {
+			importPath = p
+			break
+		}
+	}
+	if importPath == "" {
+		return reflect.Value{}, fmt.Errorf("undefined: %s", pkgName)
+	}
+	interpPackages.RLock()
+	defer interpPackages.RUnlock()
+	members, ok := interpPackages.members[importPath]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("the package %s is not known to the interpreter, register it with blipUtil.RegisterPackage", importPath)
+	}
+	member, ok := members[name]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%s.%s is not known to the interpreter, register it with blipUtil.RegisterPackage", importPath, name)
+	}
+	return reflect.ValueOf(member), nil
+}
+
+// selectMember
+// x.name, a method or a field, through the pointers.
+func selectMember(x reflect.Value, name string) (reflect.Value, error) {
+	if !x.IsValid() {
+		return x, fmt.Errorf("nil has no field or method %s", name)
+	}
+	if method := x.MethodByName(name); method.IsValid() {
+		return method, nil
+	}
+	if x.Kind() != reflect.Ptr && x.CanAddr() {
+		if method := x.Addr().MethodByName(name); method.IsValid() {
+			return method, nil
+		}
+	}
+	for x.Kind() == reflect.Ptr {
+		if x.IsNil() {
+			return reflect.Value{}, fmt.Errorf("nil pointer dereference of %s.%s", x.Type(), name)
+		}
+		x = x.Elem()
+	}
+	if x.Kind() == reflect.Struct {
+		if field := x.FieldByName(name); field.IsValid() {
+			return concrete(field), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("%s has no field or method %s", x.Type(), name)
+}
+
+// indexValue
+// x[index], found is false for the key missing in a map.
+func indexValue(x reflect.Value, index reflect.Value) (reflect.Value, bool, error) {
+	if x.IsValid() && x.Kind() == reflect.Ptr && !x.IsNil() && x.Elem().Kind() == reflect.Array {
+		x = x.Elem()
+	}
+	if !x.IsValid() {
+		return x, false, fmt.Errorf("index of nil")
+	}
+	switch x.Kind() {
+	case reflect.Map:
+		key, err := assignable(index, x.Type().Key())
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
+		v := x.MapIndex(key)
+		if !v.IsValid() {
+			return concrete(reflect.Zero(x.Type().Elem())), false, nil
+		}
+		return concrete(v), true, nil
+	case reflect.Slice, reflect.Array, reflect.String:
+		if !index.IsValid() || !(isInt(index.Kind()) || isUint(index.Kind())) {
+			return reflect.Value{}, false, fmt.Errorf("the index of %s must be an int, found %s", x.Type(), typeName(index))
+		}
+		i := int(index.Int())
+		if isUint(index.Kind()) {
+			i = int(index.Uint())
+		}
+		if i < 0 || i >= x.Len() {
+			return reflect.Value{}, false, fmt.Errorf("index out of range [%d] with length %d", i, x.Len())
+		}
+		return concrete(x.Index(i)), true, nil
+	}
+	return reflect.Value{}, false, fmt.Errorf("cannot index %s", x.Type())
+}
+
+func (in *interpreter) evalSlice(e *goast.SliceExpr, sc *scope) (reflect.Value, error) {
+	x, err := in.evalExpr(e.X, sc)
+	if err != nil {
+		return x, err
+	}
+	if e.Slice3 {
+		return reflect.Value{}, fmt.Errorf("3-index slices are not supported by the interpreter")
+	}
+	if x.IsValid() && x.Kind() == reflect.Ptr && !x.IsNil() && x.Elem().Kind() == reflect.Array {
+		x = x.Elem()
+	}
+	if !x.IsValid() || (x.Kind() != reflect.Slice && x.Kind() != reflect.String && !(x.Kind() == reflect.Array && x.CanAddr())) {
+		return reflect.Value{}, fmt.Errorf("cannot slice %s", typeName(x))
+	}
+	bound := func(expr goast.Expr, def int) (int, error) {
+		if expr == nil {
+			return def, nil
+		}
+		v, err := in.evalExpr(expr, sc)
+		if err != nil {
+			return 0, err
+		}
+		if !v.IsValid() || !isInt(v.Kind()) {
+			return 0, fmt.Errorf("the slice index must be an int, found %s", typeName(v))
+		}
+		return int(v.Int()), nil
+	}
+	low, err := bound(e.Low, 0)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	high, err := bound(e.High, x.Len())
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if low < 0 || high < low || high > x.Len() {
+		return reflect.Value{}, fmt.Errorf("slice bounds out of range [%d:%d] with length %d", low, high, x.Len())
+	}
+	return x.Slice(low, high), nil
+}
+
+func (in *interpreter) evalTypeAssert(e *goast.TypeAssertExpr, sc *scope) (reflect.Value, bool, error) {
+	x, err := in.evalExpr(e.X, sc)
+	if err != nil {
+		return x, false, err
+	}
+	typ, err := parseType(e.Type)
+	if err != nil {
+		return reflect.Value{}, false, err
+	}
+	if x.IsValid() && x.Type().AssignableTo(typ) {
+		return x, true, nil
+	}
+	return reflect.Zero(typ), false, nil
+}
+
+func (in *interpreter) evalUnary(e *goast.UnaryExpr, sc *scope) (reflect.Value, error) {
+	x, err := in.evalExpr(e.X, sc)
+	if err != nil {
+		return x, err
+	}
+	switch e.Op {
+	case token.NOT:
+		if x.IsValid() && x.Kind() == reflect.Bool {
+			return reflect.ValueOf(!x.Bool()).Convert(x.Type()), nil
+		}
+	case token.ADD:
+		if x.IsValid() && isNumber(x.Kind()) {
+			return x, nil
+		}
+	case token.SUB:
+		if x.IsValid() && isNumber(x.Kind()) {
+			return arith(token.SUB, reflect.Zero(x.Type()), x)
+		}
+	case token.AND:
+		if x.IsValid() && x.CanAddr() {
+			return x.Addr(), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot take the address of %s", exprString(e.X))
+	}
+	return reflect.Value{}, fmt.Errorf("invalid operation: %s%s", e.Op, typeName(x))
+}
+
+func (in *interpreter) evalBinary(e *goast.BinaryExpr, sc *scope) (reflect.Value, error) {
+	left, err := in.evalExpr(e.X, sc)
+	if err != nil {
+		return left, err
+	}
+	if e.Op == token.LAND || e.Op == token.LOR {
+		if !left.IsValid() || left.Kind() != reflect.Bool {
+			return reflect.Value{}, fmt.Errorf("invalid operation: %s %s needs a bool, found %s", exprString(e.X), e.Op, typeName(left))
+		}
+		if left.Bool() == (e.Op == token.LOR) {
+			return left, nil
+		}
+		right, err := in.evalExpr(e.Y, sc)
+		if err != nil {
+			return right, err
+		}
+		if !right.IsValid() || right.Kind() != reflect.Bool {
+			return reflect.Value{}, fmt.Errorf("invalid operation: %s %s needs a bool, found %s", e.Op, exprString(e.Y), typeName(right))
+		}
+		return right, nil
+	}
+	right, err := in.evalExpr(e.Y, sc)
+	if err != nil {
+		return right, err
+	}
+	switch e.Op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		result, err := compare(e.Op, left, right)
+		return reflect.ValueOf(result), err
+	}
+	return arith(e.Op, left, right)
+}
+
+// resultType
+// The type of an operation, the operand that is not of the default type of a constant wins.
+func resultType(left reflect.Value, right reflect.Value) reflect.Type {
+	lt, rt := left.Type(), right.Type()
+	switch {
+	case lt == rt:
+		return lt
+	case isFloat(lt.Kind()) != isFloat(rt.Kind()):
+		if isFloat(lt.Kind()) {
+			return lt
+		}
+		return rt
+	case lt == defaultIntType || lt == defaultStringType:
+		return rt
+	}
+	return lt
+}
+
+// arith
+// The arithmetic of numbers, + of strings.
+func arith(op token.Token, left reflect.Value, right reflect.Value) (reflect.Value, error) {
+	if !left.IsValid() || !right.IsValid() {
+		return reflect.Value{}, fmt.Errorf("invalid operation: %s %s %s", typeName(left), op, typeName(right))
+	}
+	lk, rk := left.Kind(), right.Kind()
+	switch {
+	case lk == reflect.String && rk == reflect.String && op == token.ADD:
+		return reflect.ValueOf(left.String() + right.String()).Convert(resultType(left, right)), nil
+	case isNumber(lk) && isNumber(rk):
+		typ := resultType(left, right)
+		if isFloat(lk) || isFloat(rk) {
+			a, b := toFloat(left), toFloat(right)
+			var result float64
+			switch op {
+			case token.ADD:
+				result = a + b
+			case token.SUB:
+				result = a - b
+			case token.MUL:
+				result = a * b
+			case token.QUO:
+				result = a / b
+			default:
+				return reflect.Value{}, fmt.Errorf("invalid operation: %s %s %s", left.Type(), op, right.Type())
+			}
+			return reflect.ValueOf(result).Convert(typ), nil
+		}
+		a, b := toInt(left), toInt(right)
+		var result int64
+		switch op {
+		case token.ADD:
+			result = a + b
+		case token.SUB:
+			result = a - b
+		case token.MUL:
+			result = a * b
+		case token.QUO, token.REM:
+			if b == 0 {
+				return reflect.Value{}, fmt.Errorf("integer divide by zero")
+			}
+			if op == token.QUO {
+				result = a / b
+			} else {
+				result = a % b
+			}
+		default:
+			return reflect.Value{}, fmt.Errorf("invalid operation: %s %s %s", left.Type(), op, right.Type())
+		}
+		return reflect.ValueOf(result).Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("invalid operation: %s %s %s", left.Type(), op, right.Type())
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch {
+	case isInt(v.Kind()):
+		return float64(v.Int())
+	case isUint(v.Kind()):
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+func toInt(v reflect.Value) int64 {
+	if isUint(v.Kind()) {
+		return int64(v.Uint())
+	}
+	return v.Int()
+}
+
+// compare
+// The comparison of numbers, strings, bools, nil and the comparable values of the same type.
+func compare(op token.Token, left reflect.Value, right reflect.Value) (bool, error) {
+	ordered := op != token.EQL && op != token.NEQ
+	if !left.IsValid() || !right.IsValid() {
+		other := left
+		if !left.IsValid() {
+			other = right
+		}
+		isNil := !other.IsValid() || (canBeNil(other.Kind()) && other.IsNil())
+		if ordered || (other.IsValid() && !canBeNil(other.Kind())) {
+			return false, fmt.Errorf("invalid operation: %s %s %s", typeName(left), op, typeName(right))
+		}
+		return isNil == (op == token.EQL), nil
+	}
+	lk, rk := left.Kind(), right.Kind()
+	var cmp int
+	switch {
+	case isNumber(lk) && isNumber(rk):
+		if isFloat(lk) || isFloat(rk) {
+			cmp = compareFloat(toFloat(left), toFloat(right))
+		} else {
+			cmp = compareInt(toInt(left), toInt(right))
+		}
+	case lk == reflect.String && rk == reflect.String:
+		cmp = strings.Compare(left.String(), right.String())
+	case lk == reflect.Bool && rk == reflect.Bool && !ordered:
+		if left.Bool() != right.Bool() {
+			cmp = 1
+		}
+	case !ordered && left.Type() == right.Type() && left.Type().Comparable() && left.CanInterface() && right.CanInterface():
+		if left.Interface() != right.Interface() {
+			cmp = 1
+		}
+	default:
+		return false, fmt.Errorf("invalid operation: %s %s %s", left.Type(), op, right.Type())
+	}
+	switch op {
+	case token.EQL:
+		return cmp == 0, nil
+	case token.NEQ:
+		return cmp != 0, nil
+	case token.LSS:
+		return cmp < 0, nil
+	case token.LEQ:
+		return cmp <= 0, nil
+	case token.GTR:
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+func compareInt(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloat(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// evalCall
+// The results of a call: the builtins len, cap, append and make, a conversion, or a function.
+func (in *interpreter) evalCall(e *goast.CallExpr, sc *scope) ([]reflect.Value, error) {
+	if ident, ok := e.Fun.(*goast.Ident); ok {
+		if _, shadowed := sc.lookup(ident.Name); !shadowed {
+			switch ident.Name {
+			case "len", "cap", "append", "make":
+				v, err := in.evalBuiltin(ident.Name, e, sc)
+				return []reflect.Value{v}, err
+			}
+		}
+	}
+	if isType(e.Fun, sc) {
+		typ, _ := parseType(e.Fun)
+		if len(e.Args) != 1 {
+			return nil, fmt.Errorf("the conversion to %s expects one value", typ)
+		}
+		v, err := in.evalExpr(e.Args[0], sc)
+		if err != nil {
+			return nil, err
+		}
+		if !v.IsValid() || !v.Type().ConvertibleTo(typ) || (typ.Kind() == reflect.String && isNumber(v.Kind())) {
+			return nil, fmt.Errorf("cannot convert %s to %s", typeName(v), typ)
+		}
+		return []reflect.Value{v.Convert(typ)}, nil
+	}
+
+	fn, err := in.evalExpr(e.Fun, sc)
+	if err != nil {
+		return nil, err
+	}
+	if !fn.IsValid() || fn.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%s is not a function", exprString(e.Fun))
+	}
+	if fn.IsNil() {
+		return nil, fmt.Errorf("%s is a nil function", exprString(e.Fun))
+	}
+	ft := fn.Type()
+	variadic := ft.IsVariadic() && !e.Ellipsis.IsValid()
+	if (!variadic && len(e.Args) != ft.NumIn()) || (variadic && len(e.Args) < ft.NumIn()-1) {
+		return nil, fmt.Errorf("%s expects %d argument(s), found %d", exprString(e.Fun), ft.NumIn(), len(e.Args))
+	}
+	args := make([]reflect.Value, len(e.Args))
+	for idx, argExpr := range e.Args {
+		v, err := in.evalExpr(argExpr, sc)
+		if err != nil {
+			return nil, err
+		}
+		paramType := ft.In(idx)
+		if variadic && idx >= ft.NumIn()-1 {
+			paramType = ft.In(ft.NumIn() - 1).Elem()
+		}
+		if args[idx], err = assignable(v, paramType); err != nil {
+			return nil, fmt.Errorf("argument %d of %s: %w", idx+1, exprString(e.Fun), err)
+		}
+	}
+	var results []reflect.Value
+	if e.Ellipsis.IsValid() {
+		results = fn.CallSlice(args)
+	} else {
+		results = fn.Call(args)
+	}
+	for idx := range results {
+		results[idx] = concrete(results[idx])
+	}
+	return results, nil
+}
+
+func (in *interpreter) evalBuiltin(name string, e *goast.CallExpr, sc *scope) (reflect.Value, error) {
+	if name == "make" {
+		if len(e.Args) == 0 {
+			return reflect.Value{}, fmt.Errorf("make expects a type")
+		}
+		typ, err := parseType(e.Args[0])
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		var sizes []int
+		for _, arg := range e.Args[1:] {
+			v, err := in.evalExpr(arg, sc)
+			if err != nil {
+				return v, err
+			}
+			if !v.IsValid() || !isInt(v.Kind()) {
+				return reflect.Value{}, fmt.Errorf("the size of make must be an int, found %s", typeName(v))
+			}
+			sizes = append(sizes, int(v.Int()))
+		}
+		switch typ.Kind() {
+		case reflect.Slice:
+			if len(sizes) == 0 {
+				return reflect.Value{}, fmt.Errorf("make(%s) expects a length", typ)
+			}
+			capacity := sizes[0]
+			if len(sizes) > 1 {
+				capacity = sizes[1]
+			}
+			return reflect.MakeSlice(typ, sizes[0], capacity), nil
+		case reflect.Map:
+			return reflect.MakeMap(typ), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot make %s", typ)
+	}
+
+	var args []reflect.Value
+	for _, arg := range e.Args {
+		v, err := in.evalExpr(arg, sc)
+		if err != nil {
+			return v, err
+		}
+		args = append(args, v)
+	}
+	if name == "append" {
+		if len(args) == 0 {
+			return reflect.Value{}, fmt.Errorf("append expects a slice")
+		}
+		slice := args[0]
+		if !slice.IsValid() {
+			if len(args) < 2 || !args[1].IsValid() || e.Ellipsis.IsValid() {
+				return reflect.Value{}, fmt.Errorf("append to an untyped nil")
+			}
+			slice = reflect.Zero(reflect.SliceOf(args[1].Type()))
+		}
+		if slice.Kind() != reflect.Slice {
+			return reflect.Value{}, fmt.Errorf("append expects a slice, found %s", slice.Type())
+		}
+		if e.Ellipsis.IsValid() {
+			if len(args) != 2 {
+				return reflect.Value{}, fmt.Errorf("append with ... expects two values")
+			}
+			return reflect.AppendSlice(slice, args[1]), nil
+		}
+		for _, v := range args[1:] {
+			v, err := assignable(v, slice.Type().Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			slice = reflect.Append(slice, v)
+		}
+		return slice, nil
+	}
+
+	if len(args) != 1 {
+		return reflect.Value{}, fmt.Errorf("%s expects one value", name)
+	}
+	x := args[0]
+	if !x.IsValid() {
+		return reflect.ValueOf(0), nil
+	}
+	if x.Kind() == reflect.Ptr && !x.IsNil() && x.Elem().Kind() == reflect.Array {
+		x = x.Elem()
+	}
+	switch x.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Chan:
+		if name == "cap" {
+			return reflect.ValueOf(x.Cap()), nil
+		}
+		return reflect.ValueOf(x.Len()), nil
+	case reflect.String, reflect.Map:
+		if name == "len" {
+			return reflect.ValueOf(x.Len()), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("invalid argument %s for %s", x.Type(), name)
+}
+
+// codeFirstLine
+// The line of the first statement of a @code block in the source parsed.
+const codeFirstLine = 3
+
+// codeBlock
+// The parsed statements of a @code block.
+type codeBlock struct {
+	fset  *token.FileSet
+	stmts []goast.Stmt
+}
+
+func parseStatements(code string) (*codeBlock, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", "package p\nfunc _() {\n"+code+"\n}\n", 0)
+	if err != nil {
+		return nil, err
+	}
+	body := file.Decls[0].(*goast.FuncDecl).Body
+	return &codeBlock{fset: fset, stmts: body.List}, nil
+}
+
+// execStmt
+// The statements of @code: assignments, declarations, calls, ++ and --, if, for, range and blocks.
+func (in *interpreter) execStmt(stmt goast.Stmt, sc *scope, block *codeBlock) error {
+	switch s := stmt.(type) {
+	case *goast.AssignStmt:
+		return in.execAssign(s, sc)
+	case *goast.IncDecStmt:
+		v, err := in.evalExpr(s.X, sc)
+		if err != nil {
+			return err
+		}
+		op := token.ADD
+		if s.Tok == token.DEC {
+			op = token.SUB
+		}
+		if !v.IsValid() || !isNumber(v.Kind()) {
+			return fmt.Errorf("invalid operation: %s%s", exprString(s.X), s.Tok)
+		}
+		next, err := arith(op, v, reflect.ValueOf(1))
+		if err != nil {
+			return err
+		}
+		return in.assign(s.X, next, sc)
+	case *goast.ExprStmt:
+		if call, ok := s.X.(*goast.CallExpr); ok {
+			_, err := in.evalCall(call, sc)
+			return err
+		}
+		return fmt.Errorf("%s is evaluated but not used", exprString(s.X))
+	case *goast.DeclStmt:
+		decl, ok := s.Decl.(*goast.GenDecl)
+		if !ok || decl.Tok != token.VAR {
+			return fmt.Errorf("only var declarations are supported by the interpreter")
+		}
+		for _, spec := range decl.Specs {
+			if err := in.execVarSpec(spec.(*goast.ValueSpec), sc); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *goast.BlockStmt:
+		inner := newScope(sc)
+		for _, child := range s.List {
+			if err := in.execStmt(child, inner, block); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *goast.IfStmt:
+		inner := newScope(sc)
+		if s.Init != nil {
+			if err := in.execStmt(s.Init, inner, block); err != nil {
+				return err
+			}
+		}
+		cond, err := in.evalExpr(s.Cond, inner)
+		if err != nil {
+			return err
+		}
+		if !cond.IsValid() || cond.Kind() != reflect.Bool {
+			return fmt.Errorf("the if condition is not a bool")
+		}
+		if cond.Bool() {
+			return in.execStmt(s.Body, inner, block)
+		}
+		if s.Else != nil {
+			return in.execStmt(s.Else, inner, block)
+		}
+		return nil
+	case *goast.RangeStmt:
+		list, err := in.evalExpr(s.X, sc)
+		if err != nil {
+			return err
+		}
+		err = rangeOver(list, func(key reflect.Value, value reflect.Value) error {
+			inner := newScope(sc)
+			for _, v := range []struct {
+				expr  goast.Expr
+				value reflect.Value
+			}{{s.Key, key}, {s.Value, value}} {
+				if v.expr == nil {
+					continue
+				}
+				if s.Tok == token.DEFINE {
+					inner.define(v.expr.(*goast.Ident).Name, v.value)
+				} else if err := in.assign(v.expr, v.value, inner); err != nil {
+					return err
+				}
+			}
+			return loopBody(in.execStmt(s.Body, inner, block))
+		})
+		if err == errBreak {
+			return nil
+		}
+		return err
+	case *goast.ForStmt:
+		outer := newScope(sc)
+		if s.Init != nil {
+			if err := in.execStmt(s.Init, outer, block); err != nil {
+				return err
+			}
+		}
+		for {
+			if s.Cond != nil {
+				cond, err := in.evalExpr(s.Cond, outer)
+				if err != nil {
+					return err
+				}
+				if !cond.IsValid() || cond.Kind() != reflect.Bool {
+					return fmt.Errorf("the for condition is not a bool")
+				}
+				if !cond.Bool() {
+					return nil
+				}
+			}
+			if err := loopBody(in.execStmt(s.Body, newScope(outer), block)); err == errBreak {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if s.Post != nil {
+				if err := in.execStmt(s.Post, outer, block); err != nil {
+					return err
+				}
+			}
+		}
+	case *goast.BranchStmt:
+		if s.Label != nil {
+			return fmt.Errorf("the labels are not supported by the interpreter")
+		}
+		switch s.Tok {
+		case token.BREAK:
+			return errBreak
+		case token.CONTINUE:
+			return errContinue
+		}
+	case *goast.EmptyStmt:
+		return nil
+	}
+	return fmt.Errorf("%T is not supported by the interpreter", stmt)
+}
+
+// loopBody
+// The error of an iteration, the next iteration follows a continue.
+func loopBody(err error) error {
+	if err == errContinue {
+		return nil
+	}
+	return err
+}
+
+func (in *interpreter) execVarSpec(spec *goast.ValueSpec, sc *scope) error {
+	var typ reflect.Type
+	if spec.Type != nil {
+		var err error
+		if typ, err = parseType(spec.Type); err != nil {
+			return err
+		}
+	}
+	var values []reflect.Value
+	if len(spec.Values) == 1 && len(spec.Names) > 1 {
+		var err error
+		if values, err = in.evalMulti(spec.Values[0], len(spec.Names), sc); err != nil {
+			return err
+		}
+	} else {
+		for _, expr := range spec.Values {
+			v, err := in.evalExpr(expr, sc)
+			if err != nil {
+				return err
+			}
+			values = append(values, v)
+		}
+	}
+	for idx, name := range spec.Names {
+		var v reflect.Value
+		if idx < len(values) {
+			v = values[idx]
+		}
+		if typ != nil {
+			if !v.IsValid() && idx >= len(values) {
+				v = reflect.Zero(typ)
+			}
+			var err error
+			if v, err = assignable(v, typ); err != nil {
+				return err
+			}
+		}
+		sc.define(name.Name, v)
+	}
+	return nil
+}
+
+func (in *interpreter) execAssign(s *goast.AssignStmt, sc *scope) error {
+	var values []reflect.Value
+	if len(s.Rhs) == 1 && len(s.Lhs) > 1 {
+		var err error
+		if values, err = in.evalMulti(s.Rhs[0], len(s.Lhs), sc); err != nil {
+			return err
+		}
+	} else {
+		if len(s.Lhs) != len(s.Rhs) {
+			return fmt.Errorf("assignment mismatch: %d variables but %d values", len(s.Lhs), len(s.Rhs))
+		}
+		for _, expr := range s.Rhs {
+			v, err := in.evalExpr(expr, sc)
+			if err != nil {
+				return err
+			}
+			values = append(values, v)
+		}
+	}
+
+	switch s.Tok {
+	case token.DEFINE:
+		for idx, lhs := range s.Lhs {
+			ident, ok := lhs.(*goast.Ident)
+			if !ok {
+				return fmt.Errorf("non-name %s on the left side of :=", exprString(lhs))
+			}
+			if _, declared := sc.vars[ident.Name]; declared {
+				if err := sc.set(ident.Name, values[idx]); err != nil {
+					return err
+				}
+				continue
+			}
+			sc.define(ident.Name, values[idx])
+		}
+		return nil
+	case token.ASSIGN:
+		for idx, lhs := range s.Lhs {
+			if err := in.assign(lhs, values[idx], sc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// x op= y
+	ops := map[token.Token]token.Token{token.ADD_ASSIGN: token.ADD, token.SUB_ASSIGN: token.SUB, token.MUL_ASSIGN: token.MUL,
+		token.QUO_ASSIGN: token.QUO, token.REM_ASSIGN: token.REM}
+	op, ok := ops[s.Tok]
+	if !ok || len(s.Lhs) != 1 {
+		return fmt.Errorf("%s is not supported by the interpreter", s.Tok)
+	}
+	current, err := in.evalExpr(s.Lhs[0], sc)
+	if err != nil {
+		return err
+	}
+	next, err := arith(op, current, values[0])
+	if err != nil {
+		return err
+	}
+	return in.assign(s.Lhs[0], next, sc)
+}
+
+// assign
+// Sets a variable, a map entry, an element of a slice or a field.
+func (in *interpreter) assign(lhs goast.Expr, v reflect.Value, sc *scope) error {
+	switch target := lhs.(type) {
+	case *goast.Ident:
+		if target.Name == "_" {
+			return nil
+		}
+		return sc.set(target.Name, v)
+	case *goast.ParenExpr:
+		return in.assign(target.X, v, sc)
+	case *goast.IndexExpr:
+		x, err := in.evalExpr(target.X, sc)
+		if err != nil {
+			return err
+		}
+		if x.IsValid() && x.Kind() == reflect.Map {
+			if x.IsNil() {
+				return fmt.Errorf("assignment to entry in nil map")
+			}
+			index, err := in.evalExpr(target.Index, sc)
+			if err != nil {
+				return err
+			}
+			key, err := assignable(index, x.Type().Key())
+			if err != nil {
+				return err
+			}
+			if v, err = assignable(v, x.Type().Elem()); err != nil {
+				return err
+			}
+			x.SetMapIndex(key, v)
+			return nil
+		}
+		if x.IsValid() && x.Kind() == reflect.Ptr && !x.IsNil() {
+			x = x.Elem()
+		}
+		if !x.IsValid() || (x.Kind() != reflect.Slice && x.Kind() != reflect.Array) {
+			return fmt.Errorf("cannot assign to %s", exprString(lhs))
+		}
+		index, err := in.evalExpr(target.Index, sc)
+		if err != nil {
+			return err
+		}
+		if !index.IsValid() || !isInt(index.Kind()) || index.Int() < 0 || int(index.Int()) >= x.Len() {
+			return fmt.Errorf("invalid index of %s", exprString(lhs))
+		}
+		return setValue(x.Index(int(index.Int())), v, lhs)
+	case *goast.SelectorExpr:
+		x, err := in.evalExpr(target.X, sc)
+		if err != nil {
+			return err
+		}
+		for x.IsValid() && x.Kind() == reflect.Ptr && !x.IsNil() {
+			x = x.Elem()
+		}
+		if !x.IsValid() || x.Kind() != reflect.Struct {
+			return fmt.Errorf("cannot assign to %s", exprString(lhs))
+		}
+		return setValue(x.FieldByName(target.Sel.Name), v, lhs)
+	case *goast.StarExpr:
+		x, err := in.evalExpr(target.X, sc)
+		if err != nil {
+			return err
+		}
+		if !x.IsValid() || x.Kind() != reflect.Ptr || x.IsNil() {
+			return fmt.Errorf("invalid indirect of %s", exprString(target.X))
+		}
+		return setValue(x.Elem(), v, lhs)
+	}
+	return fmt.Errorf("cannot assign to %s", exprString(lhs))
+}
+
+func setValue(target reflect.Value, v reflect.Value, lhs goast.Expr) error {
+	if !target.IsValid() || !target.CanSet() {
+		return fmt.Errorf("cannot assign to %s", exprString(lhs))
+	}
+	v, err := assignable(v, target.Type())
+	if err != nil {
+		return err
+	}
+	target.Set(v)
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build !blipdev
+// +build !blipdev
+
+package blipUtil
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Interpreted
+// True in the builds with -tags blipdev, the render functions generated with -interpreter interpret their template.
+const Interpreted = false
+
+// RegisterTemplate
+// Registers the render function of a template for the interpreter, nothing without -tags blipdev.
+func RegisterTemplate(render interface{}, source TemplateSource) {
+}
+
+// RegisterPackage
+// The functions and values of a package the templates call, by import path, nothing without -tags blipdev.
+func RegisterPackage(importPath string, members map[string]interface{}) {
+}
+
+// Interpret
+// Renders the template of the render function, only in the builds with -tags blipdev.
+func Interpret(render interface{}, c context.Context, w io.Writer, args ...interface{}) error {
+	return errors.New("blip: the interpreter is only in the builds with -tags blipdev")
+}
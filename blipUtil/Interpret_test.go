@@ -0,0 +1,151 @@
+//go:build blipdev
+// +build blipdev
+
+package blipUtil
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func CardRender(title string, c context.Context, w io.Writer) (terror error) {
+	return Interpret(CardRender, c, w, title)
+}
+
+func LayoutRender(title string, c context.Context, w io.Writer) (terror error) {
+	return Interpret(LayoutRender, c, w, title)
+}
+
+func PageRender(items []string, n int, c context.Context, w io.Writer) (terror error) {
+	return Interpret(PageRender, c, w, items, n)
+}
+
+func PairRender(first, last string, c context.Context, w io.Writer) (terror error) {
+	return Interpret(PairRender, c, w, first, last)
+}
+
+// registerTemplates
+// Writes the templates in a directory and registers them as the generated files do.
+func registerTemplates(t *testing.T, page string) string {
+	dir := t.TempDir()
+	templates := map[string]string{
+		"card":   "@arg title string\n<b>@= title @</b>\n",
+		"layout": "@arg title string\n<title>@= title @</title>\n@yield body\n",
+		"page":   page,
+	}
+	renders := map[string]interface{}{"card": CardRender, "layout": LayoutRender, "page": PageRender}
+	args := map[string][]string{"card": {"title"}, "layout": {"title"}, "page": {"items", "n"}}
+	for name, src := range templates {
+		file := filepath.Join(dir, name+".blip.html")
+		assert.Nil(t, ioutil.WriteFile(file, []byte(src), 0644))
+		RegisterTemplate(renders[name], TemplateSource{
+			Name: name, Source: name + ".blip.html", File: file,
+			Package: "blipUtil", FileType: "html", Escaper: "html",
+			Args:    args[name],
+			Imports: []string{`"context"`, `"io"`},
+		})
+	}
+	return filepath.Join(dir, "page.blip.html")
+}
+
+func render(t *testing.T, items []string, n int) (string, error) {
+	var out bytes.Buffer
+	err := PageRender(items, n, context.Background(), &out)
+	return out.String(), err
+}
+
+func TestInterpret(t *testing.T) {
+	page := registerTemplates(t, `@import "strings"
+@arg items []string
+@arg n int
+@code
+total := 0
+for _, s := range items {
+	if s == "skip" {
+		continue
+	}
+	total += len(s)
+}
+counts := map[string]int{"a": 1}
+counts["b"] = counts["a"] + 1
+@end
+@extend layout strings.ToUpper(items[0])
+@content body
+@for item in items
+@include card item + "!"
+@end
+@int= total * n @ @int= counts["b"] @ @bool= len(items) > 2 && total > 0 @ @= "<" @ @== "<" @
+@end
+@end
+`)
+	out, err := render(t, []string{"a", "bb", "skip"}, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "\n<title>A</title>\n<b>a!</b>\n<b>bb!</b>\n<b>skip!</b>\n\n30 2 true &lt; <\n\n", out)
+
+	// The edits show at the next render
+	assert.Nil(t, ioutil.WriteFile(page, []byte("@arg items []string\n@arg n int\nitems: @= strings.Join(items, \",\") @\n"), 0644))
+	out, err = render(t, []string{"a", "bb"}, 1)
+	assert.NotNil(t, err)
+	assert.Equal(t, "blip: page (page.blip.html:3): blip: template page failed: undefined: strings", err.Error())
+
+	assert.Nil(t, ioutil.WriteFile(page, []byte("@import \"strings\"\n@arg items []string\n@arg n int\nitems: @= strings.Join(items, \",\") @\n"), 0644))
+	out, err = render(t, []string{"a", "bb"}, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "items: a,bb\n", out)
+}
+
+func TestInterpretErrors(t *testing.T) {
+	page := registerTemplates(t, "@arg items []string\n@arg n int\n@if n\n@end\n")
+	_, err := render(t, nil, 1)
+	assert.Equal(t, "blip: page (page.blip.html:3): blip: template page failed: @if n is not a bool", err.Error())
+
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"@arg items []string\n@arg n int\n@= n @\n", "page.blip.html:3): blip: template page failed: @= expects a string, found int"},
+		{"@arg items []string\n@arg n int\n@= items[n] @\n", "page.blip.html:3): blip: template page failed: index out of range [1] with length 0"},
+		{"@arg items []string\n@arg n int\n@include card n\n", "page.blip.html:3): blip: template page failed: argument 1 of card: cannot use int as string"},
+		{"@arg items []string\n@arg n int\n@code\nx := 1\ny := x + \"a\"\n@end\n", "page.blip.html:5): blip: template page failed: invalid operation: int + string"},
+		{"@arg items []string\n@arg n int\n@if n >\n", "page.blip.html:3): blip: template page failed: page.blip.html:3:1: @if : block is not closed, expected @end (and 1 more errors)"},
+		{"@arg items []string\n@arg count int\n", "page.blip.html:1): blip: template page failed: the @arg changed from (items, n) to (items, count), run blip to generate the render function again"},
+	}
+	for _, test := range tests {
+		assert.Nil(t, ioutil.WriteFile(page, []byte(test.src), 0644))
+		_, err := render(t, nil, 1)
+		if assert.NotNil(t, err, test.src) {
+			assert.True(t, strings.HasSuffix(err.Error(), test.err), err.Error())
+		}
+	}
+}
+
+func TestRegisterPackage(t *testing.T) {
+	RegisterPackage("example.com/format", map[string]interface{}{
+		"Price": func(cents int) string { return "$" + strings.Repeat("9", cents) },
+	})
+	registerTemplates(t, "@import f \"example.com/format\"\n@arg items []string\n@arg n int\n@= f.Price(n) @ @= fmt.Sprintf(\"%03d\", n) @\n")
+	out, err := render(t, nil, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "$99 002\n", out)
+}
+
+func TestInterpretArgNames(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pair.blip.html")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("@arg first, last string\n@= first @ @= last @\n"), 0644))
+	RegisterTemplate(PairRender, TemplateSource{
+		Name: "pair", Source: "pair.blip.html", File: file,
+		Package: "blipUtil", FileType: "html", Escaper: "html",
+		Args:    []string{"first", "last"},
+		Imports: []string{`"context"`, `"io"`},
+	})
+	var out bytes.Buffer
+	assert.Nil(t, PairRender("a", "b", context.Background(), &out))
+	assert.Equal(t, "a b\n", out.String())
+}
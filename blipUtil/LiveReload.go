@@ -0,0 +1,27 @@
+package blipUtil
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strings"
+)
+
+// DevEnv
+// The environment variable naming the blip dev server, ex: http://localhost:35729.
+// blip dev sets it for the -exec command, it is not set in the production builds.
+const DevEnv = "BLIP_DEV"
+
+// LiveReload
+// The script reloading the page when blip dev rebuilds the templates, and showing the template errors over the page.
+// Empty unless the application was started by blip dev, or with BLIP_DEV set.
+// In the <head> of the html layouts:
+//
+//	@== blipUtil.LiveReload() @
+func LiveReload() string {
+	server := strings.TrimSuffix(os.Getenv(DevEnv), "/")
+	if server == "" {
+		return ""
+	}
+	return fmt.Sprintf(`<script src="%s/blip/reload.js"></script>`, html.EscapeString(server))
+}
@@ -0,0 +1,168 @@
+package blipUtil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// ErrTemplateNotFound
+// Returned by Registry.Render for a name that is not in the registry.
+var ErrTemplateNotFound = errors.New("blip: template not found")
+
+// ArgError
+// Returned by Registry.Render when the args or the context do not match the template, nothing is rendered.
+type ArgError struct {
+	Template string
+	Arg      string
+	Msg      string
+}
+
+func (e *ArgError) Error() string {
+	return fmt.Sprintf("blip: template %s: %s", e.Template, e.Msg)
+}
+
+// TemplateVar
+// An @arg or @context of a template, the type as written in the template.
+type TemplateVar struct {
+	Name    string
+	Type    string
+	Default bool // A @context with an initial value, the context may not have it
+}
+
+// TemplateMeta
+// A template of a generated registry.
+type TemplateMeta struct {
+	Name     string // ex: index
+	Package  string // The go package of the render function
+	FileType string // ex: html
+	Source   string // The template file
+	Args     []TemplateVar
+	Context  []TemplateVar
+	Yields   []string    // The names of the @yield, sorted
+	Render   interface{} // The render function, ex: IndexRender
+}
+
+// Registry
+// The templates of the generated packages by name, to render a template named at runtime, ex: by a CMS.
+// blip -registry writes the registry of each package in blip_registry.go:
+//
+//	err := pages.Render("index", map[string]interface{}{"title": "Home"}, ctx, w)
+type Registry struct {
+	templates map[string]*TemplateMeta
+}
+
+// NewRegistry
+// The registry of the templates by their name.
+func NewRegistry(templates ...*TemplateMeta) *Registry {
+	r := &Registry{templates: make(map[string]*TemplateMeta)}
+	for _, meta := range templates {
+		r.templates[meta.Name] = meta
+	}
+	return r
+}
+
+// MergeRegistries
+// A registry of the templates of the registries, named by the key of their registry, ex: pages.index
+func MergeRegistries(registries map[string]*Registry) *Registry {
+	r := &Registry{templates: make(map[string]*TemplateMeta)}
+	for prefix, other := range registries {
+		for name, meta := range other.templates {
+			r.templates[prefix+"."+name] = meta
+		}
+	}
+	return r
+}
+
+// Lookup
+// The template of the name.
+func (r *Registry) Lookup(name string) (*TemplateMeta, bool) {
+	meta, ok := r.templates[name]
+	return meta, ok
+}
+
+// Names
+// The names of the templates, sorted.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render
+// Renders the template of the name, args are its @arg by name.
+// A missing, unknown or mistyped arg and a missing @context value return an *ArgError, an unknown name ErrTemplateNotFound.
+func (r *Registry) Render(name string, args map[string]interface{}, c context.Context, w io.Writer) error {
+	meta, ok := r.Lookup(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	in, err := meta.callArgs(args, c)
+	if err != nil {
+		return err
+	}
+	in = append(in, reflect.ValueOf(&c).Elem(), reflect.ValueOf(&w).Elem())
+	out := reflect.ValueOf(meta.Render).Call(in)
+	err, _ = out[0].Interface().(error)
+	return err
+}
+
+// callArgs
+// The args of the render function, in the order of the @arg.
+func (meta *TemplateMeta) callArgs(args map[string]interface{}, c context.Context) ([]reflect.Value, error) {
+	fail := func(arg string, format string, a ...interface{}) error {
+		return &ArgError{Template: meta.Name, Arg: arg, Msg: fmt.Sprintf(format, a...)}
+	}
+	fn := reflect.ValueOf(meta.Render)
+	if fn.Kind() != reflect.Func || fn.Type().NumIn() != len(meta.Args)+2 {
+		return nil, fail("", "the render function %T does not match the @arg, run blip", meta.Render)
+	}
+
+	declared := make(map[string]bool)
+	in := make([]reflect.Value, 0, len(meta.Args)+2)
+	for idx, arg := range meta.Args {
+		declared[arg.Name] = true
+		typ := fn.Type().In(idx)
+		value, ok := args[arg.Name]
+		if !ok {
+			return nil, fail(arg.Name, "missing @arg %s %s", arg.Name, arg.Type)
+		}
+		if value == nil {
+			switch typ.Kind() {
+			case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+				in = append(in, reflect.Zero(typ))
+				continue
+			}
+			return nil, fail(arg.Name, "@arg %s is nil, not %s", arg.Name, arg.Type)
+		}
+		v := reflect.ValueOf(value)
+		if !v.Type().AssignableTo(typ) {
+			return nil, fail(arg.Name, "@arg %s is %T, not %s", arg.Name, value, arg.Type)
+		}
+		in = append(in, v)
+	}
+
+	var unknown []string
+	for name := range args {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fail(unknown[0], "unknown @arg %s", unknown[0])
+	}
+
+	for _, cv := range meta.Context {
+		if !cv.Default && (c == nil || c.Value(cv.Name) == nil) {
+			return nil, fail(cv.Name, "missing @context %s %s", cv.Name, cv.Type)
+		}
+	}
+	return in, nil
+}
@@ -0,0 +1,60 @@
+package blipUtil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func greetRender(name string, tags []string, c context.Context, w io.Writer) (terror error) {
+	_, terror = io.WriteString(w, c.Value("greeting").(string)+" "+name)
+	return
+}
+
+func TestRegistry(t *testing.T) {
+	greet := &TemplateMeta{
+		Name: "greet", Package: "pages", FileType: "html",
+		Args:    []TemplateVar{{Name: "name", Type: "string"}, {Name: "tags", Type: "[]string"}},
+		Context: []TemplateVar{{Name: "greeting", Type: "string"}, {Name: "lang", Type: "string", Default: true}},
+		Render:  greetRender,
+	}
+	pages := NewRegistry(greet)
+	all := MergeRegistries(map[string]*Registry{"pages": pages})
+	assert.Equal(t, []string{"pages.greet"}, all.Names())
+	meta, ok := all.Lookup("pages.greet")
+	assert.True(t, ok)
+	assert.Equal(t, greet, meta)
+
+	ctx := context.WithValue(context.Background(), "greeting", "hello")
+	var out bytes.Buffer
+	assert.Nil(t, all.Render("pages.greet", map[string]interface{}{"name": "bob", "tags": nil}, ctx, &out))
+	assert.Equal(t, "hello bob", out.String())
+
+	err := pages.Render("pages.greet", nil, ctx, &out)
+	assert.True(t, errors.Is(err, ErrTemplateNotFound))
+	assert.EqualError(t, err, "blip: template not found: pages.greet")
+
+	tests := []struct {
+		args map[string]interface{}
+		c    context.Context
+		err  string
+	}{
+		{map[string]interface{}{"tags": nil}, ctx, "blip: template greet: missing @arg name string"},
+		{map[string]interface{}{"name": 1, "tags": nil}, ctx, "blip: template greet: @arg name is int, not string"},
+		{map[string]interface{}{"name": nil, "tags": nil}, ctx, "blip: template greet: @arg name is nil, not string"},
+		{map[string]interface{}{"name": "bob", "tags": nil, "title": ""}, ctx, "blip: template greet: unknown @arg title"},
+		{map[string]interface{}{"name": "bob", "tags": nil}, context.Background(), "blip: template greet: missing @context greeting string"},
+	}
+	for _, test := range tests {
+		out.Reset()
+		err := pages.Render("greet", test.args, test.c, &out)
+		assert.EqualError(t, err, test.err)
+		var argErr *ArgError
+		assert.True(t, errors.As(err, &argErr))
+		assert.Equal(t, "", out.String())
+	}
+}
@@ -113,34 +113,24 @@ func (t *BlipUtil) CallCtxFunc(c context.Context, key string) (terror error) {
 	return
 }
 
-func (t *BlipUtil) Write(w io.Writer, bytes []byte) {
+// Write
+// Writes the bytes to the writer, a failure is returned as a *WriteError.
+func (t *BlipUtil) Write(w io.Writer, bytes []byte) error {
 	_, err := w.Write(bytes)
 	if err != nil {
-		if err != nil {
-			log.Println(fmt.Sprintf("blip had error writing: %s\n", err))
-		}
-		panic(err)
+		return &WriteError{Err: err}
 	}
+	return nil
 }
-func (t *BlipUtil) WriteStr(w io.Writer, bytes string) {
-	_, err := w.Write([]byte(bytes))
-	if err != nil {
-		if err != nil {
-			log.Println(fmt.Sprintf("blip had error writing: %s\n", err))
-		}
-		panic(err)
-	}
 
+func (t *BlipUtil) WriteStr(w io.Writer, bytes string) error {
+	return t.Write(w, []byte(bytes))
 }
-func (t *BlipUtil) WriteStrSafe(w io.Writer, bytes string, escaper IBlipEscaper) {
-	_, err := w.Write([]byte(escaper.Escape(bytes)))
-	if err != nil {
-		if err != nil {
-			log.Println(fmt.Sprintf("blip had error writing: %s\n", err))
-		}
-		panic(err)
-	}
+
+func (t *BlipUtil) WriteStrSafe(w io.Writer, bytes string, escaper IBlipEscaper) error {
+	return t.Write(w, []byte(escaper.Escape(bytes)))
 }
+
 func (t *BlipUtil) RenderComplete(escaper IBlipEscaper, templateName string, langType string, duration time.Duration, err error) {
 	t.monitor.RenderComplete(escaper, templateName, langType, duration, err)
 }
@@ -168,36 +158,31 @@ func (t *BlipUtil) HasError(ctx context.Context) bool {
 	return ctx.Value("errors") != nil
 }
 
-func (t *BlipUtil) WriteBool(w io.Writer, val bool) {
-	s := "true"
-	if !val {
-		s = "false"
-	}
-	_, err := w.Write([]byte(s))
-	if err != nil {
-		if err != nil {
-			log.Println(fmt.Sprintf("blip had error writing: %s\n", err))
-		}
-		panic(err)
-	}
+func (t *BlipUtil) WriteBool(w io.Writer, val bool) error {
+	return t.Write(w, []byte(strconv.FormatBool(val)))
 }
 
-func (t *BlipUtil) WriteInt(w io.Writer, val int) {
-	_, err := w.Write([]byte(strconv.Itoa(val)))
-	if err != nil {
-		if err != nil {
-			log.Println(fmt.Sprintf("blip had error writing: %s\n", err))
-		}
-		panic(err)
-	}
+func (t *BlipUtil) WriteInt(w io.Writer, val int) error {
+	return t.Write(w, []byte(strconv.Itoa(val)))
 }
 
-func (t *BlipUtil) WriteInt64(w io.Writer, val int64) {
-	_, err := w.Write([]byte(strconv.FormatInt(val, 10)))
-	if err != nil {
-		if err != nil {
-			log.Println(fmt.Sprintf("blip had error writing: %s\n", err))
-		}
-		panic(err)
+func (t *BlipUtil) WriteInt64(w io.Writer, val int64) error {
+	return t.Write(w, []byte(strconv.FormatInt(val, 10)))
+}
+
+// TemplateInfo
+// Used by the generated code to identify the template in returned errors.
+func (t *BlipUtil) TemplateInfo(templateName string, source string) *TemplateInfo {
+	return &TemplateInfo{Name: templateName, Source: source}
+}
+
+// RecoverError
+// Converts a value recovered from a panic in the render function into a *TemplateError.
+// Write failures are returned as errors and never reach here.
+func (t *BlipUtil) RecoverError(templateName string, recovered interface{}) error {
+	err, ok := recovered.(error)
+	if !ok {
+		err = fmt.Errorf("%v", recovered)
 	}
+	return &TemplateError{Template: templateName, Err: err}
 }
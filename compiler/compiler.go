@@ -0,0 +1,181 @@
+// Package compiler transpiles blip templates to Go code in memory.
+//
+// It is the library form of the blip command, for code generators, editor tooling and tests:
+//
+//	res, diags := compiler.Compile(strings.NewReader(src), compiler.Options{Filename: "pages/index.blip.html"})
+//	if len(diags) > 0 {
+//		compiler.WriteDiagnostics(os.Stderr, diags, compiler.FormatGcc)
+//	}
+//	os.WriteFile("blipped/pages/index.blip.go", res.Code, 0666)
+package compiler
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/samlotti/blip/ast"
+	"github.com/samlotti/blip/internal"
+)
+
+// DefaultSupportBranch
+// The import path of the runtime used by the generated code.
+const DefaultSupportBranch = "github.com/samlotti/blip/blipUtil"
+
+// Diagnostic
+// An error in a template, at its file:line:col.
+type Diagnostic = internal.Diagnostic
+
+// Formats of WriteDiagnostics
+const (
+	FormatText = internal.FormatText
+	FormatJson = internal.FormatJson
+	FormatGcc  = internal.FormatGcc
+)
+
+// Options
+// How the templates are compiled.
+type Options struct {
+	// Filename of the template, ex: pages/index.blip.html. Only used by Compile.
+	// The template name, the escaper file type and the go package (the last directory) come from it.
+	Filename string
+
+	// Package overrides the go package of the generated code.
+	Package string
+
+	// SupportBranch is the import path of the runtime, DefaultSupportBranch when empty.
+	SupportBranch string
+
+	// LineDirectives writes //line directives mapping the generated code to the template.
+	// The go tools resolve relative names from the directory of the generated file,
+	// LineFile is the template file name to write, Filename when empty.
+	// For CompileFS it is the directory the paths in the fs.FS are joined to.
+	LineDirectives bool
+	LineFile       string
+
+	// RenderLineNumbers writes the template line numbers as comments in the generated code.
+	RenderLineNumbers bool
+
+	// Interpreter registers the template for the interpreter of the builds with -tags blipdev,
+	// the template file is LineFile, or Filename, relative to the generated file.
+	Interpreter bool
+}
+
+func (opts Options) blipOptions() *internal.BlipOptions {
+	branch := opts.SupportBranch
+	if branch == "" {
+		branch = DefaultSupportBranch
+	}
+	return &internal.BlipOptions{
+		SupportBranch:     branch,
+		LineDirectives:    opts.LineDirectives,
+		RenderLineNumbers: opts.RenderLineNumbers,
+		Interpreter:       opts.Interpreter,
+	}
+}
+
+// Result
+// The generated go code of a template.
+type Result struct {
+	Filename string // The go file relative to the output directory, ex: pages/index.blip.go
+	Source   string // The template file name
+	Package  string
+	Function string // The render function, ex: IndexRender
+	Code     []byte
+}
+
+// Compile
+// Transpiles one template.  The @include / @extend calls are not checked, the other templates are not known.
+// Code is empty when there are diagnostics.
+func Compile(src io.Reader, opts Options) (Result, []Diagnostic) {
+	content, err := io.ReadAll(src)
+	if err != nil {
+		return Result{}, []Diagnostic{{File: opts.Filename, Severity: "error", Message: err.Error()}}
+	}
+	files, diags := internal.CompileSources([]internal.SourceFile{{
+		Path:     opts.Filename,
+		Source:   content,
+		Package:  opts.Package,
+		LineFile: opts.LineFile,
+	}}, opts.blipOptions(), false)
+	if len(files) == 0 {
+		return Result{Source: opts.Filename}, diags
+	}
+	return result(files[0]), diags
+}
+
+// CompileFS
+// Transpiles the templates in root and its sub directories of fsys, and checks the calls between them.
+// Results are only returned for templates without diagnostics.  File names are relative to root.
+func CompileFS(fsys fs.FS, root string, opts Options) ([]Result, []Diagnostic) {
+	var sources []internal.SourceFile
+	var readErrors []Diagnostic
+	err := fs.WalkDir(fsys, root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			readErrors = append(readErrors, Diagnostic{File: name, Severity: "error", Message: err.Error()})
+			return nil
+		}
+		if entry.IsDir() || !internal.IsTemplateFile(entry.Name()) {
+			return nil
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			readErrors = append(readErrors, Diagnostic{File: name, Severity: "error", Message: err.Error()})
+			return nil
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+		if root == "." {
+			rel = name
+		}
+		lineFile := name
+		if opts.LineFile != "" {
+			lineFile = path.Join(opts.LineFile, name)
+		}
+		sources = append(sources, internal.SourceFile{Path: rel, Source: content, Package: opts.Package, LineFile: lineFile})
+		return nil
+	})
+	if err != nil {
+		readErrors = append(readErrors, Diagnostic{File: root, Severity: "error", Message: err.Error()})
+	}
+
+	files, diags := internal.CompileSources(sources, opts.blipOptions(), true)
+	results := make([]Result, 0, len(files))
+	for _, file := range files {
+		results = append(results, result(file))
+	}
+	return results, append(readErrors, diags...)
+}
+
+// Parse
+// The syntax tree of the template, see the ast package.  The tree is returned even with diagnostics,
+// blocks without their @end end at an invalid position.
+func Parse(src io.Reader, filename string) (*ast.Template, []Diagnostic) {
+	content, err := io.ReadAll(src)
+	if err != nil {
+		return &ast.Template{Filename: filename}, []Diagnostic{{File: filename, Severity: "error", Message: err.Error()}}
+	}
+	return internal.ParseSource(filename, content)
+}
+
+// FunctionName
+// The render function of a template named by its file or @name, qualified by the package when it is, ex: layout.base -> layout.BaseRender
+func FunctionName(templateName string) string {
+	return internal.FunctionName(templateName)
+}
+
+// WriteDiagnostics
+// Writes the diagnostics as text, json or gcc (file:line:col: error: msg).
+func WriteDiagnostics(w io.Writer, diags []Diagnostic, format string) error {
+	return internal.WriteDiagnostics(w, diags, format)
+}
+
+func result(file internal.GeneratedFile) Result {
+	return Result{
+		Filename: file.Path,
+		Source:   file.Source,
+		Package:  file.Package,
+		Function: file.Function,
+		Code:     file.Code,
+	}
+}
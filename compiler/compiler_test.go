@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile(t *testing.T) {
+	src := `@arg name string
+<h1>Hello @= name @</h1>
+`
+	res, diags := Compile(strings.NewReader(src), Options{Filename: "pages/hello.blip.html"})
+	assert.Equal(t, 0, len(diags))
+	assert.Equal(t, "pages/hello.blip.go", res.Filename)
+	assert.Equal(t, "pages", res.Package)
+	assert.Equal(t, "HelloRender", res.Function)
+
+	_, err := parser.ParseFile(token.NewFileSet(), res.Filename, res.Code, 0)
+	assert.Nil(t, err)
+	assert.Contains(t, string(res.Code), `"github.com/samlotti/blip/blipUtil"`)
+	assert.Contains(t, string(res.Code), "func HelloRender( name string, c context.Context, w io.Writer )")
+}
+
+func TestCompileErrors(t *testing.T) {
+	res, diags := Compile(strings.NewReader("@incude card\n"), Options{Filename: "pages/hello.blip.html"})
+	assert.Equal(t, 0, len(res.Code))
+	assert.Equal(t, []Diagnostic{{
+		File:     "pages/hello.blip.html",
+		Line:     1,
+		Col:      1,
+		Severity: "error",
+		Message:  "Invalid command found: @incude, did you mean @include?",
+		Snippet:  "\t@incude card\n\t^\n",
+	}}, diags)
+
+	_, diags = Compile(strings.NewReader(""), Options{Filename: "hello.blip.html"})
+	assert.Equal(t, 1, len(diags))
+
+	res, diags = Compile(strings.NewReader(""), Options{Filename: "hello.blip.txt", Package: "mail"})
+	assert.Equal(t, 0, len(diags))
+	assert.Equal(t, "mail/hello.blip.go", res.Filename)
+}
+
+func TestCompileFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"web/template/layout/base.blip.html": {Data: []byte("@arg title string\n<title>@= title @</title>\n@yield body\n")},
+		"web/template/pages/index.blip.html": {Data: []byte("@extend layout.base \"Index\"\n\t@content main\n\t@end\n@end\n")},
+		"web/template/pages/about.blip.html": {Data: []byte("@include layout.base \"About\"\n")},
+		"web/template/pages/notes.txt":       {Data: []byte("not a template")},
+	}
+	results, diags := CompileFS(fsys, "web/template", Options{LineDirectives: true, LineFile: "../.."})
+
+	var names []string
+	for _, res := range results {
+		names = append(names, res.Filename)
+	}
+	assert.Equal(t, []string{"layout/base.blip.go", "pages/about.blip.go"}, names)
+	assert.Contains(t, string(results[0].Code), "/*line ../../web/template/layout/base.blip.html:1:6*/title string")
+
+	assert.Equal(t, 1, len(diags))
+	assert.Equal(t, "pages/index.blip.html", diags[0].File)
+	assert.Equal(t, 2, diags[0].Line)
+	assert.Equal(t, "@content : layout.base has no @yield main, available: body", diags[0].Message)
+}
+
+func TestCompileNames(t *testing.T) {
+	fsys := fstest.MapFS{
+		"mail/welcome.blip.html":  {Data: []byte("<p>welcome</p>\n")},
+		"mail/welcome.blip.txt":   {Data: []byte("welcome\n")},
+		"mail/user-card.blip.txt": {Data: []byte("@include welcome-txt\n")},
+		"mail/reset.blip.txt":     {Data: []byte("@name passwordReset\nreset\n")},
+		"mail/other.blip.txt":     {Data: []byte("@name password-reset\nother\n")},
+	}
+	results, diags := CompileFS(fsys, ".", Options{})
+
+	functions := make(map[string]string)
+	for _, res := range results {
+		functions[res.Filename] = res.Function
+	}
+	assert.Equal(t, map[string]string{
+		"mail/welcome.blip.html.go": "WelcomeHtmlRender",
+		"mail/welcome.blip.txt.go":  "WelcomeTxtRender",
+		"mail/user-card.blip.go":    "UserCardRender",
+		"mail/other.blip.go":        "PasswordResetRender",
+	}, functions)
+
+	assert.Equal(t, 1, len(diags))
+	assert.Equal(t, "mail/reset.blip.txt", diags[0].File)
+	assert.Equal(t, 1, diags[0].Line)
+	assert.Equal(t, "the render function PasswordResetRender of package mail is also generated from mail/other.blip.txt, name the template with @name", diags[0].Message)
+}
@@ -4,12 +4,12 @@ go 1.17
 
 require (
 	github.com/fsnotify/fsnotify v1.6.0
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/stretchr/testify v1.8.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sys v0.0.0-20220908164124-27713097b956 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,15 +1,20 @@
 package internal
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"github.com/fsnotify/fsnotify"
-	"io/fs"
+	goast "go/ast"
+	"go/parser"
+	"go/token"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
+	"unicode"
 )
 
 var Version = "0.8.10"
@@ -19,240 +24,580 @@ type BlipOptions struct {
 	Sdir              string
 	Rebuild           bool
 	Watch             bool
+	Exec              string // With Watch, the command run after each build without errors
 	SupportBranch     string
 	RenderLineNumbers bool
+	LineDirectives    bool
+	Interpreter       bool   // Register the templates for the interpreter of the builds with -tags blipdev
+	Registry          string // Write the registry of the templates: package, or global for one of all packages too
+	Format            string // The diagnostics format: text, json or gcc
+
+	// From blip.yaml, see Config
+	Roots     []SourceRoot // The source directories, in place of Sdir
+	Output    string       // The directory of the generated packages, relative to the module. Default blipped
+	Colocate  bool         // Generate the go files next to the templates, in the package of their directory
+	Templates TemplateConfig
+	Overrides []ConfigOverride
 }
 
-func GteProcess(opt *BlipOptions) {
+// progress
+// Where the progress messages go, stderr when stdout is used for machine readable diagnostics.
+func (opt *BlipOptions) progress() io.Writer {
+	if opt.Format == "" || opt.Format == FormatText {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+// GteProcess
+// Transpiles the templates, the diagnostics are written to stdout in the format of the options.
+// Returns the number of diagnostics of the build, with -watch only returns if the watch cannot start.
+func GteProcess(opt *BlipOptions) int {
+	return processAndWatch(opt, nil)
+}
+
+// processAndWatch
+// The build of GteProcess, then the watch. The pages of the reload server, if any, follow the builds.
+func processAndWatch(opt *BlipOptions, reload *reloadServer) int {
+	out := opt.progress()
 
-	fmt.Printf(" __          __     ___  ___        __            ___  ___  __  \n|__) |    | |__)     |  |__   |\\/| |__) |     /\\   |  |__  /__` \n|__) |___ | |        |  |___  |  | |    |___ /~~\\  |  |___ .__/ \n")
+	fmt.Fprintf(out, " __          __     ___  ___        __            ___  ___  __  \n|__) |    | |__)     |  |__   |\\/| |__) |     /\\   |  |__  /__` \n|__) |___ | |        |  |___  |  | |    |___ /~~\\  |  |___ .__/ \n")
 
-	fmt.Printf("Blip Processing: Version: %s\n", Version)
-	fmt.Printf("Rebuild All: %v\n", opt.Rebuild)
-	fmt.Printf("Render: LineNumbers %v\n", opt.Rebuild)
-	fmt.Printf("Source folder: %s\n", opt.Sdir)
+	fmt.Fprintf(out, "Blip Processing: Version: %s\n", Version)
+	fmt.Fprintf(out, "Rebuild All: %v\n", opt.Rebuild)
+	fmt.Fprintf(out, "Render: LineNumbers %v\n", opt.Rebuild)
+	fmt.Fprintf(out, "Source folder: %s\n", strings.Join(opt.roots(), ", "))
 
-	processDir(opt.Sdir, opt)
-	fmt.Printf("\n")
+	diags := &diagnostics{}
+	processDir(opt, diags)
+	fmt.Fprintf(out, "\n")
+	first := diags.sorted()
+	count := reportDiagnostics(diags, opt)
 
 	if opt.Watch {
-		watchFiles(opt)
+		if err := watchFiles(opt, first, reload); err != nil {
+			diags = &diagnostics{}
+			diags.addError(strings.Join(opt.roots(), ", "), err)
+			count += reportDiagnostics(diags, opt)
+		}
 	}
+	return count
 }
 
-func watchFiles(opt *BlipOptions) {
-	fmt.Printf("---Watching for file changes in %s\n", opt.Sdir)
+// reportDiagnostics
+// Writes the diagnostics to stdout, returns how many there are.
+func reportDiagnostics(diags *diagnostics, opt *BlipOptions) int {
+	list := diags.sorted()
+	if err := WriteDiagnostics(os.Stdout, list, opt.Format); err != nil {
+		fmt.Fprintf(os.Stderr, "blip: %s\n", err)
+	}
+	return len(list)
+}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer watcher.Close()
-	// Start listening for events.
-	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				// log.Println("event:", event)
-				if event.Has(fsnotify.Create) {
-					fi, err := os.Stat(event.Name)
-					if err != nil {
-						// fmt.Printf("Unable to stat: %s", event.Name)
-						return
-					}
-					if fi.IsDir() {
-						go func() {
-							fmt.Printf("**** Please restart the process after adding directories!!!\n")
-							fmt.Printf("**** Please restart the process after adding directories!!!\n")
-							fmt.Printf("**** Please restart the process after adding directories!!!\n")
-							fmt.Printf("**** Please restart the process after adding directories!!!\n")
-							//fmt.Printf("Watching dir: %s\n", event.Name)
-							//watcher.Add(event.Name)
-						}()
-						return
-					}
-				}
-				if event.Has(fsnotify.Write) {
-					// log.Println("modified file:", event.Name)
-					slashIdx := strings.LastIndex(event.Name, "/")
-					fi, err := os.Stat(event.Name)
-					if err != nil {
-						return
-					}
-					sDir := event.Name[0:slashIdx]
-					go func() {
-						defer func() {
-							if err := recover(); err != nil {
-								fmt.Printf("Error: %v", err)
-							}
-						}()
-						processFile(sDir, fi, opt)
-					}()
-
-				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				log.Println("error:", err)
-			}
-		}
-	}()
-
-	// Add a path.
-	var dirs []string
-	getAllSubDirectories(&dirs, opt.Sdir)
-	for _, dir := range dirs {
-		err = watcher.Add(dir)
-		fmt.Printf("Watching dir: %s\n", dir)
-		if err != nil {
-			log.Fatal(err)
-		}
+// processDir
+// Transpiles the templates of the source directories.
+func processDir(opt *BlipOptions, diags *diagnostics) {
+	buildLock.Lock()
+	defer buildLock.Unlock()
 
+	modDir, files, ok := collectTemplates(opt, diags)
+	if !ok {
+		return
 	}
+	symbols := loadSymbols(files, diags)
+	manifest := loadManifest(modDir, opt.outputDir(modDir), opt)
 
-	// Block main goroutine forever.
-	<-make(chan struct{})
+	for _, bf := range files {
+		processFile(bf, symbols, manifest, opt, diags)
+	}
+	pruneOrphans(manifest, false, opt, diags)
+	writeRegistries(modDir, symbols, manifest, opt, diags)
+	saveManifest(manifest, diags)
 }
 
-func getAllSubDirectories(list *[]string, sdir string) []string {
-	files, err := ioutil.ReadDir(sdir)
-	if err != nil {
-		log.Fatal(err)
-	}
+// buildLock
+// One build at a time, they share the manifest.
+var buildLock sync.Mutex
 
-	*list = append(*list, sdir)
-	// fmt.Printf("> %s\n", sdir)
+// processChanged
+// Transpiles the changed templates and the templates calling them, and removes the generated files of removed templates.
+// All templates are parsed to validate the calls between them, the manifest tells which are affected.
+func processChanged(changed map[string]bool, opt *BlipOptions, diags *diagnostics) {
+	buildLock.Lock()
+	defer buildLock.Unlock()
 
-	for _, file := range files {
-		if file.IsDir() {
-			getAllSubDirectories(list, sdir+"/"+file.Name())
+	modDir, files, ok := collectTemplates(opt, diags)
+	if !ok {
+		return
+	}
+	symbols := loadSymbols(files, diags)
+	manifest := loadManifest(modDir, opt.outputDir(modDir), opt)
+
+	for _, bf := range files {
+		ts := symbols.get(bf)
+		if changed[filepath.Clean(bf.sourceFName)] || (ts != nil && manifest.stale(ts, symbols)) {
+			processFile(bf, symbols, manifest, opt, diags)
 		}
 	}
-	return *list
+	pruneOrphans(manifest, false, opt, diags)
+	writeRegistries(modDir, symbols, manifest, opt, diags)
+	saveManifest(manifest, diags)
 }
 
-func processDir(sdir string, opt *BlipOptions) {
-	files, err := ioutil.ReadDir(sdir)
-	if err != nil {
-		log.Fatal(err)
+func saveManifest(manifest *buildManifest, diags *diagnostics) {
+	if err := manifest.save(); err != nil {
+		diags.addError(manifest.path(), err)
 	}
+}
 
-	for _, file := range files {
-		if file.IsDir() {
-			processDir(sdir+"/"+file.Name(), opt)
-		} else {
-			processFile(sdir, file, opt)
+// pruneOrphans
+// Removes the generated files whose template no longer exists, all of them with all.
+func pruneOrphans(manifest *buildManifest, all bool, opt *BlipOptions, diags *diagnostics) {
+	removed, err := manifest.prune(all)
+	for _, output := range removed {
+		fmt.Fprintf(opt.progress(), "\nRemoved: %s", output)
+	}
+	if err != nil {
+		diags.addError(manifest.path(), err)
+	}
+}
 
+// GteClean
+// Removes the generated files of the templates that no longer exist, with all every generated file and the manifest.
+// Returns the number of diagnostics.
+func GteClean(opt *BlipOptions, all bool) int {
+	diags := &diagnostics{}
+	modDir, err := findGoMod()
+	if err != nil {
+		diags.addError(strings.Join(opt.roots(), ", "), err)
+		return reportDiagnostics(diags, opt)
+	}
+	buildLock.Lock()
+	defer buildLock.Unlock()
+	manifest := loadManifest(modDir, opt.outputDir(modDir), nil)
+	pruneOrphans(manifest, all, opt, diags)
+	if all {
+		if err := manifest.remove(); err != nil {
+			diags.addError(manifest.path(), err)
 		}
+	} else {
+		saveManifest(manifest, diags)
 	}
+	fmt.Fprintf(opt.progress(), "\n")
+	return reportDiagnostics(diags, opt)
+}
 
+// collectTemplates
+// The templates of the source directories and the directory of the go.mod, ok is false when it is not found.
+func collectTemplates(opt *BlipOptions, diags *diagnostics) (modDir string, files []*blipFile, ok bool) {
+	modDir, err := findGoMod()
+	if err != nil {
+		diags.addError(strings.Join(opt.roots(), ", "), err)
+		return "", nil, false
+	}
+	return modDir, collectRoots(modDir, opt, diags), true
 }
 
-func processFile(sdir string, file fs.FileInfo, opt *BlipOptions) {
-	fileType := "text"
+// collectRoots
+// The templates of the source directories, the packages are named by the overrides of blip.yaml.
+// modDir is empty when nothing is generated, ex: blip vet.
+func collectRoots(modDir string, opt *BlipOptions, diags *diagnostics) []*blipFile {
+	var files []*blipFile
+	for _, root := range opt.sourceRoots() {
+		outDir := ""
+		if !root.Colocate {
+			outDir = opt.rootOutputDir(root, modDir)
+		}
+		collectBlipFiles(outDir, root.Dir, &files, diags)
+	}
+	for _, bf := range files {
+		if pkg := opt.forTemplate(bf).Templates.Package; pkg != "" {
+			bf.pkg = pkg
+		}
+	}
+	return withoutCollisions(files, diags)
+}
 
-	if strings.HasSuffix(file.Name(), ".go") {
-		return
+// collectBlipFiles
+// All templates in the directory and its sub directories, generated under outDir or next to the templates when empty.
+// The templates of the directory are generated in its name under outDir, the sub directories are mirrored under outDir,
+// ex: template/index.blip.html -> blipped/template, template/admin/users/list.blip.html -> blipped/admin/users
+func collectBlipFiles(outDir string, sdir string, files *[]*blipFile, diags *diagnostics) {
+	start := len(*files)
+	if outDir == "" {
+		walkBlipFiles("", "", sdir, files, diags)
+	} else {
+		walkBlipFiles(outDir+"/"+path.Base(sdir), outDir, sdir, files, diags)
 	}
+	disambiguateFileTypes((*files)[start:])
+}
 
-	if !strings.Contains(file.Name(), ".blip") {
+// walkBlipFiles
+// The templates of sdir are generated in destDir, those of its sub directories in the same names under subDir.
+// Both are empty to generate next to the templates.
+func walkBlipFiles(destDir string, subDir string, sdir string, files *[]*blipFile, diags *diagnostics) {
+	entries, err := ioutil.ReadDir(sdir)
+	if err != nil {
+		diags.addError(sdir, err)
 		return
 	}
+	// Generated next to the templates, in the package of the hand written go files
+	colocatedPkg := ""
+	if destDir == "" {
+		colocatedPkg = goPackageOf(sdir)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			sub := ""
+			if subDir != "" {
+				sub = subDir + "/" + entry.Name()
+			}
+			walkBlipFiles(sub, sub, sdir+"/"+entry.Name(), files, diags)
+		} else if bf := newBlipFile(destDir, sdir, entry.Name()); bf != nil {
+			bf.pkg = colocatedPkg
+			*files = append(*files, bf)
+		}
+	}
+}
+
+// blipFile
+// A template and the generated go file it transpiles to.
+type blipFile struct {
+	sdir        string // The directory of the template
+	name        string // The file name of the template, ex: index.blip.html
+	fileType    string // The escaper file type, ex: html
+	trimmedName string // The file name without the file type, ex: index.blip
+	sourceFName string
+	destDir     string
+	destFName   string
+	source      []byte // The template when compiled from memory, nil to read sourceFName
+	pkg         string // Overrides the package from the directory
+	lineFile    string // Overrides the template file name of the //line directives
+	typeSuffix  bool   // Named with the file type, another template of the package has the same name
+}
+
+// IsTemplateFile
+// Template file names contain .blip, ex: index.blip.html
+func IsTemplateFile(name string) bool {
+	return strings.Contains(name, ".blip") && !strings.HasSuffix(name, ".go")
+}
+
+// newBlipFile
+// Returns nil if the file is not a template.
+// The go file is generated in destDir, ex: blipped/pages in the directory with the go.mod.
+// When destDir is empty it is generated next to the template.
+func newBlipFile(destDir string, sdir string, name string) *blipFile {
+	fileType := "text"
+
+	if !IsTemplateFile(name) {
+		return nil
+	}
 
-	trimmedName := file.Name()
-	if !strings.HasSuffix(file.Name(), ".blip") {
-		sections := strings.Split(file.Name(), ".")
+	trimmedName := name
+	if !strings.HasSuffix(name, ".blip") {
+		sections := strings.Split(name, ".")
 		fileType = sections[len(sections)-1]
 		trimmedName = strings.TrimSuffix(trimmedName, "."+fileType)
 	}
 
-	// destDir
-	destDir := findGoMod() + "/blipped/" + path.Base(sdir)
-	err := os.MkdirAll(destDir, 0755)
-	if err != nil {
-		panic(fmt.Sprintf("Error creating directory: %s: %s", destDir, err))
-		return
+	if destDir == "" {
+		destDir, _ = filepath.Abs(sdir)
+	}
+	return &blipFile{
+		sdir:        sdir,
+		name:        name,
+		fileType:    fileType,
+		trimmedName: trimmedName,
+		sourceFName: sdir + "/" + name,
+		destDir:     destDir,
+		destFName:   destDir + "/" + trimmedName + ".go",
 	}
+}
 
-	destFName := destDir + "/" + trimmedName + ".go"
-	sourceFName := sdir + "/" + file.Name()
+// packageName
+// The go package of the generated file, named by the last directory of the template.
+func (bf *blipFile) packageName() string {
+	if bf.pkg != "" {
+		return bf.pkg
+	}
+	dirSects := strings.Split(bf.sdir, "/")
+	return goPackageName(dirSects[len(dirSects)-1])
+}
 
-	fmt.Printf("\nProcess blip: %s --> %s", sourceFName, destDir)
+// goPackageName
+// A valid go package name from a directory name, the characters not allowed are dropped, ex: user-cards -> usercards
+func goPackageName(dir string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, dir)
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "p" + name
+	}
+	if token.IsKeyword(name) {
+		name += "_"
+	}
+	return name
+}
 
-	inBytes, err := ioutil.ReadFile(sourceFName)
+// withoutCollisions
+// Reports the templates generated to the same directory from another template directory, or to the same file,
+// they are left out of the build.
+func withoutCollisions(files []*blipFile, diags *diagnostics) []*blipFile {
+	cwd, _ := os.Getwd()
+	dirSource := make(map[string]string)
+	fileSource := make(map[string]*blipFile)
+	kept := files[:0:0]
+	for _, bf := range files {
+		sdir := filepath.Clean(bf.sdir)
+		if other, ok := dirSource[bf.destDir]; ok && other != sdir {
+			diags.add(Diagnostic{File: bf.sourceFName, Message: fmt.Sprintf("%s and %s are both generated to the package %s, rename a directory or give the roots different outputs",
+				other, sdir, relativeFile(cwd, bf.destDir))})
+			continue
+		}
+		if other, ok := fileSource[bf.destFName]; ok {
+			diags.add(Diagnostic{File: bf.sourceFName, Message: fmt.Sprintf("%s and %s are both generated to %s, rename a template",
+				other.sourceFName, bf.sourceFName, relativeFile(cwd, bf.destFName))})
+			continue
+		}
+		dirSource[bf.destDir] = sdir
+		fileSource[bf.destFName] = bf
+		kept = append(kept, bf)
+	}
+	return kept
+}
+
+// goPackageOf
+// The package of the go files in the directory, empty when there are none.
+func goPackageOf(dir string) string {
+	entries, err := ioutil.ReadDir(dir)
 	if err != nil {
-		panic(fmt.Sprintf("Error reading file: %s: %s", sourceFName, err))
-		return
+		return ""
 	}
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err == nil {
+			return f.Name.Name
+		}
+	}
+	return ""
+}
 
-	sfi, err := os.Stat(sourceFName)
+// goFunctionsOf
+// The functions declared by the hand written go files of the directory, the methods and the generated files are left out.
+func goFunctionsOf(dir string) map[string]bool {
+	functions := make(map[string]bool)
+	entries, err := ioutil.ReadDir(dir)
 	if err != nil {
-		fmt.Printf(" -- Unable to stat: %s : %s\n", sourceFName, err)
-		return
+		return functions
 	}
-	dfi, err := os.Stat(destFName)
-	if err == nil {
-		if sfi.ModTime().Before(dfi.ModTime()) {
-			if !opt.Rebuild {
-				fmt.Printf("-- Not modified \n")
-				return
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		src, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || bytes.Contains(src, []byte(generatedMarker)) {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), src, 0)
+		if err != nil {
+			continue
+		}
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*goast.FuncDecl); ok && fn.Recv == nil {
+				functions[fn.Name.Name] = true
 			}
 		}
 	}
+	return functions
+}
+
+// templateName
+// The template name, the file name up to the first dot.
+func (bf *blipFile) templateName() string {
+	return strings.Split(bf.name, ".")[0]
+}
+
+// renderName
+// The name of the render function without Render: the @name of the template,
+// else the template name, with the file type when another template has the same name, ex: mail-html
+func (bf *blipFile) renderName(parser *Parser) string {
+	if parser != nil && parser.name != nil {
+		return strings.TrimSpace(parser.name.Literal)
+	}
+	if bf.typeSuffix {
+		return bf.templateName() + "-" + bf.fileType
+	}
+	return bf.templateName()
+}
+
+// withTypeSuffix
+// Names the template and its generated file with the file type, ex: mail.blip.html -> MailHtmlRender in mail.blip.html.go
+func (bf *blipFile) withTypeSuffix() {
+	bf.typeSuffix = true
+	bf.destFName = path.Join(path.Dir(bf.destFName), bf.name+".go")
+}
 
-	lex := NewLexer(string(inBytes), sourceFName)
+// disambiguateFileTypes
+// The templates of a package with the same name and different file types, ex: mail.blip.html and mail.blip.txt,
+// are named with their file type.
+func disambiguateFileTypes(files []*blipFile) {
+	types := make(map[string]map[string]bool) // package directory and template name -> file types
+	key := func(bf *blipFile) string {
+		return bf.destDir + "\x00" + bf.templateName()
+	}
+	for _, bf := range files {
+		if types[key(bf)] == nil {
+			types[key(bf)] = make(map[string]bool)
+		}
+		types[key(bf)][bf.fileType] = true
+	}
+	for _, bf := range files {
+		if len(types[key(bf)]) > 1 {
+			bf.withTypeSuffix()
+		}
+	}
+}
+
+// parse
+// Reads and parses the template.
+func (bf *blipFile) parse() (*Parser, error) {
+	inBytes := bf.source
+	if inBytes == nil {
+		var err error
+		inBytes, err = ioutil.ReadFile(bf.sourceFName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lex := NewLexer(string(inBytes), bf.sourceFName)
 	parser := New(lex)
 	parser.Parse()
+	return parser, nil
+}
 
-	dirSects := strings.Split(sdir, "/")
-	fileSects := strings.Split(file.Name(), ".")
+// render
+// Renders the generated go code of the parsed template.
+func (bf *blipFile) render(parser *Parser, opt *BlipOptions) []byte {
+	var out bytes.Buffer
+	render := NewRender(parser)
+	if opt.LineDirectives {
+		lineFile := bf.lineFile
+		if lineFile == "" {
+			lineFile = lineDirectivePath(bf.destDir, bf.sourceFName)
+		}
+		render.WithLineDirectives(lineFile, path.Base(bf.destFName))
+	}
+	if opt.Interpreter {
+		sourceFile := bf.lineFile
+		if sourceFile == "" {
+			sourceFile = lineDirectivePath(bf.destDir, bf.sourceFName)
+		}
+		render.WithInterpreter(sourceFile)
+	}
+	render.RenderOutput(&out, bf.packageName(), bf.renderName(parser), bf.fileType, bf.sourceFName, opt)
+	return out.Bytes()
+}
 
-	// _ = os.Remove(destFName)
-	dfile, err := os.Create(destFName)
-	if err != nil {
-		fmt.Printf(" -- Error: %s\n", err)
+// processFile
+// Writes the generated go file of the template, errors are added to the diagnostics.
+// Templates are only generated when the manifest shows they changed, or -rebuild.
+func processFile(bf *blipFile, symbols *symbolTable, manifest *buildManifest, opt *BlipOptions, diags *diagnostics) {
+	opt = opt.forTemplate(bf)
+	ts := symbols.get(bf)
+	if ts == nil {
+		// Could not be read, already reported
 		return
 	}
-	NewRender(parser).RenderOutput(dfile, dirSects[len(dirSects)-1], fileSects[0], fileType, sourceFName, opt)
-	err = dfile.Close()
+	parser := ts.parser
+
+	out := opt.progress()
+	if parser.hasErrors() {
+		diags.addParserErrors(bf.sourceFName, parser)
+	}
+
+	err := os.MkdirAll(bf.destDir, 0755)
 	if err != nil {
-		fmt.Printf(" -- Error: %s\n", err)
+		diags.addError(bf.sourceFName, fmt.Errorf("creating directory %s: %w", bf.destDir, err))
 		return
 	}
 
+	fmt.Fprintf(out, "\nProcess blip: %s --> %s", bf.sourceFName, bf.destDir)
+
+	// Errors can come from the templates it calls, so regenerate to report them
+	if !opt.Rebuild && !parser.hasErrors() && !manifest.stale(ts, symbols) {
+		fmt.Fprintf(out, "-- Not modified \n")
+		return
+	}
+
+	code := bf.render(parser, opt)
+
+	err = ioutil.WriteFile(bf.destFName, code, 0666)
+	if err != nil {
+		manifest.forget(bf)
+		diags.addError(bf.destFName, err)
+		return
+	}
 	if parser.hasErrors() {
-		fmt.Printf("\n\n*** Errors found in : %s\n", file.Name())
-		for _, err := range parser.errors {
-			fmt.Printf("Error: %d:%d %s\n", err.lineNum, err.linePos, err.msg)
-		}
-		fmt.Printf("\n\n")
+		manifest.forget(bf)
+	} else {
+		manifest.built(ts, symbols)
 	}
 	// fmt.Printf("\n")
 
 }
 
+// lineDirectivePath
+// The source file relative to the directory of the generated file.
+// The go tools resolve relative //line file names from the directory of the file containing them.
+func lineDirectivePath(destDir string, sourceFName string) string {
+	abs, err := filepath.Abs(sourceFName)
+	if err != nil {
+		return sourceFName
+	}
+	rel, err := filepath.Rel(destDir, abs)
+	if err != nil {
+		return abs
+	}
+	return filepath.ToSlash(rel)
+}
+
 // findGoMod --
 // Goes up the directories until it find the go.mod file
-func findGoMod() string {
+func findGoMod() (string, error) {
 	dir, err := os.Getwd()
 	if err != nil {
-		panic(fmt.Sprintf("Cannot get directory: %s", err))
+		return "", fmt.Errorf("cannot get directory: %w", err)
 	}
+	return findGoModFrom(dir)
+}
+
+// findGoModFrom
+// The directory of the go.mod of the directory or its parents.
+func findGoModFrom(dir string) (string, error) {
 	for {
 		_, err := os.Stat(dir + "/go.mod")
 		if err == nil {
 			// This is the bae directory!
-			return dir
+			return dir, nil
 		}
-		if errors.Is(err, os.ErrNotExist) {
-			dir, _ = path.Split(strings.TrimSuffix(dir, "/"))
-			continue
-		} else {
-			panic(fmt.Sprintf("Cannot get base directory, searching for directory with go.mod: %s", err))
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("cannot get base directory, searching for directory with go.mod: %w", err)
+		}
+		parent, _ := path.Split(strings.TrimSuffix(dir, "/"))
+		if parent == "" || parent == dir {
+			return "", fmt.Errorf("no go.mod found in the directory or its parents")
 		}
+		dir = parent
 	}
 }
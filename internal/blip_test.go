@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoPackageName(t *testing.T) {
+	assert.Equal(t, "pages", goPackageName("pages"))
+	assert.Equal(t, "usercards", goPackageName("user-cards"))
+	assert.Equal(t, "v12", goPackageName("v1.2"))
+	assert.Equal(t, "p2fa", goPackageName("2fa"))
+	assert.Equal(t, "type_", goPackageName("type"))
+}
+
+func TestPackageLayout(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "template")
+	writeTemplate(t, filepath.Join(root, "admin", "components"), "card.blip.html", "@arg name string\n<p>@= name @</p>\n")
+	writeTemplate(t, filepath.Join(root, "shop", "components"), "card.blip.html", "@arg name string\n@arg price int\n<p>@= name @</p>\n")
+	writeTemplate(t, filepath.Join(root, "admin", "pages"), "index.blip.html", `@import "example.com/m/blipped/admin/components"
+@include components.card "bob", 1
+`)
+	writeTemplate(t, filepath.Join(root, "user-cards"), "small.blip.html", "<p>small</p>\n")
+	writeTemplate(t, root, "home.blip", "home\n")
+	writeTemplate(t, root, "home.blip.text", "home\n")
+	writeTemplate(t, root, "mail.blip.html", "<p>mail</p>\n")
+	writeTemplate(t, root, "mail.blip.txt", "mail\n")
+	writeTemplate(t, filepath.Join(root, "template"), "about.blip.html", "<p>about</p>\n")
+
+	diags := &diagnostics{}
+	files := collectRoots("/m", &BlipOptions{Sdir: root}, diags)
+	dests := make(map[string]string)
+	for _, bf := range files {
+		rel, err := filepath.Rel(root, bf.sourceFName)
+		assert.Nil(t, err)
+		dests[filepath.ToSlash(rel)] = bf.destFName + " " + bf.packageName()
+	}
+	assert.Equal(t, map[string]string{
+		"admin/components/card.blip.html": "/m/blipped/admin/components/card.blip.go components",
+		"admin/pages/index.blip.html":     "/m/blipped/admin/pages/index.blip.go pages",
+		"shop/components/card.blip.html":  "/m/blipped/shop/components/card.blip.go components",
+		"user-cards/small.blip.html":      "/m/blipped/user-cards/small.blip.go usercards",
+		"home.blip":                       "/m/blipped/template/home.blip.go template",
+		"mail.blip.html":                  "/m/blipped/template/mail.blip.html.go template",
+		"mail.blip.txt":                   "/m/blipped/template/mail.blip.txt.go template",
+	}, dests)
+
+	var out bytes.Buffer
+	WriteDiagnostics(&out, diags.sorted(), FormatText)
+	assert.Contains(t, out.String(), "home.blip.text: "+root+"/home.blip and "+root+"/home.blip.text are both generated to /m/blipped/template/home.blip.go, rename a template\n")
+	assert.Contains(t, out.String(), "about.blip.html: "+root+" and "+filepath.Join(root, "template")+" are both generated to the package /m/blipped/template, rename a directory or give the roots different outputs\n")
+
+	// The imported components package is called
+	symbols := loadSymbols(files, diags)
+	for _, bf := range files {
+		if bf.name == "index.blip.html" {
+			ts := symbols.get(bf)
+			assert.Equal(t, 1, len(ts.parser.errors))
+			assert.Equal(t, "@include : components.card expects 1 argument(s) (name string), found 2", ts.parser.errors[0].msg)
+		}
+	}
+}
+
+func TestTemplateFunctionName(t *testing.T) {
+	assert.Equal(t, "IndexRender", templateFunctionName("index"))
+	assert.Equal(t, "UserCardRender", templateFunctionName("user-card"))
+	assert.Equal(t, "User_cardRender", templateFunctionName("user_card"))
+	assert.Equal(t, "MailHtmlRender", templateFunctionName("mail-html"))
+	assert.Equal(t, "T404Render", templateFunctionName("404"))
+	assert.Equal(t, "layout.BaseRender", templateFunctionName("layout.base"))
+}
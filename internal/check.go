@@ -0,0 +1,351 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	goast "go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// GteCheck
+// Transpiles all templates in memory, nothing is written.
+//
+//	typeCheck: type checks the generated packages with go/types, errors are reported at the template file:line:col using the line directives.
+//	stale: compares the generated code with the files on disk, a unified diff of each out of date file is printed.
+//
+// The diagnostics are written to stdout in the format of the options, returns how many there are.
+func GteCheck(opt *BlipOptions, typeCheck bool, stale bool) int {
+	diags := &diagnostics{}
+	checkTemplates(opt, diags, typeCheck, stale, opt.progress())
+	return reportDiagnostics(diags, opt)
+}
+
+func checkTemplates(opt *BlipOptions, diags *diagnostics, typeCheck bool, stale bool, diffOut io.Writer) {
+	// Positions are only mapped back to the templates with line directives
+	modDir, files, ok := collectTemplates(opt, diags)
+	if !ok {
+		return
+	}
+
+	cwd, _ := os.Getwd()
+	overlay := make(map[string]map[string][]byte)
+	failed := make(map[string]bool)
+	symbols := loadSymbols(files, diags)
+	for _, bf := range files {
+		ts := symbols.get(bf)
+		if ts == nil {
+			failed[bf.destDir] = true
+			continue
+		}
+		parser := ts.parser
+		if parser.hasErrors() {
+			diags.addParserErrors(bf.sourceFName, parser)
+			// The generated code is not valid go, the template errors are enough
+			failed[bf.destDir] = true
+			continue
+		}
+		tmplOpt := opt.forTemplate(bf)
+		if stale {
+			checkGenerated(bf, bf.render(parser, tmplOpt), cwd, diags, diffOut)
+		}
+		if overlay[bf.destDir] == nil {
+			overlay[bf.destDir] = make(map[string][]byte)
+		}
+		typeOpt := *tmplOpt
+		typeOpt.LineDirectives = true
+		overlay[bf.destDir][filepath.Base(bf.destFName)] = bf.render(parser, &typeOpt)
+	}
+	generated := registries(modDir, symbols, opt)
+	names := make([]string, 0, len(generated))
+	for fname := range generated {
+		names = append(names, fname)
+	}
+	sort.Strings(names)
+	for _, fname := range names {
+		code := generated[fname]
+		if stale {
+			checkFile(fname, "the templates", code, cwd, diags, diffOut)
+		}
+		if dir := filepath.Dir(fname); overlay[dir] != nil {
+			overlay[dir][RegistryName] = code
+		}
+	}
+	if stale {
+		checkOrphans(modDir, opt.outputDir(modDir), cwd, diags)
+	}
+	if !typeCheck {
+		return
+	}
+
+	imp := newCheckImporter(modDir, readModulePath(modDir), overlay)
+
+	dirs := make([]string, 0, len(overlay))
+	for dir := range overlay {
+		if !failed[dir] {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		for _, err := range imp.check(dir) {
+			diags.add(Diagnostic{
+				File:    relativeFile(cwd, err.pos.Filename),
+				Line:    err.pos.Line,
+				Col:     err.pos.Column,
+				Message: err.msg,
+			})
+		}
+	}
+}
+
+// checkGenerated
+// Compares the generated code with the file on disk, the differences are written to diffOut.
+func checkGenerated(bf *blipFile, code []byte, cwd string, diags *diagnostics, diffOut io.Writer) {
+	checkFile(bf.destFName, bf.sourceFName, code, cwd, diags, diffOut)
+}
+
+// checkFile
+// Compares the code generated from source with the file on disk, see checkGenerated.
+func checkFile(destFName string, source string, code []byte, cwd string, diags *diagnostics, diffOut io.Writer) {
+	destName := relativeFile(cwd, destFName)
+	onDisk, err := ioutil.ReadFile(destFName)
+	if os.IsNotExist(err) {
+		diags.add(Diagnostic{File: destName, Message: fmt.Sprintf("not generated from %s, run blip", source)})
+		return
+	}
+	if err != nil {
+		diags.addError(destName, err)
+		return
+	}
+	if bytes.Equal(onDisk, code) {
+		return
+	}
+	diags.add(Diagnostic{File: destName, Message: fmt.Sprintf("out of date with %s, run blip", source)})
+	text, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(onDisk)),
+		B:        difflib.SplitLines(string(code)),
+		FromFile: destName + " (on disk)",
+		ToFile:   destName + " (generated)",
+		Context:  3,
+	})
+	fmt.Fprint(diffOut, text)
+}
+
+// checkOrphans
+// The generated files in the manifest whose template no longer exists.
+func checkOrphans(modDir string, outDir string, cwd string, diags *diagnostics) {
+	manifest := loadManifest(modDir, outDir, nil)
+	for key, entry := range manifest.Templates {
+		if _, err := os.Stat(filepath.Join(modDir, filepath.FromSlash(key))); !os.IsNotExist(err) {
+			continue
+		}
+		output := filepath.Join(modDir, filepath.FromSlash(entry.Output))
+		if _, err := os.Stat(output); err == nil {
+			diags.add(Diagnostic{File: relativeFile(cwd, output), Message: fmt.Sprintf("the template %s was removed, run blip or blip clean", key)})
+		}
+	}
+}
+
+// relativeFile
+// The file name relative to the working directory when below it.
+func relativeFile(cwd string, filename string) string {
+	if rel, err := filepath.Rel(cwd, filename); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return filename
+}
+
+// readModulePath
+// The module path from the go.mod in the directory.
+func readModulePath(modDir string) string {
+	f, err := os.Open(filepath.Join(modDir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "module ") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module ")), "\"")
+		}
+	}
+	return ""
+}
+
+// checkImporter
+// A types.Importer that type checks the packages of the module from source,
+// using the in memory generated files in place of the ones on disk.
+// Other packages are imported from the compiler export data.
+type checkImporter struct {
+	fset     *token.FileSet
+	modDir   string
+	modPath  string
+	overlay  map[string]map[string][]byte // directory -> file name -> generated source
+	packages map[string]*types.Package
+	fallback types.Importer
+}
+
+func newCheckImporter(modDir string, modPath string, overlay map[string]map[string][]byte) *checkImporter {
+	fset := token.NewFileSet()
+	ci := &checkImporter{
+		fset:     fset,
+		modDir:   modDir,
+		modPath:  modPath,
+		overlay:  overlay,
+		packages: make(map[string]*types.Package),
+	}
+	ci.fallback = importer.ForCompiler(fset, "gc", ci.lookupExport)
+	return ci
+}
+
+// lookupExport
+// Opens the compiler export data of a package outside the module, go list builds it when needed.
+func (ci *checkImporter) lookupExport(path string) (io.ReadCloser, error) {
+	cmd := exec.Command("go", "list", "-export", "-f", "{{.Export}}", path)
+	cmd.Dir = ci.modDir
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return nil, fmt.Errorf("%s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, err
+	}
+	export := strings.TrimSpace(string(out))
+	if export == "" {
+		return nil, fmt.Errorf("no export data for %s", path)
+	}
+	return os.Open(export)
+}
+
+func (ci *checkImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := ci.packages[path]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through %s", path)
+		}
+		return pkg, nil
+	}
+	if ci.modPath == "" || (path != ci.modPath && !strings.HasPrefix(path, ci.modPath+"/")) {
+		return ci.fallback.Import(path)
+	}
+
+	// Mark as in progress to detect cycles
+	ci.packages[path] = nil
+	dir := filepath.Join(ci.modDir, filepath.FromSlash(strings.TrimPrefix(path, ci.modPath)))
+	files, err := ci.parseDir(dir)
+	if err != nil {
+		delete(ci.packages, path)
+		return nil, err
+	}
+	// Errors in imported packages are reported when that package is checked
+	conf := types.Config{Importer: ci, FakeImportC: true, Error: func(err error) {}}
+	pkg, _ := conf.Check(path, ci.fset, files, nil)
+	ci.packages[path] = pkg
+	return pkg, nil
+}
+
+// checkError
+// A go error found in the generated code, the position is mapped to the template.
+type checkError struct {
+	pos token.Position
+	msg string
+}
+
+// check
+// Type checks the package in the directory and returns the errors found.
+func (ci *checkImporter) check(dir string) []checkError {
+	var errs []checkError
+	files, err := ci.parseDir(dir)
+	if err != nil {
+		if list, ok := err.(scanner.ErrorList); ok {
+			// One error per line, the others tend to follow from it
+			list.RemoveMultiples()
+			for _, e := range list {
+				errs = append(errs, checkError{pos: e.Pos, msg: e.Msg})
+			}
+		} else {
+			errs = append(errs, checkError{pos: token.Position{Filename: dir}, msg: err.Error()})
+		}
+		return errs
+	}
+	conf := types.Config{Importer: ci, FakeImportC: true, Error: func(err error) {
+		if terr, ok := err.(types.Error); ok {
+			errs = append(errs, checkError{pos: terr.Fset.Position(terr.Pos), msg: terr.Msg})
+		}
+	}}
+	path := ci.importPath(dir)
+	pkg, _ := conf.Check(path, ci.fset, files, nil)
+	if _, ok := ci.packages[path]; !ok {
+		ci.packages[path] = pkg
+	}
+	return errs
+}
+
+// importPath
+// The import path of a directory in the module.
+func (ci *checkImporter) importPath(dir string) string {
+	rel, err := filepath.Rel(ci.modDir, dir)
+	if err != nil || rel == "." {
+		return ci.modPath
+	}
+	return ci.modPath + "/" + filepath.ToSlash(rel)
+}
+
+// parseDir
+// Parses the go files of the package, the generated files from the overlay replace those on disk.
+func (ci *checkImporter) parseDir(dir string) ([]*goast.File, error) {
+	sources := make(map[string][]byte)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && len(ci.overlay[dir]) == 0 {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if match, err := build.Default.MatchFile(dir, name); err != nil || !match {
+			continue
+		}
+		src, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		sources[name] = src
+	}
+	for name, src := range ci.overlay[dir] {
+		sources[name] = src
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files []*goast.File
+	for _, name := range names {
+		f, err := parser.ParseFile(ci.fset, filepath.Join(dir, name), sources[name], parser.AllErrors)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTemplate(t *testing.T, dir string, name string, content string) {
+	err := os.MkdirAll(dir, 0755)
+	assert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	assert.Nil(t, err)
+}
+
+func TestCheckTypeErrors(t *testing.T) {
+	sdir := filepath.Join(t.TempDir(), "checkpages")
+	writeTemplate(t, sdir, "card.blip.html", `@arg title string
+<div>@= title @</div>
+`)
+	writeTemplate(t, sdir, "page.blip.html", `@arg count int
+@include card count
+@int= count + missing @
+`)
+
+	diags := &diagnostics{}
+	checkTemplates(&BlipOptions{Sdir: sdir, SupportBranch: "github.com/samlotti/blip/blipUtil"}, diags, true, false, ioutil.Discard)
+	var out bytes.Buffer
+	count := len(diags.list)
+	WriteDiagnostics(&out, diags.sorted(), FormatText)
+	assert.Equal(t, 2, count, out.String())
+	assert.Contains(t, out.String(), "page.blip.html:2:15: cannot use count (variable of type int) as string value in argument to CardRender")
+	assert.Contains(t, out.String(), "page.blip.html:3:15: undefined: missing")
+}
+
+func TestCheckNoErrors(t *testing.T) {
+	sdir := filepath.Join(t.TempDir(), "checkok")
+	writeTemplate(t, sdir, "card.blip.html", `@arg title string
+@import "strings"
+<div>@= strings.ToUpper(title) @</div>
+`)
+
+	diags := &diagnostics{}
+	checkTemplates(&BlipOptions{Sdir: sdir, SupportBranch: "github.com/samlotti/blip/blipUtil"}, diags, true, false, ioutil.Discard)
+	var out bytes.Buffer
+	count := len(diags.list)
+	WriteDiagnostics(&out, diags.sorted(), FormatText)
+	assert.Equal(t, 0, count, out.String())
+	// Nothing written
+	modDir, err := findGoMod()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(modDir, "blipped"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCheckStale(t *testing.T) {
+	modDir := t.TempDir()
+	writeTemplate(t, modDir, "go.mod", "module example.com/stale\n")
+	sdir := filepath.Join(modDir, "template", "pages")
+	writeTemplate(t, sdir, "index.blip.html", "<p>index</p>\n")
+	writeTemplate(t, sdir, "about.blip.html", "<p>about</p>\n")
+	writeTemplate(t, sdir, "new.blip.html", "<p>new</p>\n")
+
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(modDir))
+	defer os.Chdir(cwd)
+
+	opt := &BlipOptions{Sdir: "template", SupportBranch: "github.com/samlotti/blip/blipUtil", LineDirectives: true}
+	diags := &diagnostics{}
+	processDir(opt, diags)
+	assert.Equal(t, 0, len(diags.list))
+
+	var out bytes.Buffer
+	checkTemplates(opt, diags, false, true, &out)
+	assert.Equal(t, 0, len(diags.list), diags.sorted())
+	assert.Equal(t, "", out.String())
+
+	writeTemplate(t, sdir, "index.blip.html", "<p>home</p>\n")
+	assert.Nil(t, os.Remove(filepath.Join(sdir, "about.blip.html")))
+	assert.Nil(t, os.Remove(filepath.Join(modDir, "blipped", "pages", "new.blip.go")))
+	checkTemplates(opt, diags, false, true, &out)
+
+	var report bytes.Buffer
+	WriteDiagnostics(&report, diags.sorted(), FormatText)
+	assert.Equal(t, `blipped/pages/about.blip.go: the template template/pages/about.blip.html was removed, run blip or blip clean
+blipped/pages/index.blip.go: out of date with template/pages/index.blip.html, run blip
+blipped/pages/new.blip.go: not generated from template/pages/new.blip.html, run blip
+`, report.String())
+	assert.Contains(t, out.String(), "--- blipped/pages/index.blip.go (on disk)\n+++ blipped/pages/index.blip.go (generated)\n")
+	assert.Contains(t, out.String(), "-\t/*line ../../template/pages/index.blip.html:1:1*/terror = si.Write(w, []byte(\"<p>index</p>\\n\"))\n")
+	// Nothing written
+	_, err = os.Stat(filepath.Join(modDir, "blipped", "pages", "new.blip.go"))
+	assert.True(t, os.IsNotExist(err))
+}
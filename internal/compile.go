@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"path"
+
+	blipast "github.com/samlotti/blip/ast"
+)
+
+// SourceFile
+// A template in memory.
+type SourceFile struct {
+	Path     string // Slash separated, ex: pages/index.blip.html
+	Source   []byte
+	Package  string // The go package, empty for the last directory of Path
+	LineFile string // The template file name of the //line directives, empty for Path
+}
+
+// GeneratedFile
+// The go code of a template.
+type GeneratedFile struct {
+	Path     string // The go file relative to the output root, ex: pages/index.blip.go
+	Source   string // The Path of the template
+	Package  string
+	Function string // The render function, ex: IndexRender
+	Code     []byte
+}
+
+// CompileSources
+// Transpiles the templates without reading or writing files.
+// With validateCalls the @include / @extend calls between the templates are checked.
+// Templates with errors have no generated file.
+func CompileSources(files []SourceFile, opt *BlipOptions, validateCalls bool) ([]GeneratedFile, []Diagnostic) {
+	diags := &diagnostics{}
+	var bfs []*blipFile
+	for _, src := range files {
+		bf := newBlipFile("", path.Dir(src.Path), path.Base(src.Path))
+		if bf == nil {
+			diags.add(Diagnostic{File: src.Path, Message: "not a template, the file name must contain .blip"})
+			continue
+		}
+		if src.Package == "" && path.Dir(src.Path) == "." {
+			diags.add(Diagnostic{File: src.Path, Message: "no package, the template must be in a directory or the package given"})
+			continue
+		}
+		bf.sourceFName = src.Path
+		bf.source = src.Source
+		if bf.source == nil {
+			bf.source = []byte{}
+		}
+		bf.pkg = src.Package
+		bf.lineFile = src.LineFile
+		if bf.lineFile == "" {
+			bf.lineFile = src.Path
+		}
+		bf.destDir = bf.packageName()
+		bf.destFName = path.Join(bf.destDir, bf.trimmedName+".go")
+		bfs = append(bfs, bf)
+	}
+	disambiguateFileTypes(bfs)
+
+	var symbols *symbolTable
+	if validateCalls {
+		symbols = loadSymbols(bfs, diags)
+	} else {
+		symbols = newSymbolTable()
+		for _, bf := range bfs {
+			parser, err := bf.parse()
+			if err != nil {
+				diags.addError(bf.sourceFName, err)
+				continue
+			}
+			symbols.add(bf, parser)
+		}
+	}
+
+	var generated []GeneratedFile
+	for _, bf := range bfs {
+		ts := symbols.get(bf)
+		if ts == nil {
+			continue
+		}
+		if ts.parser.hasErrors() {
+			diags.addParserErrors(bf.sourceFName, ts.parser)
+			continue
+		}
+		generated = append(generated, GeneratedFile{
+			Path:     bf.destFName,
+			Source:   bf.sourceFName,
+			Package:  bf.packageName(),
+			Function: templateFunctionName(bf.renderName(ts.parser)),
+			Code:     bf.render(ts.parser, opt),
+		})
+	}
+	return generated, diags.sorted()
+}
+
+// ParseSource
+// Parses the template into the public ast, with the template errors as diagnostics.
+func ParseSource(filename string, src []byte) (*blipast.Template, []Diagnostic) {
+	parser := New(NewLexer(string(src), filename))
+	parser.Parse()
+	diags := &diagnostics{}
+	diags.addParserErrors(filename, parser)
+	return parser.Template(), diags.sorted()
+}
+
+// FunctionName
+// The render function of the template name, ex: user-card -> UserCardRender, layout.base -> layout.BaseRender
+func FunctionName(templateName string) string {
+	return templateFunctionName(templateName)
+}
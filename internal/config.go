@@ -0,0 +1,326 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigName
+// The configuration file, in the directory of the go.mod.
+const ConfigName = "blip.yaml"
+
+// Config
+// The settings of blip.yaml, the paths are relative to the directory of the file.
+//
+//	roots:                  # The source directories, -dir
+//	  - web/template
+//	  - dir: admin/template   # With its own output directory
+//	    output: admin/blipped
+//	  - dir: shop/views       # Generated next to the templates, in the package of the directory
+//	    colocate: true
+//	output: blipped         # Where the go files are generated, -out
+//	colocate: false         # Generate next to the templates by default, -colocate
+//	supportBranch: github.com/samlotti/blip/blipUtil
+//	lineDirectives: true
+//	renderLineNumbers: false
+//	interpreter: false      # Register the templates for the builds with -tags blipdev, -interpreter
+//	registry: package       # Write the registry of the templates of each package, global for all packages too, -registry
+//	exec: "go build -o server . && ./server"  # Run after the -watch builds without errors, -exec
+//	imports:                # Imported by the templates using them
+//	  - strings
+//	  - h net/http
+//	escapers:               # The escaper of a template file type, ex: xml templates escaped as html
+//	  xml: html
+//	trim: false             # Remove the indentation, trailing spaces and empty lines of the text
+//	minify: false           # html: collapse the white space of the text
+//	overrides:              # Settings of the templates in a directory and its sub directories
+//	  - dir: web/template/mail
+//	    package: mail
+//	    trim: true
+type Config struct {
+	Roots             []SourceRoot `yaml:"roots"`
+	Output            string       `yaml:"output"`
+	Colocate          *bool        `yaml:"colocate"`
+	SupportBranch     string       `yaml:"supportBranch"`
+	LineDirectives    *bool        `yaml:"lineDirectives"`
+	RenderLineNumbers *bool        `yaml:"renderLineNumbers"`
+	Interpreter       *bool        `yaml:"interpreter"`
+	Registry          string       `yaml:"registry"`
+	Exec              string       `yaml:"exec"`
+
+	TemplateConfig `yaml:",inline"`
+	Overrides      []ConfigOverride `yaml:"overrides"`
+}
+
+// SourceRoot
+// A directory of templates and where they are generated.
+// In blip.yaml either the directory or a mapping with dir, output and colocate.
+type SourceRoot struct {
+	Dir      string `yaml:"dir"`
+	Output   string `yaml:"output,omitempty"`   // The directory of the generated packages, default the output of the options
+	Colocate bool   `yaml:"colocate,omitempty"` // Generate next to the templates
+}
+
+// UnmarshalYAML
+// A root is the directory alone, or a mapping.
+func (root *SourceRoot) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		root.Dir = value.Value
+		return nil
+	}
+	type plain SourceRoot
+	return value.Decode((*plain)(root))
+}
+
+// TemplateConfig
+// The settings that can differ by directory.
+type TemplateConfig struct {
+	Package  string            `yaml:"package,omitempty"` // The go package, only by directory
+	Imports  []string          `yaml:"imports,omitempty"`
+	Escapers map[string]string `yaml:"escapers,omitempty"`
+	Trim     *bool             `yaml:"trim,omitempty"`
+	Minify   *bool             `yaml:"minify,omitempty"`
+}
+
+// ConfigOverride
+// The settings of the templates in the directory and its sub directories.
+// When several match the deepest directory wins.
+type ConfigOverride struct {
+	Dir            string `yaml:"dir"`
+	TemplateConfig `yaml:",inline"`
+}
+
+// LoadConfig
+// Reads the blip.yaml next to the go.mod into the options, when there is one.
+// The flags that were set, by name, take precedence over the file.
+func LoadConfig(opt *BlipOptions, setFlags map[string]bool) error {
+	modDir, err := findGoMod()
+	if err != nil {
+		// Reported by the command when it needs the module
+		return nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	return loadConfig(opt, modDir, cwd, setFlags)
+}
+
+// loadConfig
+// Reads the blip.yaml of the module directory into the options, the directories are made relative to cwd,
+// or absolute when it is empty.
+func loadConfig(opt *BlipOptions, modDir string, cwd string, setFlags map[string]bool) error {
+	fname := filepath.Join(modDir, ConfigName)
+	data, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	if cfg.Package != "" {
+		return fmt.Errorf("%s: package is only allowed in the overrides", fname)
+	}
+	return cfg.apply(opt, modDir, cwd, setFlags)
+}
+
+// apply
+// Sets the options from the configuration, except those of the flags that were set.
+func (cfg *Config) apply(opt *BlipOptions, modDir string, cwd string, setFlags map[string]bool) error {
+	// Relative to the working directory like -dir, the generated files name the templates by it
+	relative := func(dir string) string {
+		if filepath.IsAbs(dir) {
+			return dir
+		}
+		if cwd == "" {
+			return filepath.Join(modDir, dir)
+		}
+		rel, err := filepath.Rel(cwd, filepath.Join(modDir, dir))
+		if err != nil {
+			return filepath.Join(modDir, dir)
+		}
+		return rel
+	}
+
+	if len(cfg.Roots) > 0 && !setFlags["dir"] {
+		opt.Sdir = ""
+		opt.Roots = nil
+		for _, root := range cfg.Roots {
+			if root.Dir == "" {
+				return fmt.Errorf("%s: a root has no dir", ConfigName)
+			}
+			root.Dir = relative(root.Dir)
+			opt.Roots = append(opt.Roots, root)
+		}
+	}
+	if cfg.Output != "" && !setFlags["out"] {
+		opt.Output = cfg.Output
+	}
+	if cfg.Colocate != nil && !setFlags["colocate"] {
+		opt.Colocate = *cfg.Colocate
+	}
+	if cfg.SupportBranch != "" && !setFlags["supportBranch"] {
+		opt.SupportBranch = cfg.SupportBranch
+	}
+	if cfg.LineDirectives != nil && !setFlags["lineDirectives"] {
+		opt.LineDirectives = *cfg.LineDirectives
+	}
+	if cfg.RenderLineNumbers != nil && !setFlags["renderLineNumbers"] {
+		opt.RenderLineNumbers = *cfg.RenderLineNumbers
+	}
+	if cfg.Interpreter != nil && !setFlags["interpreter"] {
+		opt.Interpreter = *cfg.Interpreter
+	}
+	if cfg.Registry != "" && !setFlags["registry"] {
+		opt.Registry = cfg.Registry
+	}
+	if cfg.Exec != "" && !setFlags["exec"] {
+		opt.Exec = cfg.Exec
+	}
+	opt.Templates.merge(cfg.TemplateConfig)
+
+	opt.Overrides = nil
+	for _, override := range cfg.Overrides {
+		if override.Dir == "" {
+			return fmt.Errorf("%s: an override has no dir", ConfigName)
+		}
+		override.Dir = filepath.Join(modDir, override.Dir)
+		opt.Overrides = append(opt.Overrides, override)
+	}
+	// The deepest directory is applied last
+	sort.SliceStable(opt.Overrides, func(i, j int) bool {
+		return len(opt.Overrides[i].Dir) < len(opt.Overrides[j].Dir)
+	})
+	return nil
+}
+
+// merge
+// Adds the settings of the override.
+func (tc *TemplateConfig) merge(override TemplateConfig) {
+	if override.Package != "" {
+		tc.Package = override.Package
+	}
+	tc.Imports = append(append([]string(nil), tc.Imports...), override.Imports...)
+	if len(override.Escapers) > 0 {
+		escapers := make(map[string]string)
+		for fileType, escaper := range tc.Escapers {
+			escapers[fileType] = escaper
+		}
+		for fileType, escaper := range override.Escapers {
+			escapers[fileType] = escaper
+		}
+		tc.Escapers = escapers
+	}
+	if override.Trim != nil {
+		tc.Trim = override.Trim
+	}
+	if override.Minify != nil {
+		tc.Minify = override.Minify
+	}
+}
+
+// forTemplate
+// The options of the template, with the overrides of its directory.
+func (opt *BlipOptions) forTemplate(bf *blipFile) *BlipOptions {
+	if len(opt.Overrides) == 0 {
+		return opt
+	}
+	dir, err := filepath.Abs(bf.sdir)
+	if err != nil {
+		return opt
+	}
+	tmplOpt := *opt
+	for _, override := range opt.Overrides {
+		if dir == override.Dir || strings.HasPrefix(dir, override.Dir+string(filepath.Separator)) {
+			tmplOpt.Templates.merge(override.TemplateConfig)
+		}
+	}
+	return &tmplOpt
+}
+
+// roots
+// The source directories.
+func (opt *BlipOptions) roots() []string {
+	var dirs []string
+	for _, root := range opt.sourceRoots() {
+		dirs = append(dirs, root.Dir)
+	}
+	return dirs
+}
+
+// sourceRoots
+// The source directories and their output, a root without an output is colocated with -colocate.
+func (opt *BlipOptions) sourceRoots() []SourceRoot {
+	roots := opt.Roots
+	if len(roots) == 0 {
+		roots = []SourceRoot{{Dir: opt.Sdir}}
+	}
+	resolved := make([]SourceRoot, 0, len(roots))
+	for _, root := range roots {
+		if root.Output == "" && opt.Colocate {
+			root.Colocate = true
+		}
+		resolved = append(resolved, root)
+	}
+	return resolved
+}
+
+// outputDir
+// The directory of the generated packages, the manifest is kept in it.
+func (opt *BlipOptions) outputDir(modDir string) string {
+	output := opt.Output
+	if output == "" {
+		output = "blipped"
+	}
+	if filepath.IsAbs(output) {
+		return output
+	}
+	return filepath.Join(modDir, output)
+}
+
+// rootOutputDir
+// The directory of the generated packages of the root.
+func (opt *BlipOptions) rootOutputDir(root SourceRoot, modDir string) string {
+	if root.Output == "" {
+		return opt.outputDir(modDir)
+	}
+	if filepath.IsAbs(root.Output) {
+		return root.Output
+	}
+	return filepath.Join(modDir, root.Output)
+}
+
+// escaperFor
+// The escaper of the template file type.
+func (opt *BlipOptions) escaperFor(fileType string) string {
+	if escaper, ok := opt.Templates.Escapers[fileType]; ok {
+		return escaper
+	}
+	return fileType
+}
+
+// defaultImports
+// The imports of the configuration as written in a go import, ex: h net/http -> h "net/http"
+func (opt *BlipOptions) defaultImports() []string {
+	var specs []string
+	for _, imp := range opt.Templates.Imports {
+		fields := strings.Fields(imp)
+		for idx, field := range fields {
+			if !strings.HasPrefix(field, "\"") && (idx == len(fields)-1) {
+				fields[idx] = "\"" + field + "\""
+			}
+		}
+		specs = append(specs, strings.Join(fields, " "))
+	}
+	return specs
+}
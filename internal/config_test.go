@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	modDir := t.TempDir()
+	writeTemplate(t, modDir, "go.mod", "module example.com/config\n")
+	writeTemplate(t, modDir, ConfigName, `roots:
+  - web/template
+output: gen
+supportBranch: example.com/support
+lineDirectives: false
+imports:
+  - strings
+escapers:
+  xml: html
+trim: true
+overrides:
+  - dir: web/template/mail
+    package: mail
+    minify: true
+    imports:
+      - h net/http
+  - dir: web/template/mail/plain
+    trim: false
+`)
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(modDir))
+	defer os.Chdir(cwd)
+
+	opt := &BlipOptions{Sdir: "./template", SupportBranch: "example.com/flag", LineDirectives: true}
+	assert.Nil(t, LoadConfig(opt, map[string]bool{"supportBranch": true}))
+	assert.Equal(t, []string{filepath.Join("web", "template")}, opt.roots())
+	assert.Equal(t, filepath.Join(modDir, "gen"), opt.outputDir(modDir))
+	// The flag that was set wins
+	assert.Equal(t, "example.com/flag", opt.SupportBranch)
+	assert.False(t, opt.LineDirectives)
+	assert.Equal(t, "html", opt.escaperFor("xml"))
+	assert.Equal(t, "txt", opt.escaperFor("txt"))
+	assert.Equal(t, []string{`"strings"`}, opt.defaultImports())
+
+	page := opt.forTemplate(&blipFile{sdir: filepath.Join("web", "template", "pages")})
+	assert.Equal(t, "", page.Templates.Package)
+	assert.True(t, *page.Templates.Trim)
+	assert.Nil(t, page.Templates.Minify)
+
+	plain := opt.forTemplate(&blipFile{sdir: filepath.Join("web", "template", "mail", "plain")})
+	assert.Equal(t, "mail", plain.Templates.Package)
+	assert.False(t, *plain.Templates.Trim)
+	assert.True(t, *plain.Templates.Minify)
+	assert.Equal(t, []string{`"strings"`, `h "net/http"`}, plain.defaultImports())
+	// The options of the module are unchanged
+	assert.True(t, *opt.Templates.Trim)
+
+	writeTemplate(t, modDir, ConfigName, "package: web\n")
+	assert.NotNil(t, LoadConfig(&BlipOptions{}, nil))
+}
+
+func renderWith(t *testing.T, source string, fileType string, opt *BlipOptions) string {
+	parser := New(NewLexer(source, "test"))
+	parser.Parse()
+	assert.False(t, parser.hasErrors())
+	var out bytes.Buffer
+	NewRender(parser).RenderOutput(&out, "template", "test", fileType, "test", opt)
+	return out.String()
+}
+
+func TestTrimMinify(t *testing.T) {
+	on := true
+	source := "<div>\n    <p>  @= name @  </p>   \n\n    <pre>\n  keep   this\n    </pre>\n</div>\n@text\n    raw\n@end\n"
+	opt := &BlipOptions{SupportBranch: "github.com/samlotti/blip/blipUtil"}
+
+	code := renderWith(t, "@arg name string\n"+source, "html", opt)
+	assert.Contains(t, code, `si.Write(w, []byte("<div>\n    <p>  "))`)
+
+	opt.Templates.Trim = &on
+	code = renderWith(t, "@arg name string\n"+source, "html", opt)
+	assert.Contains(t, code, `si.Write(w, []byte("<div>\n<p>  "))`)
+	assert.Contains(t, code, `si.Write(w, []byte("  </p>\n<pre>\n  keep   this\n    </pre>\n</div>\n"))`)
+	// @text is written as is
+	assert.Contains(t, code, `si.Write(w, []byte("\n    raw\n"))`)
+
+	opt.Templates.Trim = nil
+	opt.Templates.Minify = &on
+	code = renderWith(t, "@arg name string\n"+source, "html", opt)
+	assert.Contains(t, code, `si.Write(w, []byte("<div>\n<p> "))`)
+	assert.Contains(t, code, `si.Write(w, []byte(" </p>\n<pre>\n  keep   this\n    </pre>\n</div>\n"))`)
+
+	// Only html is minified
+	code = renderWith(t, "@arg name string\n"+source, "txt", opt)
+	assert.Contains(t, code, `si.Write(w, []byte("<div>\n    <p>  "))`)
+}
+
+func TestDefaultImports(t *testing.T) {
+	opt := &BlipOptions{SupportBranch: "github.com/samlotti/blip/blipUtil"}
+	opt.Templates.Imports = []string{"strings", "h net/http"}
+	opt.Templates.Escapers = map[string]string{"xml": "html"}
+
+	code := renderWith(t, "@arg name string\n<a>@= strings.ToUpper(name) @</a>\n", "xml", opt)
+	assert.Contains(t, code, "\t\"strings\"\n")
+	assert.NotContains(t, code, "net/http")
+	assert.Contains(t, code, `si.GetEscaperFor( "html")`)
+
+	code = renderWith(t, "@arg code int\n<a>@= h.StatusText(code) @</a>\n", "html", opt)
+	assert.NotContains(t, code, "\t\"strings\"\n")
+	assert.Contains(t, code, "\th \"net/http\"\n")
+}
+
+func TestConfigBuild(t *testing.T) {
+	modDir := t.TempDir()
+	writeTemplate(t, modDir, "go.mod", "module example.com/configbuild\n")
+	writeTemplate(t, modDir, ConfigName, "roots: [web/template]\noutput: gen\noverrides:\n  - dir: web/template/mail\n    package: email\n")
+	writeTemplate(t, filepath.Join(modDir, "web", "template", "mail"), "welcome.blip.txt", "hi\n")
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(modDir))
+	defer os.Chdir(cwd)
+
+	opt := &BlipOptions{Sdir: "./template", SupportBranch: "github.com/samlotti/blip/blipUtil"}
+	assert.Nil(t, LoadConfig(opt, nil))
+	diags := &diagnostics{}
+	processDir(opt, diags)
+	assert.Equal(t, 0, len(diags.list), diags.sorted())
+
+	code, err := ioutil.ReadFile(filepath.Join(modDir, "gen", "mail", "welcome.blip.go"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(code), "package email\n")
+	_, err = os.Stat(filepath.Join(modDir, "gen", ManifestName))
+	assert.Nil(t, err)
+}
+
+func TestConfigRoots(t *testing.T) {
+	modDir := t.TempDir()
+	writeTemplate(t, modDir, "go.mod", "module example.com/roots\n")
+	writeTemplate(t, modDir, ConfigName, `roots:
+  - web/template
+  - dir: admin/template
+    output: admin/gen
+  - dir: shop/views
+    colocate: true
+`)
+	writeTemplate(t, filepath.Join(modDir, "web", "template", "pages"), "index.blip.html", "<p>index</p>\n")
+	writeTemplate(t, filepath.Join(modDir, "admin", "template", "users"), "list.blip.html", "<p>users</p>\n")
+	shop := filepath.Join(modDir, "shop", "views")
+	writeTemplate(t, shop, "item.go", "package catalog\n\ntype item struct {\n\tname string\n}\n")
+	writeTemplate(t, shop, "item.blip.html", "@arg it item\n<p>@= it.name @</p>\n")
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(modDir))
+	defer os.Chdir(cwd)
+
+	opt := &BlipOptions{Sdir: "./template", SupportBranch: "github.com/samlotti/blip/blipUtil", LineDirectives: true}
+	assert.Nil(t, LoadConfig(opt, nil))
+	assert.Equal(t, []string{filepath.Join("web", "template"), filepath.Join("admin", "template"), filepath.Join("shop", "views")}, opt.roots())
+	diags := &diagnostics{}
+	processDir(opt, diags)
+	assert.Equal(t, 0, len(diags.list), diags.sorted())
+
+	for _, generated := range []string{"blipped/pages/index.blip.go", "admin/gen/users/list.blip.go", "shop/views/item.blip.go"} {
+		_, err := os.Stat(filepath.Join(modDir, filepath.FromSlash(generated)))
+		assert.Nil(t, err, generated)
+	}
+	code, err := ioutil.ReadFile(filepath.Join(shop, "item.blip.go"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(code), "package catalog\n")
+	assert.Contains(t, string(code), "/*line item.blip.html:2:")
+
+	// The manifest of the output tracks all roots
+	manifest := loadManifest(modDir, opt.outputDir(modDir), opt)
+	assert.Equal(t, "shop/views/item.blip.go", manifest.Templates["shop/views/item.blip.html"].Output)
+	assert.Equal(t, "admin/gen/users/list.blip.go", manifest.Templates["admin/template/users/list.blip.html"].Output)
+}
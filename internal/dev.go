@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DefaultDevAddr
+// Where blip dev serves the live reload.
+const DefaultDevAddr = "localhost:35729"
+
+// devEnv
+// blipUtil.DevEnv, not imported: the interpreter of blipUtil imports the compiler.
+const devEnv = "BLIP_DEV"
+
+// GteDev
+// Transpiles the templates, watches them and serves the live reload on addr until stopped.
+// Returns the number of diagnostics when the server or the watch cannot start.
+func GteDev(opt *BlipOptions, addr string) int {
+	reload, err := newReloadServer(addr)
+	if err != nil {
+		diags := &diagnostics{}
+		diags.addError(addr, err)
+		return reportDiagnostics(diags, opt)
+	}
+	go reload.serve()
+
+	// The applications started by -exec write the script
+	if err := os.Setenv(devEnv, reload.url); err != nil {
+		diags := &diagnostics{}
+		diags.addError(devEnv, err)
+		return reportDiagnostics(diags, opt)
+	}
+	fmt.Fprintf(opt.progress(), "---Live reload on %s, write @== blipUtil.LiveReload() @ in the <head> of the html layouts\n", reload.url)
+	opt.Watch = true
+	return processAndWatch(opt, reload)
+}
+
+// reloadEvent
+// A server sent event to the pages: reload, or errors with the diagnostics as json.
+type reloadEvent struct {
+	name string
+	data string
+}
+
+// reloadServer
+// Serves the live reload script and the events telling the pages to reload or to show the template errors.
+type reloadServer struct {
+	listener net.Listener
+	url      string
+
+	mu      sync.Mutex
+	clients map[chan reloadEvent]bool
+	errors  *reloadEvent // The errors of the last build, sent to the pages connecting
+}
+
+func newReloadServer(addr string) (*reloadServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+		host = "localhost"
+	}
+	return &reloadServer{
+		listener: listener,
+		url:      "http://" + net.JoinHostPort(host, port),
+		clients:  make(map[chan reloadEvent]bool),
+	}, nil
+}
+
+func (s *reloadServer) serve() {
+	if err := http.Serve(s.listener, s.handler()); err != nil {
+		fmt.Fprintf(os.Stderr, "blip: dev: %s\n", err)
+	}
+}
+
+func (s *reloadServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blip/reload.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprint(w, reloadScript)
+	})
+	mux.HandleFunc("/blip/events", s.events)
+	return mux
+}
+
+// events
+// The event stream of a page.
+func (s *reloadServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// The page is served by the application, on another port
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	events := make(chan reloadEvent, 8)
+	s.mu.Lock()
+	s.clients[events] = true
+	pending := s.errors
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, events)
+		s.mu.Unlock()
+	}()
+
+	fmt.Fprintf(w, ": blip\n\n")
+	if pending != nil {
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", pending.name, pending.data)
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+			flusher.Flush()
+		}
+	}
+}
+
+// afterBuild
+// The pages reload after a build without errors, or show the errors.
+func (s *reloadServer) afterBuild(list []Diagnostic) {
+	event := reloadEvent{name: "reload", data: "{}"}
+	if len(list) > 0 {
+		data, err := json.Marshal(list)
+		if err != nil {
+			data = []byte("[]")
+		}
+		event = reloadEvent{name: "errors", data: string(data)}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = nil
+	if event.name == "errors" {
+		s.errors = &event
+	}
+	for client := range s.clients {
+		select {
+		case client <- event:
+		default:
+			// The page is not reading, it reconnects
+		}
+	}
+}
+
+// reloadScript
+// Served as /blip/reload.js, the server is the origin of the script.
+// On reload the page is fetched until the application answers, it may be restarting.
+var reloadScript = strings.TrimSpace(`
+(function () {
+  var src = document.currentScript.src;
+  var server = src.substring(0, src.lastIndexOf("/blip/"));
+  var overlay = null;
+
+  function hide() {
+    if (overlay) {
+      overlay.remove();
+      overlay = null;
+    }
+  }
+
+  function show(list) {
+    hide();
+    overlay = document.createElement("div");
+    overlay.style.cssText = "position:fixed;inset:0;z-index:2147483647;overflow:auto;padding:24px;" +
+      "background:rgba(24,24,24,0.94);color:#eee;font:14px/1.5 monospace;white-space:pre-wrap";
+    var title = document.createElement("div");
+    title.style.cssText = "color:#ff6b6b;font-weight:bold;margin-bottom:16px";
+    title.textContent = "blip: " + list.length + " template error(s)";
+    overlay.appendChild(title);
+    list.forEach(function (d) {
+      var item = document.createElement("div");
+      item.style.marginBottom = "16px";
+      var pos = document.createElement("div");
+      pos.style.color = "#8ab4f8";
+      pos.textContent = d.line ? d.file + ":" + d.line + ":" + d.col : d.file;
+      var msg = document.createElement("div");
+      msg.textContent = d.message;
+      item.appendChild(pos);
+      item.appendChild(msg);
+      if (d.snippet) {
+        var snippet = document.createElement("div");
+        snippet.style.color = "#aaa";
+        snippet.textContent = d.snippet;
+        item.appendChild(snippet);
+      }
+      overlay.appendChild(item);
+    });
+    overlay.addEventListener("click", hide);
+    (document.body || document.documentElement).appendChild(overlay);
+  }
+
+  function reload() {
+    fetch(location.href, { method: "HEAD", cache: "no-store" }).then(function () {
+      location.reload();
+    }, function () {
+      setTimeout(reload, 300);
+    });
+  }
+
+  var events = new EventSource(server + "/blip/events");
+  events.addEventListener("reload", reload);
+  events.addEventListener("errors", function (e) {
+    show(JSON.parse(e.data));
+  });
+})();
+`)
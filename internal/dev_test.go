@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readEvent
+// The next server sent event of the stream, as its lines.
+func readEvent(t *testing.T, reader *bufio.Reader) string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		if err != nil || line == "\n" {
+			return strings.Join(lines, "")
+		}
+		if !strings.HasPrefix(line, ":") {
+			lines = append(lines, line)
+		}
+	}
+}
+
+func TestReloadServer(t *testing.T) {
+	s := &reloadServer{clients: make(map[chan reloadEvent]bool)}
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/blip/reload.js")
+	assert.Nil(t, err)
+	script, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "text/javascript; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(script), `new EventSource(server + "/blip/events")`)
+
+	// The errors of the last build are sent to the pages connecting
+	s.afterBuild([]Diagnostic{{File: "template/index.blip.html", Line: 2, Col: 5, Severity: "error", Message: "undefined: name"}})
+	resp, err = http.Get(server.URL + "/blip/events")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	reader := bufio.NewReader(resp.Body)
+	assert.Equal(t, "", readEvent(t, reader))
+	assert.Equal(t, `event: errors
+data: [{"file":"template/index.blip.html","line":2,"col":5,"severity":"error","message":"undefined: name"}]
+`, readEvent(t, reader))
+
+	s.afterBuild(nil)
+	assert.Equal(t, "event: reload\ndata: {}\n", readEvent(t, reader))
+	assert.Nil(t, s.errors)
+}
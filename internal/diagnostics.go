@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Output formats of the diagnostics
+const (
+	FormatText = "text"
+	FormatJson = "json"
+	FormatGcc  = "gcc"
+)
+
+// Diagnostic
+// An error found in a template or while generating the code.
+// Line and Col are 0 when the error has no position, ex: a file that cannot be written.
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Col      int    `json:"col"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Snippet  string `json:"snippet,omitempty"` // The template line and a caret under the column
+}
+
+// Position
+// file:line:col, or file when there is no position.
+func (d Diagnostic) Position() string {
+	if d.Line == 0 {
+		return d.File
+	}
+	return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Col)
+}
+
+// CheckFormat
+// Returns an error if the format is not one of text, json or gcc.
+func CheckFormat(format string) error {
+	switch format {
+	case FormatText, FormatJson, FormatGcc:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatText, FormatJson, FormatGcc)
+}
+
+// WriteDiagnostics
+// Writes the diagnostics in the format.
+//
+//	text: file:line:col: message, followed by the template line
+//	json: an array of diagnostics, [] when there are none
+//	gcc:  file:line:col: error: message
+func WriteDiagnostics(w io.Writer, diags []Diagnostic, format string) error {
+	switch format {
+	case FormatJson:
+		if diags == nil {
+			diags = []Diagnostic{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diags)
+	case FormatGcc:
+		for _, d := range diags {
+			if _, err := fmt.Fprintf(w, "%s: %s: %s\n", d.Position(), d.Severity, d.Message); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatText, "":
+		for _, d := range diags {
+			if _, err := fmt.Fprintf(w, "%s: %s\n%s", d.Position(), d.Message, d.Snippet); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return CheckFormat(format)
+}
+
+// diagnostics
+// Collects the diagnostics of a run, safe for the concurrent builds of watch.
+type diagnostics struct {
+	mu   sync.Mutex
+	list []Diagnostic
+}
+
+func (ds *diagnostics) add(d Diagnostic) {
+	if d.Severity == "" {
+		d.Severity = "error"
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.list = append(ds.list, d)
+}
+
+// addError
+// An error without a template position.
+func (ds *diagnostics) addError(file string, err error) {
+	ds.add(Diagnostic{File: file, Message: err.Error()})
+}
+
+// addParserErrors
+// The errors of the parsed template, with the template line as snippet.
+func (ds *diagnostics) addParserErrors(file string, parser *Parser) {
+	for _, perr := range parser.errors {
+		ds.add(Diagnostic{
+			File:    file,
+			Line:    perr.lineNum,
+			Col:     perr.linePos,
+			Message: strings.TrimSpace(perr.msg),
+			Snippet: parser.ErrorSnippet(perr),
+		})
+	}
+}
+
+// sorted
+// The diagnostics by file and position.
+func (ds *diagnostics) sorted() []Diagnostic {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list := append([]Diagnostic(nil), ds.list...)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].File != list[j].File {
+			return list[i].File < list[j].File
+		}
+		if list[i].Line != list[j].Line {
+			return list[i].Line < list[j].Line
+		}
+		return list[i].Col < list[j].Col
+	})
+	return list
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWriteDiagnostics(t *testing.T) {
+	lex := NewLexer("@arg name strin g\n", "TestLexer1")
+	parser := New(lex)
+	parser.Parse()
+
+	diags := &diagnostics{}
+	diags.addParserErrors("pages/index.blip.html", parser)
+	diags.addError("blipped/pages/index.blip.go", errors.New("permission denied"))
+	list := diags.sorted()
+
+	var out bytes.Buffer
+	assert.Nil(t, WriteDiagnostics(&out, list, FormatGcc))
+	assert.Equal(t, "blipped/pages/index.blip.go: error: permission denied\npages/index.blip.html:1:17: error: @arg : missing ',' in parameter list\n", out.String())
+
+	out.Reset()
+	assert.Nil(t, WriteDiagnostics(&out, list, FormatText))
+	assert.Contains(t, out.String(), "pages/index.blip.html:1:17: @arg : missing ',' in parameter list\n\t@arg name strin g\n\t                ^\n")
+
+	out.Reset()
+	assert.Nil(t, WriteDiagnostics(&out, list, FormatJson))
+	assert.Contains(t, out.String(), `"file": "pages/index.blip.html",
+    "line": 1,
+    "col": 17,
+    "severity": "error",`)
+
+	out.Reset()
+	assert.Nil(t, WriteDiagnostics(&out, nil, FormatJson))
+	assert.Equal(t, "[]\n", out.String())
+
+	assert.NotNil(t, CheckFormat("xml"))
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// execStopDelay
+// How long the command has to exit once interrupted before it is killed.
+var execStopDelay = 5 * time.Second
+
+// execPrefix
+// Written before each line of output of the command.
+const execPrefix = "[exec] "
+
+// execRunner
+// Runs the -exec command after the builds without errors, the previous run is stopped first.
+// A build with errors leaves the previous run as is.
+type execRunner struct {
+	command string
+	out     io.Writer // The progress and the stdout of the command
+	errOut  io.Writer // The stderr of the command
+
+	mu     sync.Mutex
+	run    *execRun
+	closed bool
+}
+
+// execRun
+// A started command, done is closed once it exited.
+type execRun struct {
+	cmd      *exec.Cmd
+	done     chan struct{}
+	stopping int32 // Set when blip stops it, atomic
+}
+
+func newExecRunner(command string, out io.Writer) *execRunner {
+	return &execRunner{command: command, out: out, errOut: os.Stderr}
+}
+
+// afterBuild
+// Restarts the command when the build has no errors.
+func (r *execRunner) afterBuild(count int) {
+	if count > 0 {
+		fmt.Fprintf(r.out, "---Not running %s, the build has errors\n", r.command)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.stopRun()
+	if err := r.start(); err != nil {
+		fmt.Fprintf(r.out, "---Unable to run %s: %s\n", r.command, err)
+	}
+}
+
+// close
+// Stops the running command, if any, and the next builds do not run it.
+func (r *execRunner) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.stopRun()
+}
+
+func (r *execRunner) stopRun() {
+	run := r.run
+	if run == nil {
+		return
+	}
+	r.run = nil
+	select {
+	case <-run.done:
+		return
+	default:
+	}
+	atomic.StoreInt32(&run.stopping, 1)
+	fmt.Fprintf(r.out, "---Stopping %s\n", r.command)
+	_ = interruptProcess(run.cmd)
+	select {
+	case <-run.done:
+	case <-time.After(execStopDelay):
+		_ = killProcess(run.cmd)
+		<-run.done
+	}
+}
+
+func (r *execRunner) start() error {
+	stdout := &prefixWriter{w: r.out, prefix: execPrefix}
+	stderr := &prefixWriter{w: r.errOut, prefix: execPrefix}
+	cmd := shellCommand(r.command)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	fmt.Fprintf(r.out, "---Running %s\n", r.command)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	run := &execRun{cmd: cmd, done: make(chan struct{})}
+	r.run = run
+	go func() {
+		err := cmd.Wait()
+		stdout.flush()
+		stderr.flush()
+		if atomic.LoadInt32(&run.stopping) == 0 {
+			if err != nil {
+				fmt.Fprintf(r.out, "---%s exited: %s\n", r.command, err)
+			} else {
+				fmt.Fprintf(r.out, "---%s exited\n", r.command)
+			}
+		}
+		close(run.done)
+	}()
+	return nil
+}
+
+// prefixWriter
+// Writes the prefix before each line, a partial line waits for its end or the flush.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (pw *prefixWriter) Write(data []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.partial = append(pw.partial, data...)
+	for {
+		idx := bytes.IndexByte(pw.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		if _, err := fmt.Fprintf(pw.w, "%s%s", pw.prefix, pw.partial[:idx+1]); err != nil {
+			return len(data), err
+		}
+		pw.partial = pw.partial[idx+1:]
+	}
+	return len(data), nil
+}
+
+// flush
+// Writes the last line when it has no end of line.
+func (pw *prefixWriter) flush() {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if len(pw.partial) > 0 {
+		fmt.Fprintf(pw.w, "%s%s\n", pw.prefix, pw.partial)
+		pw.partial = nil
+	}
+}
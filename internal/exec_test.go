@@ -0,0 +1,76 @@
+//go:build !windows
+// +build !windows
+
+package internal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer
+// A buffer written by the command while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(data []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(data)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	pw := &prefixWriter{w: &out, prefix: execPrefix}
+	pw.Write([]byte("one\ntw"))
+	pw.Write([]byte("o\nthree"))
+	assert.Equal(t, "[exec] one\n[exec] two\n", out.String())
+	pw.flush()
+	assert.Equal(t, "[exec] one\n[exec] two\n[exec] three\n", out.String())
+}
+
+func TestExecRunner(t *testing.T) {
+	out := &syncBuffer{}
+	runner := newExecRunner("echo started; exec sleep 30", out)
+	runner.errOut = out
+	defer runner.close()
+
+	runner.afterBuild(0)
+	waitFor(t, "the first run", func() bool { return strings.Count(out.String(), "[exec] started\n") == 1 })
+	first := runner.run
+
+	// A build with errors keeps the run
+	runner.afterBuild(2)
+	assert.Contains(t, out.String(), "---Not running echo started; exec sleep 30, the build has errors\n")
+	assert.Equal(t, first, runner.run)
+
+	// The next build stops it and runs again
+	runner.afterBuild(0)
+	<-first.done
+	waitFor(t, "the second run", func() bool { return strings.Count(out.String(), "[exec] started\n") == 2 })
+	assert.Contains(t, out.String(), "---Stopping echo started; exec sleep 30\n")
+	assert.NotContains(t, out.String(), "exited")
+
+	runner.close()
+	runner.afterBuild(0)
+	assert.Nil(t, runner.run)
+	assert.Equal(t, 2, strings.Count(out.String(), "[exec] started\n"))
+
+	// A command ending by itself is reported
+	out = &syncBuffer{}
+	runner = newExecRunner("printf partial; exit 3", out)
+	runner.afterBuild(0)
+	<-runner.run.done
+	assert.Equal(t, "---Running printf partial; exit 3\n[exec] partial\n---printf partial; exit 3 exited: exit status 3\n", out.String())
+}
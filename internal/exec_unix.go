@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package internal
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand
+// The command run by sh in its own process group, so the processes it starts are stopped with it.
+func shellCommand(command string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// interruptProcess
+// Sends SIGINT to the process group of the command.
+func interruptProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
+
+// killProcess
+// Kills the process group of the command.
+func killProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
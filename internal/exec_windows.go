@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package internal
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// shellCommand
+// The command run by cmd.
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+// interruptProcess
+// Windows has no interrupt for another console process, the tree is ended by taskkill.
+func interruptProcess(cmd *exec.Cmd) error {
+	return exec.Command("taskkill", "/T", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
+
+// killProcess
+// Kills the process tree of the command.
+func killProcess(cmd *exec.Cmd) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
@@ -0,0 +1,250 @@
+package internal
+
+import (
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"strings"
+
+	blipast "github.com/samlotti/blip/ast"
+)
+
+// Template
+// The parse tree as the public ast types.
+func (p *Parser) Template() *blipast.Template {
+	tmpl := &blipast.Template{
+		Span: blipast.Span{
+			Start: blipast.Pos{Offset: 0, Line: 1, Col: 1},
+			Stop:  blipast.Pos{Offset: p.lex.offset, Line: p.lex.lineNum, Col: p.lex.lPos},
+		},
+		Filename: p.lex.FName,
+	}
+	if p.name != nil {
+		tmpl.Name = &blipast.Name{Span: tokenSpan(p.name), Name: strings.TrimSpace(p.name.Literal)}
+	}
+	for _, tok := range p.imports {
+		tmpl.Imports = append(tmpl.Imports, &blipast.Import{Span: tokenSpan(tok), Spec: strings.TrimSpace(tok.Literal)})
+	}
+	for _, tok := range p.args {
+		// @arg a, b string declares a and b
+		for _, arg := range argVars([]*Token{tok}) {
+			tmpl.Args = append(tmpl.Args, &blipast.Arg{Span: tokenSpan(tok), Name: arg.name, Type: arg.typ})
+		}
+	}
+	for _, tok := range p.context {
+		name, typ := splitDecl(tok.Literal)
+		value := ""
+		if idx := strings.Index(typ, "="); idx >= 0 {
+			value = strings.TrimSpace(typ[idx+1:])
+			typ = strings.TrimSpace(typ[0:idx])
+		}
+		tmpl.Context = append(tmpl.Context, &blipast.Context{Span: tokenSpan(tok), Name: name, Type: typ, Value: value})
+	}
+	for _, fn := range p.functions {
+		base := fn.(*astBase)
+		tmpl.Funcs = append(tmpl.Funcs, &blipast.Func{Span: blockSpan(base), Code: childLiterals(base), CodePos: childrenPos(base)})
+	}
+	tmpl.Body = exportNodes(p.root.GetChildren())
+	return tmpl
+}
+
+func exportNodes(nodes []ast) []blipast.Node {
+	var list []blipast.Node
+	for _, node := range nodes {
+		if exported := exportNode(node.(*astBase)); exported != nil {
+			list = append(list, exported)
+		}
+	}
+	return list
+}
+
+func exportNode(node *astBase) blipast.Node {
+	tok := node.token
+	switch node.nodeType {
+	case NODE_TOKEN, NODE_TOKEN_RAW:
+		return &blipast.Text{Span: tokenSpan(tok), Text: tok.Literal}
+	case NODE_DISPLAY:
+		return exportDisplay(tok, blipast.DisplayEscaped)
+	case NODE_DISPLAY_RAW:
+		return exportDisplay(tok, blipast.DisplayRaw)
+	case NODE_DISPLAY_BOOL:
+		return exportDisplay(tok, blipast.DisplayBool)
+	case NODE_DISPLAY_INT:
+		return exportDisplay(tok, blipast.DisplayInt)
+	case NODE_DISPLAY_INT64:
+		return exportDisplay(tok, blipast.DisplayInt64)
+	case NODE_YIELD:
+		return &blipast.Yield{Span: tokenSpan(tok), Name: strings.TrimSpace(tok.Literal)}
+	case NODE_INCLUDE_SIMPLE:
+		name, args := splitCall(tok.Literal)
+		return &blipast.Include{Span: tokenSpan(tok), Template: name, Args: args}
+	case NODE_INCLUDE:
+		name, args := splitCall(tok.Literal)
+		extend := &blipast.Extend{Span: blockSpan(node), Template: name, Args: args}
+		for _, child := range exportNodes(node.children) {
+			if content, ok := child.(*blipast.Content); ok {
+				extend.Contents = append(extend.Contents, content)
+			}
+		}
+		return extend
+	case NODE_CONTENT:
+		return &blipast.Content{Span: blockSpan(node), Name: strings.TrimSpace(tok.Literal), Body: exportNodes(node.children)}
+	case NODE_CODEBLOCK:
+		return &blipast.Code{Span: blockSpan(node), Code: childLiterals(node), CodePos: childrenPos(node)}
+	case NODE_TEXT:
+		return &blipast.TextBlock{Span: blockSpan(node), Text: childLiterals(node)}
+	case NODE_IF:
+		return exportIf(node)
+	case NODE_FOR:
+		return exportFor(node)
+	}
+	// The @else / @end markers are part of their block
+	return nil
+}
+
+func exportDisplay(tok *Token, kind blipast.DisplayKind) *blipast.Display {
+	return &blipast.Display{
+		Span:    tokenSpan(tok),
+		Kind:    kind,
+		Expr:    strings.TrimSpace(tok.Literal),
+		ExprPos: literalPos(tok, len(tok.Literal)-len(strings.TrimLeft(tok.Literal, " \t"))),
+	}
+}
+
+func exportIf(node *astBase) *blipast.If {
+	tok := node.token
+	stmt := &blipast.If{
+		Span:    blockSpan(node),
+		Cond:    strings.TrimSpace(tok.Literal),
+		CondPos: literalPos(tok, len(tok.Literal)-len(strings.TrimLeft(tok.Literal, " \t"))),
+	}
+	var then []ast
+	var els []ast
+	inElse := false
+	for _, child := range node.children {
+		base := child.(*astBase)
+		switch {
+		case base.nodeType == NODE_ELSE:
+			inElse = true
+			stmt.ElsePos = tokenSpan(base.token).Start
+		case inElse:
+			els = append(els, child)
+		default:
+			then = append(then, child)
+		}
+	}
+	stmt.Then = exportNodes(then)
+	stmt.Else = exportNodes(els)
+	return stmt
+}
+
+func exportFor(node *astBase) *blipast.For {
+	tok := node.token
+	stmt := &blipast.For{Span: blockSpan(node), Body: exportNodes(node.children)}
+	fields := strings.Fields(tok.Literal)
+	if len(fields) > 0 {
+		stmt.Var = fields[0]
+	}
+	if idx := strings.Index(tok.Literal, " in "); idx >= 0 {
+		rest := tok.Literal[idx+len(" in "):]
+		stmt.Range = strings.TrimSpace(rest)
+		stmt.RangePos = literalPos(tok, len(tok.Literal)-len(strings.TrimLeft(rest, " \t")))
+	}
+	return stmt
+}
+
+// tokenSpan
+// From the start of the token to just after it.
+func tokenSpan(tok *Token) blipast.Span {
+	return blipast.Span{
+		Start: blipast.Pos{Offset: tok.Offset, Line: tok.Line, Col: tok.Pos},
+		Stop:  blipast.Pos{Offset: tok.EndOffset, Line: tok.EndLine, Col: tok.EndPos},
+	}
+}
+
+// blockSpan
+// From the command opening the block to just after its @end, the end is not valid when the @end is missing.
+func blockSpan(node *astBase) blipast.Span {
+	span := tokenSpan(node.token)
+	span.Stop = blipast.Pos{}
+	if node.end != nil {
+		span.Stop = tokenSpan(node.end).Stop
+	}
+	return span
+}
+
+// literalPos
+// The position of the byte offset within the token literal.
+func literalPos(tok *Token, offset int) blipast.Pos {
+	line, col := tok.PosAt(offset)
+	return blipast.Pos{Offset: tok.LitOffset + offset, Line: line, Col: col}
+}
+
+// splitDecl
+// name type, as in @arg and @context.
+func splitDecl(literal string) (string, string) {
+	splits := strings.SplitN(strings.TrimSpace(literal), " ", 2)
+	if len(splits) < 2 {
+		return splits[0], ""
+	}
+	return splits[0], strings.TrimSpace(splits[1])
+}
+
+// argVar
+// A name declared by an @arg and its type.
+type argVar struct {
+	name string
+	typ  string
+}
+
+// argVars
+// The names declared by the @arg with their type, in order: @arg a, b string declares a and b.
+// Each @arg is parsed as a go field list, one that does not parse is split at the first space.
+func argVars(args []*Token) []argVar {
+	var vars []argVar
+	for _, arg := range args {
+		literal := strings.TrimSpace(arg.Literal)
+		src := "package p\nfunc _(" + literal + ")"
+		fset := gotoken.NewFileSet()
+		file, err := goparser.ParseFile(fset, "", src, 0)
+		if err != nil || len(file.Decls) != 1 {
+			name, typ := splitDecl(literal)
+			vars = append(vars, argVar{name: name, typ: typ})
+			continue
+		}
+		for _, field := range file.Decls[0].(*goast.FuncDecl).Type.Params.List {
+			typ := src[fset.Position(field.Type.Pos()).Offset:fset.Position(field.Type.End()).Offset]
+			for _, name := range field.Names {
+				vars = append(vars, argVar{name: name.Name, typ: typ})
+			}
+		}
+	}
+	return vars
+}
+
+// splitCall
+// The template and arguments of an @include / @extend.
+func splitCall(literal string) (string, []string) {
+	splits := strings.SplitN(strings.TrimSpace(literal), " ", 2)
+	if len(splits) < 2 {
+		return splits[0], nil
+	}
+	return splits[0], splitCallArgs(splits[1])
+}
+
+// childrenPos
+// The start of the literals of a block, just after the command when it is empty.
+func childrenPos(node *astBase) blipast.Pos {
+	if len(node.children) > 0 {
+		return tokenSpan(node.children[0].GetToken()).Start
+	}
+	return tokenSpan(node.token).Stop
+}
+
+func childLiterals(node *astBase) string {
+	var sb strings.Builder
+	for _, child := range node.children {
+		sb.WriteString(child.GetToken().Literal)
+	}
+	return sb.String()
+}
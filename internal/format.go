@@ -0,0 +1,422 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	goformat "go/format"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/pmezard/go-difflib/difflib"
+	blipast "github.com/samlotti/blip/ast"
+)
+
+// FormatUsage
+// What blip fmt changes, for its usage.
+const FormatUsage = `Rewrites the templates to the canonical form, the output of a template is preserved byte for byte:
+  - the spacing of the commands is normalised and their go code gofmt-ed, ex: @= x@ becomes @= x @
+  - the @code and @func bodies are gofmt-ed and indented from their command
+  - the nested blocks of @if, @for, @content... are not indented: the white space before a command and in the text
+    is output, indenting them would change the output. The indentation of the template is kept as written.
+`
+
+// Format
+// The template in canonical form, see blip fmt:
+//   - one space around the go code of the commands, ex: @= x @, @for n in list, and the go code gofmt-ed
+//   - the @code and @func bodies gofmt-ed and indented from their command
+//
+// The text and the white space around the commands are output, they are kept as is.
+// Templates with errors are not formatted, the PError is returned.
+func Format(src []byte, filename string) ([]byte, error) {
+	parser := New(NewLexer(string(src), filename))
+	parser.Parse()
+	if parser.hasErrors() {
+		return nil, parser.errors[0]
+	}
+
+	f := &formatter{src: string(src)}
+	lex := NewLexer(string(src), filename)
+	lex.KeepComments = true
+	f.format(lex)
+	out := f.out.Bytes()
+
+	// The output must not change
+	formatted := New(NewLexer(string(out), filename))
+	formatted.Parse()
+	if formatted.hasErrors() || !equalOutput(outputOf(parser.Template()), outputOf(formatted.Template())) {
+		return nil, fmt.Errorf("formatting would change the output of the template")
+	}
+	return out, nil
+}
+
+type formatter struct {
+	src        string
+	out        bytes.Buffer
+	lineStart  bool // At the start of a line, pending has the white space written since
+	pending    string
+	lineIndent string // The white space before the last command alone on its line
+}
+
+func (f *formatter) format(lex *Lexer) {
+	f.lineStart = true
+	for {
+		tok := lex.NextToken()
+		source := f.src[tok.Offset:tok.EndOffset]
+		switch tok.Type {
+		case EOF:
+			f.flush()
+			return
+		case LITERAL:
+			f.writeLiteral(source)
+		case COMMENT:
+			f.writeCommand(tok, strings.TrimRight(source, " \t\n"), newline(source))
+		case ATDisplay, ATDisplayUnsafe, ATDisplayBool, ATDisplayInt, ATDisplayInt64:
+			f.flush()
+			f.out.WriteString(fmt.Sprintf("%s %s @", tok.Type, formatExpr(tok.Literal)))
+		case IF:
+			f.writeCommand(tok, "@if "+formatExpr(tok.Literal), newline(source))
+		case FOR:
+			fields := (&Parser{}).splitFor(tok)
+			f.writeCommand(tok, fmt.Sprintf("@for %s in %s", fields[0], formatExpr(fields[2])), newline(source))
+		case EXTEND:
+			f.writeCommand(tok, "@extend "+formatCall(tok.Literal), newline(source))
+		case CONTENT:
+			f.writeCommand(tok, "@content "+strings.TrimSpace(tok.Literal), newline(source))
+		case INCLUDE:
+			f.writeCommand(tok, "@include "+formatCall(tok.Literal), newline(source))
+		case YIELD, IMPORT, NAME:
+			f.writeCommand(tok, fmt.Sprintf("%s %s", tok.Type, strings.TrimSpace(tok.Literal)), newline(source))
+		case ARG, CONTEXT:
+			f.writeCommand(tok, fmt.Sprintf("%s %s", tok.Type, collapseSpaces(tok.Literal)), newline(source))
+		case ELSE:
+			f.writeCommand(tok, "@else", "")
+		case END:
+			f.writeCommand(tok, "@end", "")
+		case TEXT, STARTBLOCK, FUNCTS:
+			f.writeCommand(tok, string(tok.Type), "")
+			f.writeBody(tok.Type, lex)
+		}
+	}
+}
+
+// writeLiteral
+// Output text, the white space at the start of a line is kept pending until it is known if a command follows.
+func (f *formatter) writeLiteral(text string) {
+	for len(text) > 0 {
+		idx := strings.IndexByte(text, '\n')
+		line := text
+		if idx >= 0 {
+			line = text[0 : idx+1]
+		}
+		text = text[len(line):]
+
+		if f.lineStart {
+			trimmed := strings.TrimLeft(line, " \t")
+			f.pending += line[0 : len(line)-len(trimmed)]
+			line = trimmed
+			if line == "" {
+				continue
+			}
+			f.flush()
+		}
+		f.out.WriteString(line)
+		f.lineStart = strings.HasSuffix(line, "\n")
+	}
+}
+
+// flush
+// Writes the pending white space unchanged.
+func (f *formatter) flush() {
+	f.out.WriteString(f.pending)
+	f.pending = ""
+	f.lineStart = false
+}
+
+// writeCommand
+// A command, the white space before it is kept.
+func (f *formatter) writeCommand(tok *Token, text string, nl string) {
+	f.lineIndent = ""
+	if f.lineStart {
+		f.lineIndent = f.pending
+	}
+	f.flush()
+	f.out.WriteString(text)
+	f.out.WriteString(nl)
+	f.lineStart = nl != ""
+}
+
+// writeBody
+// The body of a @text, @code or @func and its @end.
+// @text is output so it is unchanged, the go code is gofmt-ed and indented from the command.
+func (f *formatter) writeBody(block TokenType, lex *Lexer) {
+	body := ""
+	if tok := lex.NextToken(); tok.Type == LITERAL {
+		body = f.src[tok.Offset:tok.EndOffset]
+		// The @end
+		lex.NextToken()
+	}
+
+	code, ok := formatGo(body, block == STARTBLOCK)
+	if block == TEXT || !ok {
+		f.out.WriteString(body)
+	} else {
+		tabs := f.lineIndent
+		if block == STARTBLOCK {
+			tabs += "\t"
+		}
+		f.out.WriteString("\n")
+		if code != "" {
+			for _, line := range strings.Split(code, "\n") {
+				if line != "" {
+					f.out.WriteString(tabs + line)
+				}
+				f.out.WriteString("\n")
+			}
+		}
+		f.out.WriteString(f.lineIndent)
+	}
+	f.out.WriteString("@end")
+	f.lineStart = false
+}
+
+// newline
+// The newline consumed by a command that takes the rest of the line.
+func newline(source string) string {
+	if strings.HasSuffix(source, "\n") {
+		return "\n"
+	}
+	return ""
+}
+
+// formatExpr
+// The go expression gofmt-ed, or with its spaces collapsed when it cannot be parsed.
+func formatExpr(text string) string {
+	text = strings.TrimSpace(text)
+	expr, err := goparser.ParseExpr(text)
+	if err != nil {
+		return collapseSpaces(text)
+	}
+	var buf bytes.Buffer
+	if err := goformat.Node(&buf, gotoken.NewFileSet(), expr); err != nil || strings.Contains(buf.String(), "\n") {
+		return collapseSpaces(text)
+	}
+	return buf.String()
+}
+
+// formatCall
+// The template and gofmt-ed arguments of an @include / @extend.
+func formatCall(literal string) string {
+	name, args := splitCall(literal)
+	for idx, arg := range args {
+		args[idx] = formatExpr(arg)
+	}
+	if len(args) == 0 {
+		return name
+	}
+	return name + " " + strings.Join(args, ", ")
+}
+
+// formatGo
+// gofmt of a @code body (statements) or @func body (declarations), without the surrounding function or package.
+func formatGo(body string, statements bool) (string, bool) {
+	if strings.TrimSpace(body) == "" {
+		return "", true
+	}
+	src := "package blip\n" + body
+	if statements {
+		src = "package blip\nfunc _() {\n" + body + "\n}\n"
+	}
+	formatted, err := goformat.Source([]byte(src))
+	if err != nil {
+		return "", false
+	}
+	code := strings.TrimPrefix(string(formatted), "package blip\n")
+	if statements {
+		code = strings.TrimPrefix(code, "\nfunc _() {\n")
+		code = strings.TrimSuffix(code, "}\n")
+		// Remove the indentation of the function body
+		lines := strings.Split(code, "\n")
+		for idx, line := range lines {
+			lines[idx] = strings.TrimPrefix(line, "\t")
+		}
+		code = strings.Join(lines, "\n")
+	}
+	return strings.Trim(code, "\n"), true
+}
+
+// collapseSpaces
+// Runs of spaces and tabs replaced by one space, except in go string and rune literals.
+func collapseSpaces(text string) string {
+	var sb strings.Builder
+	var quote rune
+	escaped := false
+	space := false
+	for _, ch := range strings.TrimSpace(text) {
+		if quote != 0 {
+			sb.WriteRune(ch)
+			if escaped {
+				escaped = false
+			} else if ch == '\\' && quote != '`' {
+				escaped = true
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		if ch == ' ' || ch == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			sb.WriteRune(' ')
+			space = false
+		}
+		if ch == '"' || ch == '\'' || ch == '`' {
+			quote = ch
+		}
+		sb.WriteRune(ch)
+	}
+	return sb.String()
+}
+
+// outputOf
+// What the template writes, to check that formatting does not change it.
+// The text is compared byte for byte, the go code without white space.
+func outputOf(tmpl *blipast.Template) []string {
+	var out []string
+	noSpace := func(text string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, text)
+	}
+	blipast.Inspect(tmpl, func(node blipast.Node) bool {
+		switch n := node.(type) {
+		case *blipast.Text:
+			out = append(out, "text:"+n.Text)
+		case *blipast.TextBlock:
+			out = append(out, "@text:"+n.Text)
+		case *blipast.Display:
+			out = append(out, n.Kind.String()+noSpace(n.Expr))
+		case *blipast.Include:
+			out = append(out, "@include:"+n.Template+noSpace(strings.Join(n.Args, ",")))
+		case *blipast.Extend:
+			out = append(out, "@extend:"+n.Template+noSpace(strings.Join(n.Args, ",")))
+		case *blipast.Content:
+			out = append(out, "@content:"+n.Name)
+		case *blipast.Yield:
+			out = append(out, "@yield:"+n.Name)
+		case *blipast.If:
+			out = append(out, "@if:"+noSpace(n.Cond))
+		case *blipast.For:
+			out = append(out, "@for:"+n.Var+noSpace(n.Range))
+		case *blipast.Code:
+			out = append(out, "@code:"+noSpace(n.Code))
+		case *blipast.Func:
+			out = append(out, "@func:"+noSpace(n.Code))
+		case *blipast.Name:
+			out = append(out, "@name:"+n.Name)
+		case *blipast.Import:
+			out = append(out, "@import:"+noSpace(n.Spec))
+		case *blipast.Arg:
+			out = append(out, "@arg:"+n.Name+noSpace(n.Type))
+		case *blipast.Context:
+			out = append(out, "@context:"+n.Name+noSpace(n.Type)+noSpace(n.Value))
+		case nil:
+			out = append(out, "end")
+		}
+		return true
+	})
+	return out
+}
+
+func equalOutput(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// GteFormat
+// blip fmt: formats the templates of the paths, files or directories, the -dir of the options when there are none.
+// With write the templates are rewritten, with diff a unified diff is printed, otherwise the formatted templates.
+// Returns the number of diagnostics.
+func GteFormat(opt *BlipOptions, paths []string, write bool, diff bool) int {
+	if len(paths) == 0 {
+		paths = opt.roots()
+	}
+	diags := &diagnostics{}
+	for _, root := range paths {
+		err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				diags.addError(name, err)
+				return nil
+			}
+			if info.IsDir() || (name != root && !IsTemplateFile(info.Name())) {
+				return nil
+			}
+			formatFile(name, write, diff, diags)
+			return nil
+		})
+		if err != nil {
+			diags.addError(root, err)
+		}
+	}
+	return reportDiagnostics(diags, opt)
+}
+
+func formatFile(name string, write bool, diff bool, diags *diagnostics) {
+	bf := newBlipFile("", filepath.Dir(name), filepath.Base(name))
+	if bf == nil {
+		diags.add(Diagnostic{File: name, Message: "not a template, the file name must contain .blip"})
+		return
+	}
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		diags.addError(name, err)
+		return
+	}
+	out, err := Format(src, name)
+	if perr, ok := err.(PError); ok {
+		diags.add(Diagnostic{File: name, Line: perr.lineNum, Col: perr.linePos, Message: strings.TrimSpace(perr.msg)})
+		return
+	}
+	if err != nil {
+		diags.addError(name, err)
+		return
+	}
+
+	switch {
+	case write:
+		if !bytes.Equal(src, out) {
+			if err := ioutil.WriteFile(name, out, 0666); err != nil {
+				diags.addError(name, err)
+			}
+		}
+	case diff:
+		if !bytes.Equal(src, out) {
+			text, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(string(src)),
+				B:        difflib.SplitLines(string(out)),
+				FromFile: name + ".orig",
+				ToFile:   name,
+				Context:  3,
+			})
+			fmt.Print(text)
+		}
+	default:
+		os.Stdout.Write(out)
+	}
+}
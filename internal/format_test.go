@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	src := `@import   "strings"
+@arg names  []string
+@// a comment
+<ul>
+@for  n  in  names
+  <li>@= strings.ToUpper( n )@</li>
+      @if len(n)==0
+<b>empty</b>
+  @else
+  @int= len(n)@
+        @end
+@end
+</ul>
+<pre>
+  @if true
+  x
+  @end
+</pre>
+@code
+x:=1
+  _ = x
+@end
+@func
+func   a() {   }
+@end
+`
+	expected := `@import "strings"
+@arg names []string
+@// a comment
+<ul>
+@for n in names
+  <li>@= strings.ToUpper(n) @</li>
+      @if len(n) == 0
+<b>empty</b>
+  @else
+  @int= len(n) @
+        @end
+@end
+</ul>
+<pre>
+  @if true
+  x
+  @end
+</pre>
+@code
+	x := 1
+	_ = x
+@end
+@func
+func a() {}
+@end
+`
+	out, err := Format([]byte(src), "list.blip.html")
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(out))
+
+	// Formatted templates do not change
+	again, err := Format(out, "list.blip.html")
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(again))
+
+	// The white space around the commands is output, it is kept
+	parse := func(src string) []string {
+		parser := New(NewLexer(src, "list.blip.html"))
+		parser.Parse()
+		return outputOf(parser.Template())
+	}
+	assert.Equal(t, parse(src), parse(expected))
+	assert.NotEqual(t, parse("<ul>\n  @if true\n  x\n  @end\n"), parse("<ul>\n\t@if true\n\t\tx\n\t@end\n"))
+}
+
+func TestFormatText(t *testing.T) {
+	// The white space of text templates is output, it is kept
+	src := "Hello @= name@,\n  @if len(items)>0\n  Items:\n    @for i in items\n  - @= i @\n    @end\n  @end\n"
+	out, err := Format([]byte(src), "mail.blip.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello @= name @,\n  @if len(items) > 0\n  Items:\n    @for i in items\n  - @= i @\n    @end\n  @end\n", string(out))
+}
+
+func TestFormatErrors(t *testing.T) {
+	_, err := Format([]byte("@if x\n"), "x.blip.html")
+	perr, ok := err.(PError)
+	assert.True(t, ok)
+	assert.Equal(t, 1, perr.lineNum)
+	assert.Equal(t, "1:1: @if : block is not closed, expected @end", err.Error())
+}
+
+func TestFormatNestedBlocks(t *testing.T) {
+	// Only the go code is indented, the white space before the commands and in the text is output
+	src := "@for n in names\n@if n != \"\"\n<li>@= n @</li>\n@code\nx:=n\n_ = x\n@end\n@end\n@end\n"
+	out, err := Format([]byte(src), "list.blip.html")
+	assert.Nil(t, err)
+	assert.Equal(t, "@for n in names\n@if n != \"\"\n<li>@= n @</li>\n@code\n\tx := n\n\t_ = x\n@end\n@end\n@end\n", string(out))
+}
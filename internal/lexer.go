@@ -2,22 +2,52 @@ package internal
 
 import (
 	"fmt"
+	"strings"
+	"unicode/utf8"
 )
 
 // TokenType == May be better as an integer!
 type TokenType string
 
 type Token struct {
-	Type    TokenType
-	Literal string
-	Line    int
-	Pos     int
+	Type      TokenType
+	Literal   string
+	Line      int // Line of the start of the token
+	Pos       int // Position on the line of the start of the token
+	LitLine   int // Line where the Literal starts, for commands this is after the command name
+	LitPos    int // Position on the line where the Literal starts
+	LitOffset int // Byte offset of the start of the Literal
+	Offset    int // Byte offset of the start of the token
+
+	// Just after the token, the start of the next one
+	EndLine   int
+	EndPos    int
+	EndOffset int
+}
+
+// PosAt
+// Returns the line and position of the byte offset within the Literal.
+func (t *Token) PosAt(offset int) (int, int) {
+	line, pos := t.LitLine, t.LitPos
+	for idx, ch := range t.Literal {
+		if idx >= offset {
+			break
+		}
+		if ch == '\n' {
+			line += 1
+			pos = 1
+		} else {
+			pos += 1
+		}
+	}
+	return line, pos
 }
 
 const (
 	ILLEGAL = "Illegal"
 	EOF     = "Eof"
 	EOL     = '\n'
+	COMMENT = "Comment" // @// and @* *@, only with KeepComments
 
 	LITERAL         = "LITERAL"
 	ARG             = "@arg"     // Literal will be the remainder of the line
@@ -28,6 +58,7 @@ const (
 	ATDisplay       = "@="       // Literal will be up to the eol/eof or next @   @= name @
 	ATDisplayUnsafe = "@=="      // Literal will be up to the eol/eof or next @   @= name @
 	IMPORT          = "@import"  // Placed at the begging for go imports
+	NAME            = "@name"    // The template name, the render function is named by it in place of the file name
 	INCLUDE         = "@include" // includes another template but no embedded content
 	EXTEND          = "@extend"  // includes another template
 	CONTENT         = "@content" // The content to embed
@@ -52,7 +83,15 @@ type Lexer struct {
 	readPosition int  // The next position
 	ch           rune // current character
 	priorToken   Token
+	KeepComments bool // Returns the comments as COMMENT tokens, ex: to format the template
 	literalMode  bool // Set to true after @func , @code, @text, reads up to the @end
+	tokLine      int  // Line of the start of the token being read
+	tokPos       int  // Position of the start of the token being read
+	litLine      int  // Line of the start of the literal being read
+	litPos       int  // Position of the start of the literal being read
+	offset       int  // Byte offset of the current character
+	tokOffset    int  // Byte offset of the start of the token being read
+	litOffset    int  // Byte offset of the start of the literal being read
 }
 
 func NewLexer(input string, fname string) *Lexer {
@@ -70,14 +109,20 @@ func (l *Lexer) readChar() {
 		return
 	}
 
+	if l.readPosition > 0 {
+		l.offset += utf8.RuneLen(l.ch)
+	}
+
+	if l.ch == '\n' {
+		// The newline belongs to the line it ends
+		l.lineNum += 1
+		l.lPos = 0
+	}
+
 	if l.readPosition >= len(l.runes) {
 		l.ch = 0
 	} else {
 		l.ch = l.runes[l.readPosition]
-		if l.ch == '\n' {
-			l.lineNum += 1
-			l.lPos = 0
-		}
 	}
 	l.position = l.readPosition
 	l.lPos += 1
@@ -105,17 +150,28 @@ func (l *Lexer) peekChar() rune {
 }
 
 func (l *Lexer) newToken(tokenType TokenType, ch rune) Token {
-	var r = Token{Type: tokenType, Literal: string(ch), Line: l.lineNum, Pos: l.lPos}
-	l.priorToken = r
-	return r
+	return l.newTokenStr(tokenType, string(ch))
 }
 
 func (l *Lexer) newTokenStr(tokenType TokenType, ch string) Token {
-	var r = Token{Type: tokenType, Literal: ch, Line: l.lineNum, Pos: l.lPos}
+	var r = Token{Type: tokenType, Literal: ch, Line: l.tokLine, Pos: l.tokPos, LitLine: l.litLine, LitPos: l.litPos, LitOffset: l.litOffset, Offset: l.tokOffset}
 	l.priorToken = r
 	return r
 }
 
+// markToken
+// Records the current character as the start of the token and its literal.
+func (l *Lexer) markToken() {
+	l.tokLine, l.tokPos, l.tokOffset = l.lineNum, l.lPos, l.offset
+	l.markLiteral()
+}
+
+// markLiteral
+// Records the current character as the start of the literal.
+func (l *Lexer) markLiteral() {
+	l.litLine, l.litPos, l.litOffset = l.lineNum, l.lPos, l.offset
+}
+
 func (l *Lexer) PriorToken() *Token {
 	return &l.priorToken
 }
@@ -124,7 +180,14 @@ func (l *Lexer) PriorToken() *Token {
 // On input  l.ch is the current character
 // On output should be at next character after token required characters.
 // peekChar will be looking at the next character
-func (l *Lexer) NextToken() (tk *Token) {
+func (l *Lexer) NextToken() *Token {
+	tk := l.nextToken()
+	tk.EndLine, tk.EndPos, tk.EndOffset = l.lineNum, l.lPos, l.offset
+	l.priorToken = *tk
+	return tk
+}
+
+func (l *Lexer) nextToken() (tk *Token) {
 
 	defer func() {
 		if err := recover(); err != nil {
@@ -135,6 +198,8 @@ func (l *Lexer) NextToken() (tk *Token) {
 
 	var tok Token
 
+	l.markToken()
+
 	if l.isEOF() {
 		tok := l.newToken(EOF, '0')
 		return &tok
@@ -149,15 +214,25 @@ func (l *Lexer) NextToken() (tk *Token) {
 	case '@':
 		// @//
 		if l.peekChar() == '/' && l.peekCharAt(1) == '/' {
+			pos := l.position
 			l.readTil('\n')
 			l.readChar()
-			return l.NextToken()
+			if l.KeepComments {
+				tok = l.newTokenStr(COMMENT, string(l.runes[pos:l.position]))
+				return &tok
+			}
+			return l.nextToken()
 		}
 
 		if l.peekChar() == '*' {
+			pos := l.position
 			l.bypassMultilineComment()
 			l.readChar()
-			return l.NextToken()
+			if l.KeepComments {
+				tok = l.newTokenStr(COMMENT, string(l.runes[pos:l.position]))
+				return &tok
+			}
+			return l.nextToken()
 		}
 
 		if l.peekChar() == '@' {
@@ -239,42 +314,42 @@ func (l *Lexer) isAt() bool {
 	return l.isChar('@')
 }
 
-func (l *Lexer) readTilStr(str []rune) string {
+// readTilStrSingleLine
+// Reads up to str on the same line, ok is false when the end of the line or file is found first.
+// The lexer is then left on the newline.
+func (l *Lexer) readTilStrSingleLine(str []rune) (string, bool) {
 	pos := l.position
-	for !l.isStr(str) && !l.isEOF() {
+	for !l.isStr(str) && !l.isEOF() && !l.isEOL() {
 		l.readChar()
 	}
 
-	if l.isEOF() {
-		panic(fmt.Sprintf("Expected to find '%s' not found.", string(str)))
+	if l.isEOL() || l.isEOF() {
+		return "", false
 	}
 
 	posend := l.position
 	for range str {
 		l.readChar()
 	}
-	return string(l.runes[pos : posend+1])
+	return string(l.runes[pos : posend+1]), true
 }
 
-func (l *Lexer) readTilStrSingleLine(str []rune) string {
-	pos := l.position
-	for !l.isStr(str) && !l.isEOF() && !l.isEOL() {
-		l.readChar()
-	}
-
-	if l.isEOL() {
-		panic(fmt.Sprintf("Expected to find '%s' on same line, not found.", string(str)))
-	}
-
-	if l.isEOF() {
-		panic(fmt.Sprintf("Expected to find '%s' not found.", string(str)))
+// readDisplay
+// The display command up to the closing @, an ILLEGAL token when it is not closed on the line.
+func (l *Lexer) readDisplay(tokenType TokenType) (Token, bool) {
+	literal, ok := l.readTilStrSingleLine([]rune{'@'})
+	if !ok {
+		return l.newTokenStr(ILLEGAL, fmt.Sprintf("%s is not closed, expected @ before the end of the line", tokenType)), false
 	}
+	return l.newTokenStr(tokenType, literal), true
+}
 
-	posend := l.position
-	for range str {
+// skipLine
+// Moves to the newline, or the end of file, after an error in a command.
+func (l *Lexer) skipLine() {
+	for !l.isEOL() && !l.isEOF() {
 		l.readChar()
 	}
-	return string(l.runes[pos : posend+1])
 }
 
 func (l *Lexer) readTil(char rune) string {
@@ -320,6 +395,7 @@ func (l *Lexer) pickCommand() Token {
 	if l.ch != '\n' {
 		l.readChar()
 	}
+	l.markLiteral()
 
 	advance := false
 
@@ -349,22 +425,17 @@ func (l *Lexer) pickCommand() Token {
 		//}
 		advance = true
 	case "@bool=":
-		tkn = l.newTokenStr(ATDisplayBool, l.readTilStrSingleLine([]rune{'@'}))
-		advance = true
+		tkn, advance = l.readDisplay(ATDisplayBool)
 	case "@int=":
-		tkn = l.newTokenStr(ATDisplayInt, l.readTilStrSingleLine([]rune{'@'}))
-		advance = true
+		tkn, advance = l.readDisplay(ATDisplayInt)
 	case "@int64=":
-		tkn = l.newTokenStr(ATDisplayInt64, l.readTilStrSingleLine([]rune{'@'}))
-		advance = true
+		tkn, advance = l.readDisplay(ATDisplayInt64)
 	case "@==":
 		// tkn = l.newTokenStr(ATDisplayUnsafe, l.readTils([]rune{EOL, '@'}))
-		tkn = l.newTokenStr(ATDisplayUnsafe, l.readTilStrSingleLine([]rune{'@'}))
-		advance = true
+		tkn, advance = l.readDisplay(ATDisplayUnsafe)
 	case "@=":
 		// tkn = l.newTokenStr(ATDisplay, l.readTils([]rune{EOL, '@'}))
-		tkn = l.newTokenStr(ATDisplay, l.readTilStrSingleLine([]rune{'@'}))
-		advance = true
+		tkn, advance = l.readDisplay(ATDisplay)
 		// advance because we consumed more
 	case "@arg":
 		tkn = l.newTokenStr(ARG, l.readTil(EOL))
@@ -384,6 +455,10 @@ func (l *Lexer) pickCommand() Token {
 		tkn = l.newTokenStr(IMPORT, l.readTil(EOL))
 		// consume the eol
 		advance = true
+	case "@name":
+		tkn = l.newTokenStr(NAME, l.readTil(EOL))
+		// consume the eol
+		advance = true
 	case "@text":
 		tkn = l.newTokenStr(TEXT, cmd)
 		l.literalMode = true
@@ -397,7 +472,9 @@ func (l *Lexer) pickCommand() Token {
 		l.literalMode = true
 		advance = false
 	default:
-		tkn = l.newTokenStr(ILLEGAL, fmt.Sprintf("Invalid command found: %s", cmd))
+		tkn = l.newTokenStr(ILLEGAL, invalidCommandMessage(cmd))
+		// Continue at the next line
+		l.skipLine()
 		advance = false
 	}
 
@@ -421,3 +498,85 @@ func (l *Lexer) bypassMultilineComment() {
 		l.readChar()
 	}
 }
+
+// commands
+// The command names, used to suggest the command meant by an invalid one.
+var commands = []string{
+	ARG, CONTEXT, ATDisplayBool, ATDisplayInt, ATDisplayInt64, ATDisplay, ATDisplayUnsafe, IMPORT, NAME,
+	INCLUDE, EXTEND, CONTENT, YIELD, STARTBLOCK, FUNCTS, TEXT, IF, ELSE, END, FOR,
+}
+
+// invalidCommandMessage
+// The error for an invalid command, with the command it looks like when there is one.
+// ex: Invalid command found: @incude, did you mean @include?
+func invalidCommandMessage(cmd string) string {
+	// The command name ends at the first character that cannot be part of one, ex: @nme</p>
+	end := 1
+	for end < len(cmd) && (isLetter(rune(cmd[end])) || cmd[end] == '=' || (cmd[end] >= '0' && cmd[end] <= '9')) {
+		end += 1
+	}
+	name := cmd[0:end]
+	if name == "@" {
+		return "Invalid command found: @, use @@ to write a literal @"
+	}
+	msg := fmt.Sprintf("Invalid command found: %s", name)
+	if suggestion := suggestCommand(name); suggestion != "" {
+		msg += fmt.Sprintf(", did you mean %s?", suggestion)
+	}
+	return msg
+}
+
+func isLetter(ch rune) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+// suggestCommand
+// The command closest to name by edit distance, empty if none is close enough.
+func suggestCommand(name string) string {
+	best := ""
+	bestDist := 0
+	for _, cmd := range commands {
+		dist := editDistance(strings.ToLower(name), cmd)
+		if best == "" || dist < bestDist {
+			best, bestDist = cmd, dist
+		}
+	}
+	// Allow about one typo for every three characters of the name
+	if bestDist == 0 || bestDist > (len(name)+1)/3 {
+		return ""
+	}
+	return best
+}
+
+// editDistance
+// The number of inserts, deletes, replaces and swaps of two adjacent characters to change a into b.
+func editDistance(a string, b string) int {
+	dist := make([][]int, len(a)+1)
+	for i := range dist {
+		dist[i] = make([]int, len(b)+1)
+		dist[i][0] = i
+	}
+	for j := range dist[0] {
+		dist[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d := dist[i-1][j-1] + cost
+			if dist[i-1][j]+1 < d {
+				d = dist[i-1][j] + 1
+			}
+			if dist[i][j-1]+1 < d {
+				d = dist[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && dist[i-2][j-2]+1 < d {
+				d = dist[i-2][j-2] + 1
+			}
+			dist[i][j] = d
+		}
+	}
+	return dist[len(a)][len(b)]
+}
@@ -428,3 +428,49 @@ func TestCodeBaseIf(t *testing.T) {
 	fmt.Printf("M:%s at %d\n", tk.Literal, tk.Line)
 
 }
+
+func TestTokenPositions(t *testing.T) {
+	sample := `<h1>
+@if user != nil
+	@=  user.Name @
+@end`
+	lex := NewLexer(sample, "TestLexer1")
+	tkn := lex.NextToken()
+	assert.Equal(t, LITERAL, string(tkn.Type))
+	assert.Equal(t, 1, tkn.Line)
+	assert.Equal(t, 1, tkn.Pos)
+
+	tkn = lex.NextToken()
+	assert.Equal(t, IF, string(tkn.Type))
+	assert.Equal(t, 2, tkn.Line)
+	assert.Equal(t, 1, tkn.Pos)
+	assert.Equal(t, 2, tkn.LitLine)
+	assert.Equal(t, 5, tkn.LitPos)
+
+	tkn = lex.NextToken()
+	assert.Equal(t, LITERAL, string(tkn.Type))
+	assert.Equal(t, 3, tkn.Line)
+
+	tkn = lex.NextToken()
+	assert.Equal(t, ATDisplay, string(tkn.Type))
+	assert.Equal(t, 3, tkn.Line)
+	assert.Equal(t, 2, tkn.Pos)
+	line, pos := tkn.PosAt(1)
+	assert.Equal(t, 3, line)
+	assert.Equal(t, 6, pos)
+
+	tkn = lex.NextToken()
+	tkn = lex.NextToken()
+	assert.Equal(t, END, string(tkn.Type))
+	assert.Equal(t, 4, tkn.Line)
+	assert.Equal(t, 1, tkn.Pos)
+}
+
+func TestSuggestCommand(t *testing.T) {
+	assert.Equal(t, "@include", suggestCommand("@incude"))
+	assert.Equal(t, "@content", suggestCommand("@contnt"))
+	assert.Equal(t, "@for", suggestCommand("@fro"))
+	assert.Equal(t, "", suggestCommand("@badCommand"))
+	assert.Equal(t, "Invalid command found: @nme, did you mean @name?", invalidCommandMessage("@nme</p>"))
+	assert.Equal(t, "Invalid command found: @, use @@ to write a literal @", invalidCommandMessage("@"))
+}
@@ -0,0 +1,740 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	blipast "github.com/samlotti/blip/ast"
+)
+
+// GteLsp
+// Runs the language server on stdin / stdout until the client exits.
+// Returns the exit status, 1 when the client exits without a shutdown.
+func GteLsp() int {
+	server := NewLspServer(os.Stdin, os.Stdout)
+	if err := server.Serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "blip lsp: %s\n", err)
+		return 1
+	}
+	if !server.shutdown {
+		return 1
+	}
+	return 0
+}
+
+// LSP constants used by the server
+const (
+	lspSyncFull      = 1
+	lspSeverityError = 1
+	lspKindKeyword   = 14
+	lspKindProperty  = 10
+
+	lspErrMethodNotFound = -32601
+	lspErrInvalidParams  = -32602
+
+	lspFileDeleted = 3
+)
+
+// LspServer
+// A language server for the templates, speaking JSON-RPC with the Content-Length framing of LSP.
+// The templates of the workspace are read from disk at initialize, the open documents replace them.
+// The files changed on disk are read again when the client reports them.
+type LspServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	mu       sync.Mutex // Serializes the writes
+	root     string
+	files    map[string]*blipFile // path -> template of the workspace, with its text on disk
+	packages map[string]string    // directory -> go package of the hand written files
+	layout   map[string]*blipFile // path -> template of the build, generated where blip.yaml says
+	docs     map[string]string    // path -> text of the open documents
+	symbols  *symbolTable         // The templates parsed, nil after a change
+	watch    bool                 // The client registers file watchers for the server
+	shutdown bool
+}
+
+func NewLspServer(in io.Reader, out io.Writer) *LspServer {
+	return &LspServer{
+		in:       bufio.NewReader(in),
+		out:      out,
+		files:    make(map[string]*blipFile),
+		packages: make(map[string]string),
+		docs:     make(map[string]string),
+	}
+}
+
+// lspMessage
+// A request, response or notification.
+type lspMessage struct {
+	Jsonrpc string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspResponse struct {
+	Jsonrpc string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type lspErrorResponse struct {
+	Jsonrpc string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   *lspError        `json:"error"`
+}
+
+type lspNotification struct {
+	Jsonrpc string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspPositionParams struct {
+	TextDocument lspTextDocument `json:"textDocument"`
+	Position     lspPosition     `json:"position"`
+}
+
+type lspCompletionItem struct {
+	Label    string       `json:"label"`
+	Kind     int          `json:"kind"`
+	Detail   string       `json:"detail,omitempty"`
+	TextEdit *lspTextEdit `json:"textEdit,omitempty"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspMarkup struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspHover struct {
+	Contents lspMarkup `json:"contents"`
+	Range    lspRange  `json:"range"`
+}
+
+// Serve
+// Handles the messages until exit or the end of the input.
+func (s *LspServer) Serve() error {
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		s.handle(msg)
+	}
+}
+
+// read
+// The next message, after its Content-Length header.
+func (s *LspServer) read() (*lspMessage, error) {
+	headers, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length %q", headers.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	msg := &lspMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *LspServer) write(msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "blip lsp: %s\n", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *LspServer) reply(id *json.RawMessage, result interface{}) {
+	s.write(lspResponse{Jsonrpc: "2.0", ID: id, Result: result})
+}
+
+func (s *LspServer) replyError(id *json.RawMessage, code int, msg string) {
+	s.write(lspErrorResponse{Jsonrpc: "2.0", ID: id, Error: &lspError{Code: code, Message: msg}})
+}
+
+func (s *LspServer) notify(method string, params interface{}) {
+	s.write(lspNotification{Jsonrpc: "2.0", Method: method, Params: params})
+}
+
+func (s *LspServer) handle(msg *lspMessage) {
+	switch msg.Method {
+	case "initialize":
+		var params struct {
+			RootURI      string `json:"rootUri"`
+			Capabilities struct {
+				Workspace struct {
+					DidChangeWatchedFiles struct {
+						DynamicRegistration bool `json:"dynamicRegistration"`
+					} `json:"didChangeWatchedFiles"`
+				} `json:"workspace"`
+			} `json:"capabilities"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		s.watch = params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration
+		if params.RootURI != "" {
+			s.root = filepath.Clean(uriPath(params.RootURI))
+			s.loadWorkspace()
+		}
+		s.reply(msg.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   lspSyncFull,
+				"definitionProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"@", " "}},
+			},
+			"serverInfo": map[string]string{"name": "blip", "version": Version},
+		})
+	case "initialized":
+		if s.watch && s.root != "" {
+			// The client reports the templates changed on disk, ex: by git or another editor
+			s.write(map[string]interface{}{
+				"jsonrpc": "2.0", "id": "blip-watch", "method": "client/registerCapability",
+				"params": map[string]interface{}{"registrations": []map[string]interface{}{{
+					"id": "blip-watch", "method": "workspace/didChangeWatchedFiles",
+					"registerOptions": map[string]interface{}{"watchers": []map[string]string{{"globPattern": "**/*.blip*"}}},
+				}}},
+			})
+		}
+	case "shutdown":
+		s.shutdown = true
+		s.reply(msg.ID, nil)
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didClose":
+		s.handleDocument(msg)
+	case "workspace/didChangeWatchedFiles":
+		s.handleWatchedFiles(msg)
+	case "textDocument/definition", "textDocument/hover", "textDocument/completion":
+		var params lspPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			s.replyError(msg.ID, lspErrInvalidParams, err.Error())
+			return
+		}
+		switch msg.Method {
+		case "textDocument/definition":
+			s.reply(msg.ID, s.definition(params))
+		case "textDocument/hover":
+			s.reply(msg.ID, s.hover(params))
+		default:
+			s.reply(msg.ID, s.completion(params))
+		}
+	default:
+		// Notifications that are not handled are ignored, requests get an error
+		if msg.ID != nil && msg.Method != "" {
+			s.replyError(msg.ID, lspErrMethodNotFound, "method not found: "+msg.Method)
+		}
+	}
+}
+
+// handleDocument
+// Tracks the open documents, the diagnostics of all of them are published after a change
+// as templates calling the changed one may be affected.
+func (s *LspServer) handleDocument(msg *lspMessage) {
+	var params struct {
+		TextDocument   lspTextDocument `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return
+	}
+	path := uriPath(params.TextDocument.URI)
+	switch msg.Method {
+	case "textDocument/didOpen":
+		s.docs[path] = params.TextDocument.Text
+	case "textDocument/didChange":
+		// Full sync, the last change is the document
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[path] = params.ContentChanges[n-1].Text
+		}
+	case "textDocument/didClose":
+		delete(s.docs, path)
+		// Back to the file on disk, it may not have been saved
+		s.readFile(path)
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri":         params.TextDocument.URI,
+			"diagnostics": []lspDiagnostic{},
+		})
+	}
+	s.symbols = nil
+	s.publishDiagnostics()
+}
+
+// handleWatchedFiles
+// The templates created, changed or deleted on disk.
+func (s *LspServer) handleWatchedFiles(msg *lspMessage) {
+	var params struct {
+		Changes []struct {
+			URI  string `json:"uri"`
+			Type int    `json:"type"`
+		} `json:"changes"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return
+	}
+	// The templates created in the roots are generated where the build does
+	s.loadLayout()
+	for _, change := range params.Changes {
+		path := uriPath(change.URI)
+		if change.Type == lspFileDeleted {
+			delete(s.files, path)
+		} else {
+			s.readFile(path)
+		}
+	}
+	s.disambiguate()
+	s.symbols = nil
+	s.publishDiagnostics()
+}
+
+// loadWorkspace
+// Reads the templates below the root, the hidden, vendor and node_modules directories are skipped.
+func (s *LspServer) loadWorkspace() {
+	s.loadLayout()
+	filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != s.root && lspSkipDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if IsTemplateFile(info.Name()) {
+			s.readFile(path)
+		}
+		return nil
+	})
+	s.disambiguate()
+	s.symbols = nil
+}
+
+// lspSkipDir
+// The directories that do not hold the templates of the workspace.
+func lspSkipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules"
+}
+
+// loadLayout
+// Where the build generates the templates of its roots, with the roots, output and packages of the blip.yaml of the module.
+// The templates outside of the roots are generated next to them as with -colocate.
+func (s *LspServer) loadLayout() {
+	s.layout = make(map[string]*blipFile)
+	modDir, err := findGoModFrom(s.root)
+	if err != nil {
+		return
+	}
+	opt := &BlipOptions{Sdir: filepath.Join(modDir, "template")}
+	if err := loadConfig(opt, modDir, "", nil); err != nil {
+		return
+	}
+	for _, bf := range collectRoots(modDir, opt, &diagnostics{}) {
+		s.layout[filepath.Clean(bf.sourceFName)] = bf
+	}
+}
+
+// newFile
+// The template at the path, generated as the build does.
+func (s *LspServer) newFile(path string) *blipFile {
+	if built := s.layout[path]; built != nil {
+		bf := newBlipFile(built.destDir, built.sdir, built.name)
+		bf.pkg = built.pkg
+		return bf
+	}
+	dir := filepath.Dir(path)
+	bf := newBlipFile("", dir, filepath.Base(path))
+	if bf == nil {
+		return nil
+	}
+	pkg, ok := s.packages[dir]
+	if !ok {
+		pkg = goPackageOf(dir)
+		s.packages[dir] = pkg
+	}
+	bf.pkg = pkg
+	return bf
+}
+
+// readFile
+// Reads the template of the workspace at the path.
+// The template is removed when it cannot be read.
+func (s *LspServer) readFile(path string) {
+	dir := filepath.Dir(path)
+	rel, err := filepath.Rel(s.root, dir)
+	if s.root == "" || err != nil {
+		return
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+		// Outside of the root too
+		if elem != "." && lspSkipDir(elem) {
+			return
+		}
+	}
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		delete(s.files, path)
+		return
+	}
+	if bf := s.files[path]; bf != nil {
+		bf.source = source
+		return
+	}
+	bf := s.newFile(path)
+	if bf == nil {
+		return
+	}
+	bf.source = source
+	s.files[path] = bf
+}
+
+// disambiguate
+// Names the templates of the workspace with their file type when another one of the package has the same name.
+func (s *LspServer) disambiguate() {
+	files := make([]*blipFile, 0, len(s.files))
+	for path, bf := range s.files {
+		fresh := newBlipFile(bf.destDir, bf.sdir, bf.name)
+		fresh.pkg, fresh.source = bf.pkg, bf.source
+		s.files[path] = fresh
+		files = append(files, fresh)
+	}
+	disambiguateFileTypes(files)
+}
+
+// load
+// The templates of the workspace and the open documents parsed, with the calls between them validated.
+// They are parsed again after a change only.
+func (s *LspServer) load() *symbolTable {
+	if s.symbols != nil {
+		return s.symbols
+	}
+	paths := make([]string, 0, len(s.files)+len(s.docs))
+	for path := range s.files {
+		paths = append(paths, path)
+	}
+	for path := range s.docs {
+		if s.files[path] == nil {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var files []*blipFile
+	for _, path := range paths {
+		text, open := s.docs[path]
+		bf := s.files[path]
+		if bf == nil {
+			// Open outside of the workspace, or not saved yet
+			if bf = s.newFile(path); bf == nil {
+				continue
+			}
+		} else {
+			copied := *bf
+			bf = &copied
+		}
+		if open {
+			bf.source = []byte(text)
+		}
+		files = append(files, bf)
+	}
+	s.symbols = loadSymbols(files, &diagnostics{})
+	return s.symbols
+}
+
+// template
+// The open document at the path.
+func (s *LspServer) template(symbols *symbolTable, path string) *templateSymbols {
+	for bf, ts := range symbols.byFile {
+		if bf.sourceFName == path {
+			return ts
+		}
+	}
+	return nil
+}
+
+func (s *LspServer) publishDiagnostics() {
+	symbols := s.load()
+	paths := make([]string, 0, len(s.docs))
+	for path := range s.docs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		list := []lspDiagnostic{}
+		if ts := s.template(symbols, path); ts != nil {
+			lines := strings.Split(s.docs[path], "\n")
+			for _, perr := range ts.parser.errors {
+				line := perr.lineNum - 1
+				list = append(list, lspDiagnostic{
+					Range:    lspLineRange(lines, line, perr.linePos),
+					Severity: lspSeverityError,
+					Source:   "blip",
+					Message:  strings.TrimSpace(perr.msg),
+				})
+			}
+		}
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri":         pathURI(path),
+			"diagnostics": list,
+		})
+	}
+}
+
+// callAt
+// The @include / @extend command on the line of the position and the template it calls.
+func (s *LspServer) callAt(params lspPositionParams) (node blipast.Node, name string, target *templateSymbols) {
+	path := uriPath(params.TextDocument.URI)
+	symbols := s.load()
+	ts := s.template(symbols, path)
+	if ts == nil {
+		return nil, "", nil
+	}
+	line := params.Position.Line + 1
+	blipast.Inspect(ts.parser.Template(), func(n blipast.Node) bool {
+		switch call := n.(type) {
+		case *blipast.Include:
+			if call.Pos().Line == line {
+				node, name = call, call.Template
+			}
+		case *blipast.Extend:
+			if call.Pos().Line == line {
+				node, name = call, call.Template
+			}
+		}
+		return node == nil
+	})
+	if node == nil {
+		return nil, "", nil
+	}
+	target, _ = symbols.resolve(ts.file, name)
+	return node, name, target
+}
+
+// definition
+// From an @include / @extend to the template it calls.
+func (s *LspServer) definition(params lspPositionParams) interface{} {
+	_, _, target := s.callAt(params)
+	if target == nil {
+		return nil
+	}
+	return []lspLocation{{URI: pathURI(target.file.sourceFName)}}
+}
+
+// hover
+// The @arg signature and @yield names of the template called on the line.
+func (s *LspServer) hover(params lspPositionParams) interface{} {
+	node, name, target := s.callAt(params)
+	if target == nil {
+		return nil
+	}
+	value := "```go\n" + name + target.argList() + "\n```"
+	if len(target.yields) > 0 {
+		value += "\n\n@yield " + strings.Join(target.yields, ", ")
+	}
+	lines := strings.Split(s.docs[uriPath(params.TextDocument.URI)], "\n")
+	return lspHover{
+		Contents: lspMarkup{Kind: "markdown", Value: value},
+		Range:    lspLineRange(lines, node.Pos().Line-1, node.Pos().Col),
+	}
+}
+
+var (
+	completeContent = regexp.MustCompile(`@content\s+(\w*)$`)
+	completeCommand = regexp.MustCompile(`@(\w*)$`)
+)
+
+// completion
+// The commands after an @, the @yield names of the extended template after @content.
+func (s *LspServer) completion(params lspPositionParams) interface{} {
+	items := []lspCompletionItem{}
+	path := uriPath(params.TextDocument.URI)
+	lines := strings.Split(s.docs[path], "\n")
+	if params.Position.Line >= len(lines) {
+		return items
+	}
+	line := []rune(lines[params.Position.Line])
+	col := lspColumn(lines, params.Position.Line, params.Position.Character)
+	prefix := string(line[0 : col-1])
+
+	if match := completeContent.FindStringSubmatch(prefix); match != nil {
+		for _, yield := range s.extendedYields(path, params.Position.Line+1) {
+			if strings.HasPrefix(yield, match[1]) {
+				items = append(items, lspCompletionItem{Label: yield, Kind: lspKindProperty, Detail: "@yield"})
+			}
+		}
+		return items
+	}
+	if match := completeCommand.FindStringSubmatch(prefix); match != nil {
+		// Replace from the @ so clients that do not count it in the word do not repeat it
+		start := params.Position.Character - len(utf16.Encode([]rune(match[0])))
+		for _, cmd := range commands {
+			if !strings.HasPrefix(cmd, "@"+match[1]) {
+				continue
+			}
+			items = append(items, lspCompletionItem{
+				Label: cmd,
+				Kind:  lspKindKeyword,
+				TextEdit: &lspTextEdit{
+					Range:   lspRange{Start: lspPosition{Line: params.Position.Line, Character: start}, End: params.Position},
+					NewText: cmd,
+				},
+			})
+		}
+	}
+	return items
+}
+
+// extendedYields
+// The @yield names of the template of the innermost @extend around the line.
+func (s *LspServer) extendedYields(path string, line int) []string {
+	symbols := s.load()
+	ts := s.template(symbols, path)
+	if ts == nil {
+		return nil
+	}
+	var extend *blipast.Extend
+	blipast.Inspect(ts.parser.Template(), func(n blipast.Node) bool {
+		if e, ok := n.(*blipast.Extend); ok && e.Pos().Line < line && (!e.End().IsValid() || e.End().Line >= line) {
+			extend = e
+		}
+		return true
+	})
+	if extend == nil {
+		return nil
+	}
+	target, _ := symbols.resolve(ts.file, extend.Template)
+	if target == nil {
+		return nil
+	}
+	return target.yields
+}
+
+// lspLineRange
+// From the 1 based character column to the end of the line.
+func lspLineRange(lines []string, line int, col int) lspRange {
+	end := 0
+	if line >= 0 && line < len(lines) {
+		end = lspCharacter(lines, line, len([]rune(lines[line]))+1)
+	}
+	return lspRange{
+		Start: lspPosition{Line: line, Character: lspCharacter(lines, line, col)},
+		End:   lspPosition{Line: line, Character: end},
+	}
+}
+
+// lspCharacter
+// The UTF-16 offset in the line of the 1 based character column.
+func lspCharacter(lines []string, line int, col int) int {
+	if line < 0 || line >= len(lines) {
+		return 0
+	}
+	runes := []rune(lines[line])
+	if col-1 < len(runes) {
+		runes = runes[0 : col-1]
+	}
+	return len(utf16.Encode(runes))
+}
+
+// lspColumn
+// The 1 based character column of the UTF-16 offset in the line.
+func lspColumn(lines []string, line int, character int) int {
+	col := 1
+	units := 0
+	for _, ch := range lines[line] {
+		if units >= character {
+			break
+		}
+		units += len(utf16.Encode([]rune{ch}))
+		col++
+	}
+	return col
+}
+
+// uriPath
+// The file path of a file:// URI.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// pathURI
+// The file:// URI of a path.
+func pathURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
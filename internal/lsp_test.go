@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// lspClient
+// A scripted client talking to the server through pipes, as an editor does on stdio.
+type lspClient struct {
+	t             *testing.T
+	in            io.Writer
+	out           *bufio.Reader
+	nextID        int
+	notifications []lspMessage
+	done          chan error
+}
+
+func startLsp(t *testing.T) *lspClient {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	server := NewLspServer(serverIn, serverOut)
+	c := &lspClient{t: t, in: clientOut, out: bufio.NewReader(clientIn), done: make(chan error, 1)}
+	go func() {
+		err := server.Serve()
+		serverOut.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+func (c *lspClient) send(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	assert.Nil(c.t, err)
+	fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (c *lspClient) read() lspMessage {
+	headers, err := textproto.NewReader(c.out).ReadMIMEHeader()
+	assert.Nil(c.t, err)
+	length, _ := strconv.Atoi(headers.Get("Content-Length"))
+	body := make([]byte, length)
+	_, err = io.ReadFull(c.out, body)
+	assert.Nil(c.t, err)
+	var msg lspMessage
+	assert.Nil(c.t, json.Unmarshal(body, &msg))
+	return msg
+}
+
+// call
+// Sends the request and returns the result, the notifications and requests of the server before it are kept.
+func (c *lspClient) call(method string, params interface{}, result interface{}) {
+	c.nextID++
+	c.send(map[string]interface{}{"id": c.nextID, "method": method, "params": params})
+	for {
+		msg := c.read()
+		if msg.ID == nil || msg.Method != "" {
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+		assert.Equal(c.t, strconv.Itoa(c.nextID), string(*msg.ID))
+		assert.Nil(c.t, msg.Error)
+		if result != nil {
+			assert.Nil(c.t, json.Unmarshal(msg.Result, result))
+		}
+		return
+	}
+}
+
+func (c *lspClient) notify(method string, params interface{}) {
+	c.send(map[string]interface{}{"method": method, "params": params})
+}
+
+// diagnostics
+// Waits for the diagnostics of the document.
+func (c *lspClient) diagnostics(uri string) []lspDiagnostic {
+	for {
+		msg := c.read()
+		if msg.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var params struct {
+			URI         string          `json:"uri"`
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		}
+		assert.Nil(c.t, json.Unmarshal(msg.Params, &params))
+		if params.URI == uri {
+			return params.Diagnostics
+		}
+	}
+}
+
+func textPosition(uri string, line int, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": character},
+	}
+}
+
+func TestLsp(t *testing.T) {
+	root := t.TempDir()
+	writeTemplate(t, filepath.Join(root, "layout"), "base.blip.html", `@arg title string
+<title>@= title @</title>
+@yield body
+@yield footer
+`)
+	page := filepath.Join(root, "pages", "index.blip.html")
+	writeTemplate(t, filepath.Join(root, "pages"), "index.blip.html", "")
+	uri := pathURI(page)
+
+	c := startLsp(t)
+	var init struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	c.call("initialize", map[string]interface{}{
+		"rootUri":      pathURI(root),
+		"capabilities": map[string]interface{}{"workspace": map[string]interface{}{"didChangeWatchedFiles": map[string]bool{"dynamicRegistration": true}}},
+	}, &init)
+	assert.Equal(t, true, init.Capabilities["definitionProvider"])
+	c.notify("initialized", map[string]interface{}{})
+	assert.Equal(t, "client/registerCapability", c.read().Method)
+
+	// Diagnostics as the document is edited
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "blip", "version": 1, "text": "@if true\n<b>x</b>\n"},
+	})
+	diags := c.diagnostics(uri)
+	assert.Equal(t, 1, len(diags))
+	assert.Equal(t, "@if : block is not closed, expected @end", diags[0].Message)
+	assert.Equal(t, lspRange{Start: lspPosition{0, 0}, End: lspPosition{0, 8}}, diags[0].Range)
+
+	text := `@import "example.com/x/layout"
+@extend layout.base "Home"
+	@content 
+	@end
+@end
+`
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]string{{"text": text}},
+	})
+	// The @content being typed is not complete
+	diags = c.diagnostics(uri)
+	assert.Equal(t, "@content : @content has invalid characters, @ not expected", diags[0].Message)
+	assert.Equal(t, 2, diags[0].Range.Start.Line)
+
+	// Go to the extended template
+	var locations []lspLocation
+	c.call("textDocument/definition", textPosition(uri, 1, 10), &locations)
+	assert.Equal(t, []lspLocation{{URI: pathURI(filepath.Join(root, "layout", "base.blip.html"))}}, locations)
+
+	// Its @arg signature
+	var hover lspHover
+	c.call("textDocument/hover", textPosition(uri, 1, 3), &hover)
+	assert.Equal(t, "```go\nlayout.base(title string)\n```\n\n@yield body, footer", hover.Contents.Value)
+
+	// The @yield names for the @content
+	var items []lspCompletionItem
+	c.call("textDocument/completion", textPosition(uri, 2, 10), &items)
+	assert.Equal(t, []string{"body", "footer"}, completionLabels(items))
+
+	// The files on disk are read again when the client reports them
+	base := filepath.Join(root, "layout", "base.blip.html")
+	writeTemplate(t, filepath.Join(root, "layout"), "base.blip.html", "@arg title string\n@arg year int\n@yield body\n")
+	c.call("textDocument/hover", textPosition(uri, 1, 3), &hover)
+	assert.Equal(t, "```go\nlayout.base(title string)\n```\n\n@yield body, footer", hover.Contents.Value)
+	c.notify("workspace/didChangeWatchedFiles", map[string]interface{}{
+		"changes": []map[string]interface{}{{"uri": pathURI(base), "type": 2}},
+	})
+	c.diagnostics(uri)
+	c.call("textDocument/hover", textPosition(uri, 1, 3), &hover)
+	assert.Equal(t, "```go\nlayout.base(title string, year int)\n```\n\n@yield body", hover.Contents.Value)
+
+	// The commands
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 3},
+		"contentChanges": []map[string]string{{"text": "<p>@in"}},
+	})
+	diags = c.diagnostics(uri)
+	assert.Equal(t, "Invalid command found: @in, did you mean @if?", diags[0].Message)
+	c.call("textDocument/completion", textPosition(uri, 0, 6), &items)
+	assert.Equal(t, []string{"@int=", "@int64=", "@include"}, completionLabels(items))
+	assert.Equal(t, lspRange{Start: lspPosition{0, 3}, End: lspPosition{0, 6}}, items[0].TextEdit.Range)
+
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	assert.Nil(t, <-c.done)
+}
+
+func completionLabels(items []lspCompletionItem) []string {
+	labels := []string{}
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	return labels
+}
+
+func TestLspWorkspace(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"pages", "vendor/x", "node_modules/x", ".git/x"} {
+		writeTemplate(t, filepath.Join(root, dir), "index.blip.html", "<p>\n")
+	}
+	s := NewLspServer(nil, io.Discard)
+	s.root = root
+	s.loadWorkspace()
+	var paths []string
+	for path := range s.files {
+		paths = append(paths, path)
+	}
+	assert.Equal(t, []string{filepath.Join(root, "pages", "index.blip.html")}, paths)
+
+	// Nor from the watched files
+	s.readFile(filepath.Join(root, "vendor", "x", "index.blip.html"))
+	assert.Equal(t, 1, len(s.files))
+}
+
+func TestLspLayout(t *testing.T) {
+	root := t.TempDir()
+	writeTemplate(t, root, "go.mod", "module example.com/m\n\ngo 1.17\n")
+	writeTemplate(t, root, ConfigName, `roots:
+  - web/template
+  - dir: shop/views
+    colocate: true
+output: gen
+overrides:
+  - dir: web/template/mail
+    package: letters
+`)
+	writeTemplate(t, filepath.Join(root, "web", "template", "pages"), "index.blip.html", "@include letters.welcome\n@include shop.cart\n")
+	writeTemplate(t, filepath.Join(root, "web", "template", "mail"), "welcome.blip.txt", "@arg name string\n")
+	writeTemplate(t, filepath.Join(root, "shop", "views"), "shop.go", "package shop\n")
+	writeTemplate(t, filepath.Join(root, "shop", "views"), "cart.blip.html", "<p>\n")
+	writeTemplate(t, filepath.Join(root, "other"), "note.blip.txt", "hi\n")
+
+	s := NewLspServer(nil, io.Discard)
+	s.root = root
+	s.loadWorkspace()
+	file := func(elem ...string) *blipFile {
+		bf := s.files[filepath.Join(append([]string{root}, elem...)...)]
+		assert.NotNil(t, bf)
+		return bf
+	}
+	// The templates are generated where the build does
+	assert.Equal(t, filepath.Join(root, "gen", "pages"), file("web", "template", "pages", "index.blip.html").destDir)
+	assert.Equal(t, "letters", file("web", "template", "mail", "welcome.blip.txt").packageName())
+	assert.Equal(t, "shop", file("shop", "views", "cart.blip.html").packageName())
+	assert.Equal(t, filepath.Join(root, "other"), file("other", "note.blip.txt").destDir)
+
+	// The calls between the packages are resolved
+	symbols := s.load()
+	index := s.template(symbols, filepath.Join(root, "web", "template", "pages", "index.blip.html"))
+	assert.Equal(t, 1, len(index.parser.errors))
+	assert.Contains(t, index.parser.errors[0].msg, "letters.welcome expects 1 argument(s) (name string), found 0")
+}
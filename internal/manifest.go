@@ -0,0 +1,334 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ManifestName
+// The build manifest, in the output directory, ex: blipped.
+const ManifestName = "blip-manifest.json"
+
+// buildManifest
+// What the generated files were built from, to rebuild only the templates affected by a change.
+// A template is rebuilt when its source, a template it calls, the blip version or the options change.
+// The outputs in the manifest are the files owned by blip, they are removed when the template is.
+type buildManifest struct {
+	Version    string                    `json:"version"`
+	Options    string                    `json:"options"`
+	Templates  map[string]*manifestEntry `json:"templates"`            // By the template path relative to the module
+	Registries []string                  `json:"registries,omitempty"` // The registry files relative to the module, see -registry
+
+	mu       sync.Mutex
+	modDir   string
+	outDir   string
+	outdated bool     // Built by another version or options, all templates are stale
+	replaced []string // Outputs of templates now generated to another file
+}
+
+// manifestEntry
+// A generated template.
+type manifestEntry struct {
+	Hash   string            `json:"hash"`           // sha256 of the template source
+	Output string            `json:"output"`         // The generated file relative to the module
+	Deps   map[string]string `json:"deps,omitempty"` // The templates called by @include / @extend and their hash
+}
+
+// loadManifest
+// Reads the manifest of the module, it is empty when missing. All templates are stale when built by another version or options.
+// opt is nil when not building, the saved version and options are kept, ex: blip clean.
+func loadManifest(modDir string, outDir string, opt *BlipOptions) *buildManifest {
+	m := &buildManifest{
+		Version:   Version,
+		Templates: make(map[string]*manifestEntry),
+		modDir:    modDir,
+		outDir:    outDir,
+	}
+	if opt != nil {
+		m.Options = optionsFingerprint(opt)
+	}
+	data, err := ioutil.ReadFile(m.path())
+	if err != nil {
+		return m
+	}
+	saved := &buildManifest{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		return m
+	}
+	if saved.Templates != nil {
+		m.Templates = saved.Templates
+	}
+	m.Registries = saved.Registries
+	if opt == nil {
+		m.Version, m.Options = saved.Version, saved.Options
+	}
+	m.outdated = saved.Version != m.Version || saved.Options != m.Options
+	return m
+}
+
+// optionsFingerprint
+// The options that change the generated code.
+func optionsFingerprint(opt *BlipOptions) string {
+	fingerprint := fmt.Sprintf("supportBranch=%s renderLineNumbers=%v lineDirectives=%v",
+		opt.SupportBranch, opt.RenderLineNumbers, opt.LineDirectives)
+	if opt.Interpreter {
+		fingerprint += " interpreter=true"
+	}
+	if len(opt.Roots) > 0 || len(opt.Templates.Imports) > 0 || len(opt.Templates.Escapers) > 0 || opt.Templates.Trim != nil || opt.Templates.Minify != nil || len(opt.Overrides) > 0 {
+		// The settings of blip.yaml, the roots name the templates in the generated code
+		config, _ := json.Marshal(struct {
+			Roots     []SourceRoot
+			Templates TemplateConfig
+			Overrides []ConfigOverride
+		}{opt.Roots, opt.Templates, opt.Overrides})
+		fingerprint += " config=" + sourceHash(string(config))
+	}
+	return fingerprint
+}
+
+func (m *buildManifest) path() string {
+	return filepath.Join(m.outDir, ManifestName)
+}
+
+// key
+// The file relative to the module directory.
+func (m *buildManifest) key(fname string) string {
+	abs, err := filepath.Abs(fname)
+	if err != nil {
+		return filepath.ToSlash(fname)
+	}
+	rel, err := filepath.Rel(m.modDir, abs)
+	if err != nil {
+		return filepath.ToSlash(abs)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// stale
+// True when the template has to be generated.
+func (m *buildManifest) stale(ts *templateSymbols, symbols *symbolTable) bool {
+	m.mu.Lock()
+	entry := m.Templates[m.key(ts.file.sourceFName)]
+	m.mu.Unlock()
+	if m.outdated || entry == nil || entry.Hash != ts.hash || entry.Output != m.key(ts.file.destFName) {
+		return true
+	}
+	if _, err := os.Stat(ts.file.destFName); err != nil {
+		return true
+	}
+	deps := symbols.dependencies(ts)
+	if len(deps) != len(entry.Deps) {
+		return true
+	}
+	for _, dep := range deps {
+		if hash, ok := entry.Deps[m.key(dep.file.sourceFName)]; !ok || hash != dep.hash {
+			return true
+		}
+	}
+	return false
+}
+
+// built
+// Records the generated template.
+func (m *buildManifest) built(ts *templateSymbols, symbols *symbolTable) {
+	entry := &manifestEntry{Hash: ts.hash, Output: m.key(ts.file.destFName)}
+	for _, dep := range symbols.dependencies(ts) {
+		if entry.Deps == nil {
+			entry.Deps = make(map[string]string)
+		}
+		entry.Deps[m.key(dep.file.sourceFName)] = dep.hash
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := m.key(ts.file.sourceFName)
+	if prior := m.Templates[key]; prior != nil && prior.Output != entry.Output {
+		m.replaced = append(m.replaced, prior.Output)
+	}
+	m.Templates[key] = entry
+}
+
+// forget
+// The template is generated again by the next build, ex: it has errors.
+func (m *buildManifest) forget(bf *blipFile) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.Templates, m.key(bf.sourceFName))
+}
+
+// prune
+// Removes the outputs of the templates that no longer exist, and those replaced by another output.
+// With all, removes every output. Only files generated by blip are removed.
+// Returns the removed files relative to the module.
+func (m *buildManifest) prune(all bool) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.Templates))
+	for key := range m.Templates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var orphans []string
+	for _, key := range keys {
+		if !all {
+			if _, err := os.Stat(filepath.Join(m.modDir, filepath.FromSlash(key))); !os.IsNotExist(err) {
+				continue
+			}
+		}
+		orphans = append(orphans, m.Templates[key].Output)
+		delete(m.Templates, key)
+	}
+	orphans = append(orphans, m.replaced...)
+	m.replaced = nil
+	if all {
+		orphans = append(orphans, m.Registries...)
+		m.Registries = nil
+	}
+
+	// An output can be generated again by another template, ex: renamed to another file type
+	inUse := make(map[string]bool)
+	for _, entry := range m.Templates {
+		inUse[entry.Output] = true
+	}
+	var removed []string
+	for _, output := range orphans {
+		if inUse[output] {
+			continue
+		}
+		ok, err := removeGenerated(filepath.Join(m.modDir, filepath.FromSlash(output)))
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed = append(removed, output)
+		}
+	}
+	return removed, nil
+}
+
+// registered
+// Records the registry files of the build, those of the previous build no longer written are removed.
+// Returns the removed files relative to the module.
+func (m *buildManifest) registered(outputs []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	written := make(map[string]bool)
+	for _, output := range outputs {
+		written[output] = true
+	}
+	var removed []string
+	for _, output := range m.Registries {
+		if written[output] {
+			continue
+		}
+		ok, err := removeGenerated(filepath.Join(m.modDir, filepath.FromSlash(output)))
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed = append(removed, output)
+		}
+	}
+	m.Registries = outputs
+	return removed, nil
+}
+
+// generatedMarker
+// In the header of the generated files.
+const generatedMarker = "// Generated by Blip\n"
+
+// removeGenerated
+// Removes the file if it was generated by blip, and its directory when it is left empty.
+func removeGenerated(fname string) (bool, error) {
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(f, header)
+	f.Close()
+	if !strings.Contains(string(header[0:n]), generatedMarker) {
+		// Replaced by a hand written file
+		return false, nil
+	}
+	if err := os.Remove(fname); err != nil {
+		return false, err
+	}
+	// Fails when not empty
+	os.Remove(filepath.Dir(fname))
+	return true, nil
+}
+
+// remove
+// Removes the manifest, ex: blip clean -all
+func (m *buildManifest) remove() error {
+	err := os.Remove(m.path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// save
+// Writes the manifest, the output directory is created when there is none.
+func (m *buildManifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path()), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.path(), append(data, '\n'), 0666)
+}
+
+// sourceHash
+// The sha256 of a template source.
+func sourceHash(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// dependencies
+// The templates called by the template, sorted by file.
+func (st *symbolTable) dependencies(ts *templateSymbols) []*templateSymbols {
+	found := make(map[*templateSymbols]bool)
+	var walk func(node ast)
+	walk = func(node ast) {
+		for _, child := range node.GetChildren() {
+			base := child.(*astBase)
+			if base.nodeType == NODE_INCLUDE_SIMPLE || base.nodeType == NODE_INCLUDE {
+				name, _ := splitCall(base.token.Literal)
+				if target, _ := st.resolve(ts.file, name); target != nil && target != ts {
+					found[target] = true
+				}
+			}
+			walk(child)
+		}
+	}
+	walk(ts.parser.root)
+
+	deps := make([]*templateSymbols, 0, len(found))
+	for dep := range found {
+		deps = append(deps, dep)
+	}
+	sort.Slice(deps, func(i, j int) bool {
+		return deps[i].file.sourceFName < deps[j].file.sourceFName
+	})
+	return deps
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildModule
+// Builds the templates of the module as processDir does, returns the templates that were generated.
+func buildModule(t *testing.T, modDir string, opt *BlipOptions) []string {
+	diags := &diagnostics{}
+	var files []*blipFile
+	collectBlipFiles(filepath.Join(modDir, "blipped"), filepath.Join(modDir, "template"), &files, diags)
+	symbols := loadSymbols(files, diags)
+	manifest := loadManifest(modDir, filepath.Join(modDir, "blipped"), opt)
+
+	var built []string
+	for _, bf := range files {
+		if opt.Rebuild || manifest.stale(symbols.get(bf), symbols) {
+			built = append(built, bf.name)
+		}
+		processFile(bf, symbols, manifest, opt, diags)
+	}
+	assert.Nil(t, manifest.save())
+	assert.Equal(t, 0, len(diags.list), diags.sorted())
+	return built
+}
+
+func TestManifest(t *testing.T) {
+	modDir := t.TempDir()
+	sdir := filepath.Join(modDir, "template", "pages")
+	writeTemplate(t, sdir, "layout.blip.html", "@arg title string\n<title>@= title @</title>\n@yield body\n")
+	writeTemplate(t, sdir, "index.blip.html", "@extend layout \"Home\"\n@content body\nhi\n@end\n@end\n")
+	writeTemplate(t, sdir, "about.blip.html", "<p>about</p>\n")
+	opt := &BlipOptions{SupportBranch: "github.com/samlotti/blip/blipUtil"}
+
+	assert.Equal(t, []string{"about.blip.html", "index.blip.html", "layout.blip.html"}, buildModule(t, modDir, opt))
+	assert.Equal(t, 0, len(buildModule(t, modDir, opt)))
+
+	manifest := loadManifest(modDir, filepath.Join(modDir, "blipped"), opt)
+	entry := manifest.Templates["template/pages/index.blip.html"]
+	assert.Equal(t, "blipped/pages/index.blip.go", entry.Output)
+	assert.Equal(t, []string{"template/pages/layout.blip.html"}, keys(entry.Deps))
+
+	// The templates calling a changed template are rebuilt
+	writeTemplate(t, sdir, "layout.blip.html", "@arg title string\n<h1>@= title @</h1>\n@yield body\n")
+	assert.Equal(t, []string{"index.blip.html", "layout.blip.html"}, buildModule(t, modDir, opt))
+
+	// Saving without a change does not rebuild
+	writeTemplate(t, sdir, "about.blip.html", "<p>about</p>\n")
+	assert.Equal(t, 0, len(buildModule(t, modDir, opt)))
+
+	// A removed output
+	assert.Nil(t, os.Remove(filepath.Join(modDir, "blipped", "pages", "about.blip.go")))
+	assert.Equal(t, []string{"about.blip.html"}, buildModule(t, modDir, opt))
+
+	// Changed options rebuild all
+	opt.RenderLineNumbers = true
+	assert.Equal(t, 3, len(buildModule(t, modDir, opt)))
+	assert.Equal(t, 0, len(buildModule(t, modDir, opt)))
+}
+
+func keys(m map[string]string) []string {
+	list := []string{}
+	for key := range m {
+		list = append(list, key)
+	}
+	return list
+}
+
+func TestManifestPrune(t *testing.T) {
+	modDir := t.TempDir()
+	sdir := filepath.Join(modDir, "template", "pages")
+	writeTemplate(t, sdir, "index.blip.html", "<p>index</p>\n")
+	writeTemplate(t, sdir, "about.blip.html", "<p>about</p>\n")
+	writeTemplate(t, filepath.Join(modDir, "template", "mail"), "welcome.blip.txt", "hi\n")
+	opt := &BlipOptions{SupportBranch: "github.com/samlotti/blip/blipUtil"}
+	buildModule(t, modDir, opt)
+
+	// A renamed and a removed template
+	assert.Nil(t, os.Rename(filepath.Join(sdir, "about.blip.html"), filepath.Join(sdir, "info.blip.html")))
+	assert.Nil(t, os.RemoveAll(filepath.Join(modDir, "template", "mail")))
+	// Hand written, not removed
+	writeTemplate(t, filepath.Join(modDir, "blipped", "pages"), "helpers.go", "package pages\n")
+
+	assert.Equal(t, []string{"info.blip.html"}, buildModule(t, modDir, opt))
+	manifest := loadManifest(modDir, filepath.Join(modDir, "blipped"), opt)
+	removed, err := manifest.prune(false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"blipped/mail/welcome.blip.go", "blipped/pages/about.blip.go"}, removed)
+	assert.Nil(t, manifest.save())
+
+	_, err = os.Stat(filepath.Join(modDir, "blipped", "mail"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(modDir, "blipped", "pages", "info.blip.go"))
+	assert.Nil(t, err)
+
+	// clean -all
+	manifest = loadManifest(modDir, filepath.Join(modDir, "blipped"), nil)
+	removed, err = manifest.prune(true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"blipped/pages/index.blip.go", "blipped/pages/info.blip.go"}, removed)
+	_, err = os.Stat(filepath.Join(modDir, "blipped", "pages", "helpers.go"))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+)
+
+// preservedTag
+// The html elements whose white space is kept by trim and minify.
+var preservedTag = regexp.MustCompile(`(?i)</?(pre|textarea|script)\b[^>]*>`)
+
+var whiteSpace = regexp.MustCompile(`\s+`)
+
+// textFilter
+// Applies the trim and minify settings to the text of a template, in the order it is written.
+type textFilter struct {
+	trim      bool
+	minify    bool
+	preserved bool // Within a <pre>, <textarea> or <script>
+}
+
+func newTextFilter(opt *BlipOptions, fileType string) *textFilter {
+	return &textFilter{
+		trim:   opt.Templates.Trim != nil && *opt.Templates.Trim,
+		minify: opt.Templates.Minify != nil && *opt.Templates.Minify && fileType == "html",
+	}
+}
+
+// filter
+// The text to output, atLineStart is set when the text starts a line of the template.
+func (f *textFilter) filter(text string, atLineStart bool) string {
+	if !f.trim && !f.minify {
+		return text
+	}
+	var out strings.Builder
+	pos := 0
+	for _, tag := range preservedTag.FindAllStringIndex(text, -1) {
+		out.WriteString(f.filterSegment(text[pos:tag[0]], atLineStart))
+		out.WriteString(text[tag[0]:tag[1]])
+		f.preserved = text[tag[0]+1] != '/'
+		pos = tag[1]
+		atLineStart = false
+	}
+	out.WriteString(f.filterSegment(text[pos:], atLineStart))
+	return out.String()
+}
+
+func (f *textFilter) filterSegment(text string, atLineStart bool) string {
+	if f.preserved {
+		return text
+	}
+	if f.trim {
+		text = trimLines(text, atLineStart)
+	}
+	if f.minify {
+		text = whiteSpace.ReplaceAllStringFunc(text, func(space string) string {
+			if strings.Contains(space, "\n") {
+				return "\n"
+			}
+			return " "
+		})
+	}
+	return text
+}
+
+// trimLines
+// Removes the indentation and trailing spaces of the lines, and the blank lines.
+// The first line is only a whole line when atLineStart.
+func trimLines(text string, atLineStart bool) string {
+	var out strings.Builder
+	for idx, line := range strings.SplitAfter(text, "\n") {
+		wholeLine := idx > 0 || atLineStart
+		hasNL := strings.HasSuffix(line, "\n")
+		line = strings.TrimSuffix(line, "\n")
+		if hasNL {
+			line = strings.TrimRight(line, " \t\r")
+		}
+		if wholeLine {
+			line = strings.TrimLeft(line, " \t")
+			if line == "" && hasNL {
+				continue
+			}
+		}
+		out.WriteString(line)
+		if hasNL {
+			out.WriteString("\n")
+		}
+	}
+	return out.String()
+}
@@ -2,6 +2,10 @@ package internal
 
 import (
 	"fmt"
+	goparser "go/parser"
+	"go/scanner"
+	gotoken "go/token"
+	"regexp"
 	"strings"
 	"unicode"
 )
@@ -42,6 +46,7 @@ type astBase struct {
 	children []ast
 	nodeType int
 	token    *Token
+	end      *Token // The @end of a block
 }
 
 func (b *astBase) GetRoot() ast {
@@ -78,17 +83,29 @@ type PError struct {
 	msg     string
 }
 
+func (e PError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.lineNum, e.linePos, strings.TrimSpace(e.msg))
+}
+
+type closedBlock struct {
+	opener *Token
+	end    *Token
+}
+
 type Parser struct {
 	depth int
 
-	lex       *Lexer
-	root      ast
-	current   ast
-	errors    []PError
-	imports   []*Token
-	args      []*Token
-	context   []*Token
-	functions []ast
+	lex     *Lexer
+	root    ast
+	current ast
+	errors  []PError
+	imports []*Token
+	// Blocks ended by an @end not lined up with them, used to find the block missing its @end
+	misaligned []closedBlock
+	args       []*Token
+	context    []*Token
+	functions  []ast
+	name       *Token // @name, nil to name the template by its file
 }
 
 func newAst(parent ast, nodeType int, token *Token) *astBase {
@@ -158,40 +175,63 @@ func (p *Parser) parseNode(node ast, isRoot bool, terminators []TokenType) *Toke
 			}
 			p.processFunction(node, token)
 		case EOF:
-			if !isRoot {
-				p.addError(token, "Unexpected EOF, was there a missing @end ?")
-			}
+			// Blocks report the missing @end at their opening command
 			return token
+		case ILLEGAL:
+			p.addErrorAt(token.Line, token.Pos, token.Literal)
+		case END:
+			p.addError(token, "no open @if, @for, @extend or @content block to end")
+		case ELSE:
+			p.addError(token, "no open @if for the @else")
+		case CONTENT:
+			p.addError(token, "@content is only allowed inside an @extend block")
+			p.parseDetached(token)
 		case LITERAL:
 			node.addChild(newAst(node, NODE_TOKEN, token))
 
 		case ARG:
 			if isRoot {
 				p.args = append(p.args, token)
-				p.verifySplit(token, 2)
+				if p.verifySplit(token, 2) {
+					p.validateGo(token, 0, "func _(", ")")
+				}
 			} else {
 				p.rootRequiredError(token)
 			}
 		case CONTEXT:
 			if isRoot {
 				p.context = append(p.context, token)
-				p.verifySplit(token, 2)
+				if p.verifySplit(token, 2) {
+					p.validateGo(token, 0, "var ", "")
+				}
 			} else {
 				p.rootRequiredError(token)
 			}
 		case ATDisplay:
 			node.addChild(newAst(node, NODE_DISPLAY, token))
+			p.validateGo(token, 0, "var _ = ", "")
 		case ATDisplayInt:
 			node.addChild(newAst(node, NODE_DISPLAY_INT, token))
+			p.validateGo(token, 0, "var _ = ", "")
 		case ATDisplayBool:
 			node.addChild(newAst(node, NODE_DISPLAY_BOOL, token))
+			p.validateGo(token, 0, "var _ = ", "")
 		case ATDisplayInt64:
 			node.addChild(newAst(node, NODE_DISPLAY_INT64, token))
+			p.validateGo(token, 0, "var _ = ", "")
 		case ATDisplayUnsafe:
 			node.addChild(newAst(node, NODE_DISPLAY_RAW, token))
+			p.validateGo(token, 0, "var _ = ", "")
 		case IMPORT:
 			if isRoot {
 				p.imports = append(p.imports, token)
+				p.validateGo(token, 0, "import ", "")
+			} else {
+				p.rootRequiredError(token)
+			}
+		case NAME:
+			if isRoot {
+				p.processName(token)
 			} else {
 				p.rootRequiredError(token)
 			}
@@ -202,6 +242,8 @@ func (p *Parser) parseNode(node ast, isRoot bool, terminators []TokenType) *Toke
 		case EXTEND:
 			if p.validateNoNewline(token) {
 				p.processExtend(node, token)
+			} else {
+				p.parseDetached(token)
 			}
 			// node.addChild(newAst(node, NODE_INCLUDE, p.processExtend(node, token)))
 		case TEXT:
@@ -239,8 +281,11 @@ func (p *Parser) parseNode(node ast, isRoot bool, terminators []TokenType) *Toke
 // If Else End
 func (p *Parser) processIfStatement(parent ast, token *Token) {
 	if !p.validateNoNewline(token) {
+		// Parse the block so its @end does not end the enclosing block
+		p.parseBlock(newAst(nil, NODE_ROOT, nil), token, []TokenType{END, ELSE})
 		return
 	}
+	p.validateGo(token, 0, "func _() {\nif ", " {}\n}")
 
 	child := newAst(parent, NODE_IF, token)
 	parent.addChild(child)
@@ -252,8 +297,10 @@ func (p *Parser) processIfStatement(parent ast, token *Token) {
 	}
 	if endToken.Type == END {
 		child.addChild(newAst(child, NODE_ENDIF, endToken))
+		child.end = endToken
+		p.closed(token, endToken)
 	} else {
-		p.addError(token, fmt.Sprintf("Expected %s found %s at line %d, unterminated @for block ", END, endToken.Type, endToken.Line))
+		p.unclosedError(token)
 	}
 }
 
@@ -262,18 +309,96 @@ func (p *Parser) processIfStatement(parent ast, token *Token) {
 // @end
 func (p *Parser) processForBlock(parent ast, token *Token) {
 	if !p.validateNoNewline(token) || !p.validateForStatementCommand(token) {
+		// Parse the block so its @end does not end the enclosing block
+		p.parseBlock(newAst(nil, NODE_ROOT, nil), token, []TokenType{END})
 		return
 	}
+	p.validateGo(token, strings.LastIndex(token.Literal, p.splitFor(token)[2]), "var _ = ", "")
 	child := newAst(parent, NODE_FOR, token)
 	parent.addChild(child)
 	endToken := p.parseNode(child, false, []TokenType{END})
 	if endToken.Type != END {
-		p.addError(token, fmt.Sprintf("Expected %s found %s at line %d, unterminated @for block ", END, endToken.Type, endToken.Line))
+		p.unclosedError(token)
 	} else {
-		child.addChild(newAst(child, NODE_END, token))
+		child.addChild(newAst(child, NODE_END, endToken))
+		child.end = endToken
+		p.closed(token, endToken)
+	}
+}
+
+// parseBlock
+// Parses the content of the block up to one of the terminators, reports a missing @end at the token opening it.
+func (p *Parser) parseBlock(node ast, token *Token, terminators []TokenType) {
+	endToken := p.parseNode(node, false, terminators)
+	if endToken.Type == ELSE {
+		endToken = p.parseNode(node, false, []TokenType{END})
+	}
+	if endToken.Type != END {
+		p.unclosedError(token)
+	}
+}
+
+// parseDetached
+// Parses a block that is in error, ex: an @if directly in an @extend, so its @end does not end the enclosing block.
+// The nodes are not part of the template.
+func (p *Parser) parseDetached(token *Token) {
+	detached := newAst(nil, NODE_ROOT, nil)
+	switch token.Type {
+	case IF:
+		p.processIfStatement(detached, token)
+	case FOR:
+		p.processForBlock(detached, token)
+	case EXTEND:
+		p.processExtend(detached, token)
+	case CONTENT:
+		p.processContent(detached, token)
+	case TEXT:
+		p.processTextBlock(detached, token)
+	case STARTBLOCK:
+		p.processCodeBlock(detached, token)
+	case FUNCTS:
+		p.processFunction(detached, token)
+		p.functions = p.functions[0 : len(p.functions)-1]
+	}
+}
+
+// unclosedError
+// A block without its @end, reported at the command that opened it.
+// When an inner block was ended by an @end lined up with this block, that block is likely the one missing its @end.
+func (p *Parser) unclosedError(token *Token) {
+	msg := fmt.Sprintf("block is not closed, expected %s", END)
+	for _, closed := range p.misaligned {
+		if closed.end.Pos == token.Pos && closed.opener.Line > token.Line && p.startsLine(token) {
+			msg += fmt.Sprintf(", the %s at line %d lines up with this block, is the %s at line %d missing its %s?",
+				END, closed.end.Line, closed.opener.Type, closed.opener.Line, END)
+			break
+		}
+	}
+	p.addError(token, msg)
+}
+
+// closed
+// Records the @end of a block that is not lined up with the command that opened it.
+func (p *Parser) closed(opener *Token, end *Token) {
+	if end.Pos < opener.Pos && p.startsLine(opener) && p.startsLine(end) {
+		p.misaligned = append(p.misaligned, closedBlock{opener: opener, end: end})
 	}
 }
 
+// startsLine
+// True when only white space is before the token on its line.
+func (p *Parser) startsLine(token *Token) bool {
+	lines := strings.Split(p.lex.input, "\n")
+	if token.Line < 1 || token.Line > len(lines) {
+		return false
+	}
+	line := []rune(lines[token.Line-1])
+	if token.Pos-1 > len(line) {
+		return false
+	}
+	return p.IsLiteralWhiteSpace(string(line[0 : token.Pos-1]))
+}
+
 // processCodeBlock
 // Code blocks are placed inline with literal output
 func (p *Parser) processCodeBlock(parent ast, cbtoken *Token) {
@@ -289,10 +414,11 @@ func (p *Parser) processCodeBlock(parent ast, cbtoken *Token) {
 		case LITERAL:
 			child.addChild(newAst(child, NODE_TOKEN_RAW, token))
 		case END:
+			child.end = token
 			return
 		case EOF:
 			// cbtoken to show line of statr code block
-			p.addError(cbtoken, "missing end of code block, unexpected EOF")
+			p.unclosedError(cbtoken)
 			return
 		default:
 			p.addError(token, "Unexpected inside @code")
@@ -301,6 +427,24 @@ func (p *Parser) processCodeBlock(parent ast, cbtoken *Token) {
 	}
 }
 
+// templateNamePattern
+// The names of @name, used as the render function name without Render.
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z_][\w-]*$`)
+
+// processName
+// @name userCard, one per template.
+func (p *Parser) processName(token *Token) {
+	if p.name != nil {
+		p.addError(token, fmt.Sprintf("the template is already named at line %d", p.name.Line))
+		return
+	}
+	if !templateNamePattern.MatchString(strings.TrimSpace(token.Literal)) {
+		p.addError(token, "expects a template name of letters, digits, _ and -, ex: @name userCard")
+		return
+	}
+	p.name = token
+}
+
 // processTextBlock
 // Text blocks are raw output of the text.
 func (p *Parser) processTextBlock(parent ast, cbtoken *Token) {
@@ -312,9 +456,10 @@ func (p *Parser) processTextBlock(parent ast, cbtoken *Token) {
 		case LITERAL:
 			child.addChild(newAst(child, NODE_TOKEN, token))
 		case END:
+			child.end = token
 			return
 		case EOF:
-			p.addError(cbtoken, "missing end of text block, unexpected EOF")
+			p.unclosedError(cbtoken)
 			return
 		default:
 			p.addError(token, "Unexpected inside @text")
@@ -327,8 +472,8 @@ func (p *Parser) processTextBlock(parent ast, cbtoken *Token) {
 // processFunction
 // Collection of literals until end bloc
 // functions are placed outside the main code
-func (p *Parser) processFunction(parent ast, token *Token) {
-	child := newAst(parent, NODE_FUNC, token)
+func (p *Parser) processFunction(parent ast, ftoken *Token) {
+	child := newAst(parent, NODE_FUNC, ftoken)
 	// parent.addChild(child)
 	p.functions = append(p.functions, child)
 
@@ -340,10 +485,11 @@ func (p *Parser) processFunction(parent ast, token *Token) {
 			child.addChild(newAst(child, NODE_TOKEN, token))
 
 		case END:
+			child.end = token
 			return
 
 		case EOF:
-			p.addError(token, "missing end of @func, unexpected EOF")
+			p.unclosedError(ftoken)
 			return
 
 		default:
@@ -363,7 +509,6 @@ func (p *Parser) processExtend(parent ast, token *Token) {
 	child := newAst(parent, NODE_INCLUDE, token)
 	parent.addChild(child)
 
-	trimErrors := false
 	for {
 		token2 := p.lex.NextToken()
 		switch token2.Type {
@@ -372,24 +517,27 @@ func (p *Parser) processExtend(parent ast, token *Token) {
 			if p.IsLiteralWhiteSpace(token2.Literal) {
 				// ok
 			} else {
-				p.addError(token, "Include blocks content must be embedded in a content block (@content)")
+				p.addError(token2, "Include blocks content must be embedded in a content block (@content)")
 			}
 
 		case CONTENT:
 			p.processContent(child, token2)
 
 		case END:
+			child.end = token2
+			p.closed(token, token2)
 			return
 
 		case EOF:
-			p.addError(token, "Expected end of include, unexpected EOF")
+			p.unclosedError(token)
 			return
 
+		case ILLEGAL:
+			p.addErrorAt(token2.Line, token2.Pos, token2.Literal)
+
 		default:
-			if !trimErrors {
-				p.addError(token, fmt.Sprintf("Unexpected %s at line %d", token2.Type, token2.Line))
-				trimErrors = true
-			}
+			p.addError(token2, fmt.Sprintf("must be inside a @content block of the %s at line %d", token.Type, token.Line))
+			p.parseDetached(token2)
 
 		}
 	}
@@ -408,7 +556,10 @@ func (p *Parser) processContent(parent ast, token *Token) {
 
 	endNode := p.parseNode(child, false, []TokenType{END})
 	if endNode.Type != END {
-		p.addError(token, "Content not terminated, expected @end")
+		p.unclosedError(token)
+	} else {
+		child.end = endNode
+		p.closed(token, endNode)
 	}
 
 }
@@ -422,10 +573,14 @@ func (p *Parser) rootRequiredError(token *Token) {
 }
 
 func (p *Parser) addError(token *Token, msg string) {
+	p.addErrorAt(token.Line, token.Pos, fmt.Sprintf("%s : %s", token.Type, msg))
+}
+
+func (p *Parser) addErrorAt(line int, pos int, msg string) {
 	p.errors = append(p.errors, PError{
-		lineNum: token.Line,
-		linePos: token.Pos,
-		msg:     fmt.Sprintf("%s : %s", token.Type, msg),
+		lineNum: line,
+		linePos: pos,
+		msg:     msg,
 	})
 }
 
@@ -457,11 +612,68 @@ func (p *Parser) hasErrors() bool {
 	return len(p.errors) > 0
 }
 
-func (p *Parser) verifySplit(token *Token, count int) {
+func (p *Parser) verifySplit(token *Token, count int) bool {
 	split := strings.SplitN(strings.Trim(token.Literal, " "), " ", count)
 	if len(split) != count {
 		p.addError(token, fmt.Sprintf("Expected %d values split by spaces in %s", count, token.Literal))
+		return false
 	}
+	return true
+}
+
+// validateGo
+// Parses the go code in the token literal, from the byte offset, with go/parser.
+// prefix and suffix make it a go declaration, ex: "var _ = " for an expression.
+// The first syntax error is added at its position in the template.
+func (p *Parser) validateGo(token *Token, offset int, prefix string, suffix string) bool {
+	const header = "package blip\n"
+	src := header + prefix + token.Literal[offset:] + suffix
+	_, err := goparser.ParseFile(gotoken.NewFileSet(), "", src, goparser.AllErrors)
+	if err == nil {
+		return true
+	}
+
+	msg := err.Error()
+	errOffset := 0
+	if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+		msg = list[0].Msg
+		errOffset = list[0].Pos.Offset - len(header) - len(prefix)
+	}
+	// Errors in the suffix are reported at the end of the literal
+	if errOffset < 0 {
+		errOffset = 0
+	}
+	if errOffset > len(token.Literal)-offset {
+		errOffset = len(token.Literal) - offset
+	}
+	line, pos := token.PosAt(offset + errOffset)
+	p.errors = append(p.errors, PError{
+		lineNum: line,
+		linePos: pos,
+		msg:     fmt.Sprintf("%s : %s", token.Type, msg),
+	})
+	return false
+}
+
+// ErrorSnippet
+// The template line of the error with a caret under the position.
+func (p *Parser) ErrorSnippet(err PError) string {
+	lines := strings.Split(p.lex.input, "\n")
+	if err.lineNum < 1 || err.lineNum > len(lines) {
+		return ""
+	}
+	line := []rune(strings.TrimRight(lines[err.lineNum-1], "\r"))
+	var caret strings.Builder
+	for idx := 0; idx < err.linePos-1 && idx < len(line); idx++ {
+		// Keep the tabs so the caret lines up
+		if line[idx] == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
+	return fmt.Sprintf("\t%s\n\t%s\n", string(line), caret.String())
 }
 
 // validateNoNewline
@@ -483,16 +695,29 @@ func (p *Parser) validateNoNewline(token *Token) bool {
 func (p *Parser) validateForStatementCommand(token *Token) bool {
 	sects := p.splitFor(token)
 	if len(sects) != 3 {
-		p.addError(token, fmt.Sprintf("@for expected:  `variable in list` found %d components, note remove any extra spaces", len(sects)))
+		p.addError(token, fmt.Sprintf("@for expected:  `variable in list` found %d components", len(sects)))
 		return false
 	}
 	if sects[1] != "in" {
 		p.addError(token, fmt.Sprintf("@for expected:  `variable in list` found %s instead of  `in`", sects[1]))
 		return false
 	}
+	if !gotoken.IsIdentifier(sects[0]) {
+		p.addError(token, fmt.Sprintf("@for expected:  `variable in list` found %s instead of a variable name", sects[0]))
+		return false
+	}
 	return true
 }
 
+// splitFor
+// The variable, the in and the range expression of a @for, the expression can contain spaces.
 func (p *Parser) splitFor(token *Token) []string {
-	return strings.Split(strings.Trim(token.Literal, " "), " ")
+	fields := strings.Fields(token.Literal)
+	if len(fields) <= 3 {
+		return fields
+	}
+	rest := strings.TrimSpace(token.Literal)
+	rest = strings.TrimSpace(rest[len(fields[0]):])
+	rest = strings.TrimSpace(rest[len(fields[1]):])
+	return []string{fields[0], fields[1], rest}
 }
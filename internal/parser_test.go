@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	goparser "go/parser"
+	"go/token"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -199,3 +203,221 @@ func TestParserText(t *testing.T) {
 	assert.True(t, strings.Contains(result, "<tr><td>\\\"abc\\\"</td></tr>\\n"))
 	assert.True(t, strings.Contains(result, "si.WriteBool(w, true )"))
 }
+
+func TestDisplayLiterals(t *testing.T) {
+	parser := New(NewLexer("@= \"a\" @\n@== \"<\" @\n", "TestLexer1"))
+	parser.Parse()
+	assert.Equal(t, 0, len(parser.errors))
+	var bresult bytes.Buffer
+	NewRender(parser).RenderOutput(&bresult, "template", "index", "html", "test", &BlipOptions{SupportBranch: ""})
+	result := bresult.String()
+
+	// The expression is go code, its quotes are not escaped as in the text
+	assert.Contains(t, result, `si.WriteStrSafe(w, "a" , escaper)`)
+	assert.Contains(t, result, `si.WriteStr(w, "<" )`)
+	_, err := goparser.ParseFile(token.NewFileSet(), "index.go", result, 0)
+	assert.Nil(t, err)
+}
+
+func TestFmtImport(t *testing.T) {
+	render := func(sample string) string {
+		parser := New(NewLexer(sample, "TestLexer1"))
+		parser.Parse()
+		assert.Equal(t, 0, len(parser.errors))
+		var bresult bytes.Buffer
+		NewRender(parser).RenderOutput(&bresult, "template", "index", "html", "test", &BlipOptions{SupportBranch: ""})
+		return bresult.String()
+	}
+
+	// fmt is imported when the template uses it, with or without an @import
+	assert.NotContains(t, render("@arg name string\n@= name @\n"), "fmt")
+	assert.Contains(t, render("@arg n int\n@= fmt.Sprint(n) @\n"), "\t\"fmt\"\n")
+	result := render("@import f \"fmt\"\n@arg n int\n@= f.Sprint(n) @\n")
+	assert.Contains(t, result, "\tf \"fmt\"\n")
+	assert.NotContains(t, result, "\t\"fmt\"\n")
+}
+
+func TestLineDirectives(t *testing.T) {
+	sample := `@arg names []string
+@for n in names
+	<li>@= n @</li>
+@end
+`
+	lex := NewLexer(sample, "TestLexer1")
+	parser := New(lex)
+	parser.Parse()
+	assert.Equal(t, 0, len(parser.errors))
+
+	var bresult bytes.Buffer
+	NewRender(parser).WithLineDirectives("../../list.blip.html", "list.go").RenderOutput(&bresult, "template", "list", "html", "test", &BlipOptions{
+		SupportBranch: "",
+	})
+	result := bresult.String()
+
+	assert.True(t, strings.Contains(result, "/*line ../../list.blip.html:1:6*/names []string"))
+	assert.True(t, strings.Contains(result, "/*line ../../list.blip.html:2:1*/for idx, /*line ../../list.blip.html:2:6*/n := range /*line ../../list.blip.html:2:11*/names {"))
+	assert.True(t, strings.Contains(result, "si.WriteStrSafe(w, /*line ../../list.blip.html:3:9*/n , escaper)"))
+
+	// Back to the generated file before the end of the function
+	lines := strings.Split(result, "\n")
+	last := lines[len(lines)-2]
+	assert.True(t, strings.HasPrefix(last, fmt.Sprintf("/*line list.go:%d:", len(lines)-1)), last)
+}
+
+// goRunRender
+// Runs main with the rendered files in a module using this one, returns the output.
+func goRunRender(t *testing.T, main string, files map[string]string) string {
+	root, err := filepath.Abs("..")
+	assert.Nil(t, err)
+	sum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	writeTemplate(t, dir, "go.mod", "module example.com/boom\n\ngo 1.17\n\nrequire github.com/samlotti/blip v0.0.0\n\nreplace github.com/samlotti/blip => "+root+"\n")
+	writeTemplate(t, dir, "go.sum", string(sum))
+	for name, src := range files {
+		writeTemplate(t, dir, name, src)
+	}
+	writeTemplate(t, dir, "main.go", "package main\n\nimport (\n\t\"context\"\n\t\"fmt\"\n\t\"io\"\n)\n\nfunc main() {\n\t"+main+"\n}\n")
+	cmd := exec.Command("go", "run", "-mod=mod", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	assert.Nil(t, err, string(out))
+	return string(out)
+}
+
+func TestPanicLine(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a module with go")
+	}
+	sample := "@arg items []string\n<p>\n@= items[3] @\n</p>\n"
+	parser := New(NewLexer(sample, "TestLexer1"))
+	parser.Parse()
+	assert.Equal(t, 0, len(parser.errors))
+	var bresult bytes.Buffer
+	NewRender(parser).RenderOutput(&bresult, "main", "boom", "html", "boom.blip.html", &BlipOptions{SupportBranch: "github.com/samlotti/blip/blipUtil"})
+
+	out := goRunRender(t, "fmt.Print(BoomRender(nil, context.Background(), io.Discard))", map[string]string{"boom.go": bresult.String()})
+	assert.Equal(t, "blip: boom (boom.blip.html:3): blip: template boom failed: runtime error: index out of range [3] with length 0", out)
+}
+
+func TestErrorRender(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a module with go")
+	}
+	// A template with errors still compiles, its render returns the errors
+	parser := New(NewLexer("<p>\n@if true\n", "TestLexer1"))
+	parser.Parse()
+	assert.Equal(t, 1, len(parser.errors))
+	var bresult bytes.Buffer
+	NewRender(parser).RenderOutput(&bresult, "main", "broken", "html", "broken.blip.html", &BlipOptions{SupportBranch: "github.com/samlotti/blip/blipUtil"})
+
+	out := goRunRender(t, "fmt.Print(BrokenRender(context.Background(), io.Discard))", map[string]string{"broken.go": bresult.String()})
+	assert.Equal(t, "blip: broken (broken.blip.html:2): blip: template broken failed: Error at 2: @if : block is not closed, expected @end", out)
+}
+
+func TestInterpreterRegistration(t *testing.T) {
+	sample := `@import "strings"
+@arg name, title string
+@arg items []string
+@func
+func upper(s string) string { return strings.ToUpper(s) }
+func (c card) String() string { return "" }
+type card struct{}
+@end
+<h1>@= upper(name) @</h1>
+`
+	render := func(interpreter bool) string {
+		parser := New(NewLexer(sample, "TestLexer1"))
+		parser.Parse()
+		assert.Equal(t, 0, len(parser.errors))
+		var bresult bytes.Buffer
+		r := NewRender(parser)
+		if interpreter {
+			r.WithInterpreter("../../card.blip.html")
+		}
+		r.RenderOutput(&bresult, "cards", "card", "html", "./template/cards/card.blip.html", &BlipOptions{SupportBranch: "github.com/samlotti/blip/blipUtil"})
+		return bresult.String()
+	}
+
+	result := render(true)
+	assert.Contains(t, result, `blipUtil.RegisterTemplate(CardRender, blipUtil.TemplateSource{
+			Name: "card", Source: "./template/cards/card.blip.html", File: "../../card.blip.html",
+			Package: "cards", FileType: "html", Escaper: "html",
+			Args: []string{"name", "title", "items"},`)
+	assert.Contains(t, result, `Funcs: map[string]interface{}{"upper": upper},`)
+	assert.Contains(t, result, `"\"strings\""`)
+	assert.Contains(t, result, "(terror error) {\n\tif blipUtil.Interpreted {\n\t\treturn blipUtil.Interpret(CardRender, c, w, name, title, items)\n\t}\n")
+	_, err := goparser.ParseFile(token.NewFileSet(), "card.go", result, 0)
+	assert.Nil(t, err)
+
+	// Without -interpreter the code is unchanged
+	result = render(false)
+	assert.NotContains(t, result, "Interpret")
+	assert.NotContains(t, result, "func init()")
+}
+
+func TestGoSyntaxErrors(t *testing.T) {
+	sample := `@import "fmt
+@arg name strin g
+@context user *User = 
+<div>@= fmt.Sprintf("%s", name @</div>
+@if len(name) > 
+@end
+@for n in names[
+@end
+@= strings.Repeat("-", 2) @
+`
+	lex := NewLexer(sample, "TestLexer1")
+	parser := New(lex)
+	parser.Parse()
+
+	var found []string
+	for _, err := range parser.errors {
+		found = append(found, fmt.Sprintf("%d:%d %s", err.lineNum, err.linePos, err.msg))
+	}
+	assert.Equal(t, []string{
+		"1:9 @import : string literal not terminated",
+		"2:17 @arg : missing ',' in parameter list",
+		"3:23 @context : expected ';', found 'EOF'",
+		"4:32 @= : expected ')', found 'EOF'",
+		"5:17 @if : expected operand, found '{'",
+		"7:17 @for : expected ';', found 'EOF'",
+	}, found)
+
+	assert.Equal(t, "\t<div>@= fmt.Sprintf(\"%s\", name @</div>\n\t                               ^\n", parser.ErrorSnippet(parser.errors[3]))
+}
+
+func TestErrorRecovery(t *testing.T) {
+	sample := `@incude card "x"
+<p>@= name</p>
+@for n in names
+	@if n == ""
+		empty
+@end
+@extend layout.base "x"
+	@if true
+	@end
+	@content body
+	@end
+@end
+@else
+@content x
+@end
+`
+	lex := NewLexer(sample, "TestLexer1")
+	parser := New(lex)
+	parser.Parse()
+
+	var found []string
+	for _, err := range parser.errors {
+		found = append(found, fmt.Sprintf("%d:%d %s", err.lineNum, err.linePos, err.msg))
+	}
+	assert.Equal(t, []string{
+		"1:1 Invalid command found: @incude, did you mean @include?",
+		"2:4 @= is not closed, expected @ before the end of the line",
+		"8:2 @if : must be inside a @content block of the @extend at line 7",
+		"13:1 @else : no open @if for the @else",
+		"14:1 @content : @content is only allowed inside an @extend block",
+		"3:1 @for : block is not closed, expected @end, the @end at line 6 lines up with this block, is the @if at line 4 missing its @end?",
+	}, found)
+}
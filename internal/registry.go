@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RegistryName
+// The registry of the templates of a generated package, written with -registry.
+const RegistryName = "blip_registry.go"
+
+// The values of -registry
+const (
+	RegistryPackage = "package" // A registry in each generated package
+	RegistryGlobal  = "global"  // And a registry of all packages in the output directory
+)
+
+// CheckRegistry
+// Returns an error if the registry is not empty, package or global.
+func CheckRegistry(registry string) error {
+	switch registry {
+	case "", RegistryPackage, RegistryGlobal:
+		return nil
+	}
+	return fmt.Errorf("unknown registry %q, expected %s or %s", registry, RegistryPackage, RegistryGlobal)
+}
+
+// registryPackage
+// A generated package with a registry.
+type registryPackage struct {
+	dir       string
+	name      string
+	colocated bool // Generated next to the templates, in a package with hand written code
+}
+
+// templatesVar
+// The registry variable of the package, prefixed when colocated so it does not collide with the hand written code.
+func (pkg registryPackage) templatesVar() string {
+	if pkg.colocated {
+		return "BlipTemplates"
+	}
+	return "Templates"
+}
+
+// renderFunc
+// The Render function of the package, prefixed as templatesVar.
+func (pkg registryPackage) renderFunc() string {
+	if pkg.colocated {
+		return "BlipRender"
+	}
+	return "Render"
+}
+
+// registries
+// The registry files of the templates by file name, none without -registry.
+// The templates with errors are left out, their render function is not valid go.
+func registries(modDir string, symbols *symbolTable, opt *BlipOptions) map[string][]byte {
+	files := make(map[string][]byte)
+	if opt.Registry == "" {
+		return files
+	}
+	dirs := make([]string, 0, len(symbols.templates))
+	for dir := range symbols.templates {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var packages []registryPackage
+	for _, dir := range dirs {
+		functions := make([]string, 0, len(symbols.templates[dir]))
+		for function, ts := range symbols.templates[dir] {
+			if !ts.parser.hasErrors() {
+				functions = append(functions, function)
+			}
+		}
+		if len(functions) == 0 {
+			continue
+		}
+		sort.Strings(functions)
+		var templates []*templateSymbols
+		for _, function := range functions {
+			templates = append(templates, symbols.templates[dir][function])
+		}
+		pkg := registryPackage{dir: filepath.Clean(dir), name: symbols.packages[dir]}
+		for _, ts := range templates {
+			if sdir, err := filepath.Abs(ts.file.sdir); err == nil && sdir == pkg.dir {
+				pkg.colocated = true
+			}
+		}
+		files[filepath.Join(pkg.dir, RegistryName)] = renderRegistry(pkg, templates, opt)
+		packages = append(packages, pkg)
+	}
+	outDir := opt.outputDir(modDir)
+	if opt.Registry == RegistryGlobal && len(packages) > 0 {
+		if _, ok := files[filepath.Join(outDir, RegistryName)]; !ok {
+			// Else the templates of the output directory have the registry of their package
+			global := registryPackage{dir: filepath.Clean(outDir)}
+			files[filepath.Join(outDir, RegistryName)] = renderGlobalRegistry(modDir, global, packages, opt)
+		}
+	}
+	return files
+}
+
+// registryHeader
+// The package clause and the imports of a registry file.
+func registryHeader(b *bytes.Buffer, pkg string, imports []string) {
+	fmt.Fprintf(b, "package %s\n\n// Do Not Edit\n%s\nimport (\n", pkg, generatedMarker)
+	for _, imp := range imports {
+		fmt.Fprintf(b, "\t%s\n", imp)
+	}
+	b.WriteString(")\n")
+}
+
+// registryRender
+// The Render function of a registry file.
+func registryRender(b *bytes.Buffer, pkg registryPackage) {
+	fmt.Fprintf(b, `
+// %[1]s
+// Renders the template named at runtime, args are its @arg by name. See blipUtil.Registry
+func %[1]s(name string, args map[string]interface{}, c context.Context, w io.Writer) error {
+	return %[2]s.Render(name, args, c, w)
+}
+`, pkg.renderFunc(), pkg.templatesVar())
+}
+
+// renderRegistry
+// The registry of the templates of a package.
+func renderRegistry(pkg registryPackage, templates []*templateSymbols, opt *BlipOptions) []byte {
+	var b bytes.Buffer
+	registryHeader(&b, pkg.name, []string{`"context"`, `"io"`, "", fmt.Sprintf("%q", opt.SupportBranch)})
+	fmt.Fprintf(&b, "\n// %[1]s\n// The templates of the package by name.\nvar %[1]s = blipUtil.NewRegistry(\n", pkg.templatesVar())
+	for _, ts := range templates {
+		name := ts.file.renderName(ts.parser)
+		fmt.Fprintf(&b, "\t&blipUtil.TemplateMeta{\n\t\tName: %q,\n\t\tPackage: %q,\n\t\tFileType: %q,\n\t\tSource: %q,\n",
+			name, pkg.name, ts.file.fileType, ts.file.sourceFName)
+		if len(ts.args) > 0 {
+			b.WriteString("\t\tArgs: []blipUtil.TemplateVar{\n")
+			for _, arg := range argVars(ts.args) {
+				fmt.Fprintf(&b, "\t\t\t{Name: %q, Type: %q},\n", arg.name, arg.typ)
+			}
+			b.WriteString("\t\t},\n")
+		}
+		if len(ts.context) > 0 {
+			b.WriteString("\t\tContext: []blipUtil.TemplateVar{\n")
+			for _, cv := range ts.context {
+				decl := cv.Literal
+				initial := strings.Contains(decl, "=")
+				if initial {
+					decl = decl[:strings.Index(decl, "=")]
+				}
+				split := strings.SplitN(strings.TrimSpace(decl), " ", 2)
+				typ := ""
+				if len(split) > 1 {
+					typ = strings.TrimSpace(split[1])
+				}
+				if initial {
+					fmt.Fprintf(&b, "\t\t\t{Name: %q, Type: %q, Default: true},\n", split[0], typ)
+				} else {
+					fmt.Fprintf(&b, "\t\t\t{Name: %q, Type: %q},\n", split[0], typ)
+				}
+			}
+			b.WriteString("\t\t},\n")
+		}
+		if len(ts.yields) > 0 {
+			var yields []string
+			for _, yield := range ts.yields {
+				yields = append(yields, fmt.Sprintf("%q", yield))
+			}
+			fmt.Fprintf(&b, "\t\tYields: []string{%s},\n", strings.Join(yields, ", "))
+		}
+		fmt.Fprintf(&b, "\t\tRender: %s,\n\t},\n", templateFunctionName(name))
+	}
+	b.WriteString(")\n")
+	registryRender(&b, pkg)
+	return formatRegistry(b.Bytes())
+}
+
+// renderGlobalRegistry
+// The registry of the templates of all packages, named by their package directory relative to the output directory, ex: pages.index
+// The packages outside of it are named relative to the module, ex: web/views.index
+func renderGlobalRegistry(modDir string, global registryPackage, packages []registryPackage, opt *BlipOptions) []byte {
+	modPath := readModulePath(modDir)
+	outDir := global.dir
+	global.name = goPackageOf(outDir)
+	if global.name == "" {
+		global.name = goPackageName(filepath.Base(outDir))
+	}
+
+	imports := []string{`"context"`, `"io"`, ""}
+	var entries []string
+	aliases := make(map[string]bool)
+	for _, p := range packages {
+		if p.name == "main" {
+			// Cannot be imported
+			continue
+		}
+		rel, err := filepath.Rel(outDir, p.dir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel, _ = filepath.Rel(modDir, p.dir)
+		}
+		modRel, _ := filepath.Rel(modDir, p.dir)
+		alias := p.name
+		for idx := 2; aliases[alias] || alias == "blipUtil" || alias == "context" || alias == "io"; idx++ {
+			alias = fmt.Sprintf("%s%d", p.name, idx)
+		}
+		aliases[alias] = true
+		importPath := modPath + "/" + filepath.ToSlash(modRel)
+		if alias == path.Base(importPath) {
+			imports = append(imports, fmt.Sprintf("%q", importPath))
+		} else {
+			imports = append(imports, fmt.Sprintf("%s %q", alias, importPath))
+		}
+		entries = append(entries, fmt.Sprintf("\t%q: %s.%s,\n", filepath.ToSlash(rel), alias, p.templatesVar()))
+	}
+	imports = append(imports, fmt.Sprintf("%q", opt.SupportBranch))
+
+	var b bytes.Buffer
+	registryHeader(&b, global.name, imports)
+	b.WriteString("\n// Templates\n// The templates of all packages by package and name, ex: pages.index\nvar Templates = blipUtil.MergeRegistries(map[string]*blipUtil.Registry{\n")
+	for _, entry := range entries {
+		b.WriteString(entry)
+	}
+	b.WriteString("})\n")
+	registryRender(&b, global)
+	return formatRegistry(b.Bytes())
+}
+
+// formatRegistry
+// gofmt of the registry, as is if it does not parse.
+func formatRegistry(code []byte) []byte {
+	formatted, err := format.Source(code)
+	if err != nil {
+		return code
+	}
+	return formatted
+}
+
+// writeRegistries
+// Writes the registry files that changed and removes those no longer generated.
+func writeRegistries(modDir string, symbols *symbolTable, manifest *buildManifest, opt *BlipOptions, diags *diagnostics) {
+	files := registries(modDir, symbols, opt)
+	names := make([]string, 0, len(files))
+	for fname := range files {
+		names = append(names, fname)
+	}
+	sort.Strings(names)
+
+	var outputs []string
+	for _, fname := range names {
+		onDisk, err := ioutil.ReadFile(fname)
+		if err == nil && !bytes.Contains(onDisk, []byte(generatedMarker)) {
+			diags.add(Diagnostic{File: fname, Message: "the registry is not written over a hand written file, rename it"})
+			continue
+		}
+		outputs = append(outputs, manifest.key(fname))
+		if bytes.Equal(onDisk, files[fname]) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			diags.addError(fname, err)
+			continue
+		}
+		if err := ioutil.WriteFile(fname, files[fname], 0666); err != nil {
+			diags.addError(fname, err)
+			continue
+		}
+		fmt.Fprintf(opt.progress(), "\nRegistry: %s", manifest.key(fname))
+	}
+
+	removed, err := manifest.registered(outputs)
+	for _, output := range removed {
+		fmt.Fprintf(opt.progress(), "\nRemoved: %s", output)
+	}
+	if err != nil {
+		diags.addError(manifest.path(), err)
+	}
+}
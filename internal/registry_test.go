@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildRegistries
+// Writes the registries of the module as the builds do.
+func buildRegistries(t *testing.T, modDir string, opt *BlipOptions) *diagnostics {
+	diags := &diagnostics{}
+	var files []*blipFile
+	collectBlipFiles(filepath.Join(modDir, "blipped"), filepath.Join(modDir, "template"), &files, diags)
+	symbols := loadSymbols(files, diags)
+	manifest := loadManifest(modDir, filepath.Join(modDir, "blipped"), opt)
+	writeRegistries(modDir, symbols, manifest, opt, diags)
+	assert.Nil(t, manifest.save())
+	return diags
+}
+
+func TestRegistry(t *testing.T) {
+	modDir := t.TempDir()
+	writeTemplate(t, modDir, "go.mod", "module example.com/m\n\ngo 1.17\n")
+	sdir := filepath.Join(modDir, "template", "pages")
+	writeTemplate(t, sdir, "layout.blip.html", "@arg title string\n<title>@= title @</title>\n@yield body\n@yield head\n")
+	writeTemplate(t, sdir, "index.blip.html", "@arg title string\n@arg items []string\n@context user string\n@context lang string = \"en\"\n<p>@= title @</p>\n")
+	writeTemplate(t, sdir, "broken.blip.html", "@if true\n")
+	writeTemplate(t, sdir, "card.blip.html", "@arg first, last string\n@arg n int\n<p>@= first + last @ @int= n @</p>\n")
+	writeTemplate(t, filepath.Join(modDir, "template", "mail"), "welcome.blip.txt", "hi\n")
+	opt := &BlipOptions{SupportBranch: "github.com/samlotti/blip/blipUtil", Registry: RegistryGlobal}
+
+	assert.Equal(t, 0, len(buildRegistries(t, modDir, opt).list))
+	read := func(name string) string {
+		src, err := ioutil.ReadFile(filepath.Join(modDir, "blipped", name))
+		assert.Nil(t, err)
+		_, err = parser.ParseFile(token.NewFileSet(), name, src, 0)
+		assert.Nil(t, err)
+		return string(src)
+	}
+
+	pages := read("pages/" + RegistryName)
+	assert.Contains(t, pages, "package pages\n")
+	assert.Contains(t, pages, generatedMarker)
+	assert.Contains(t, pages, `		Name:     "index",
+		Package:  "pages",
+		FileType: "html",
+		Source:   "`+sdir+`/index.blip.html",
+		Args: []blipUtil.TemplateVar{
+			{Name: "title", Type: "string"},
+			{Name: "items", Type: "[]string"},
+		},
+		Context: []blipUtil.TemplateVar{
+			{Name: "user", Type: "string"},
+			{Name: "lang", Type: "string", Default: true},
+		},
+		Render: IndexRender,
+`)
+	assert.Contains(t, pages, `Yields: []string{"body", "head"},`)
+	// One TemplateVar per name, as in the render function
+	assert.Contains(t, pages, `		Args: []blipUtil.TemplateVar{
+			{Name: "first", Type: "string"},
+			{Name: "last", Type: "string"},
+			{Name: "n", Type: "int"},
+		},
+		Render: CardRender,
+`)
+	assert.NotContains(t, pages, "BrokenRender")
+	assert.Contains(t, pages, "func Render(name string, args map[string]interface{}, c context.Context, w io.Writer) error {")
+
+	global := read(RegistryName)
+	assert.Contains(t, global, "package blipped\n")
+	assert.Contains(t, global, `	"example.com/m/blipped/mail"
+	"example.com/m/blipped/pages"
+`)
+	assert.Contains(t, global, `var Templates = blipUtil.MergeRegistries(map[string]*blipUtil.Registry{
+	"mail":  mail.Templates,
+	"pages": pages.Templates,
+})`)
+
+	manifest := loadManifest(modDir, filepath.Join(modDir, "blipped"), opt)
+	assert.Equal(t, []string{"blipped/blip_registry.go", "blipped/mail/blip_registry.go", "blipped/pages/blip_registry.go"}, manifest.Registries)
+
+	// The registries no longer written are removed
+	opt.Registry = RegistryPackage
+	buildRegistries(t, modDir, opt)
+	assert.False(t, exists(filepath.Join(modDir, "blipped", RegistryName)))
+	assert.True(t, exists(filepath.Join(modDir, "blipped", "pages", RegistryName)))
+
+	opt.Registry = ""
+	buildRegistries(t, modDir, opt)
+	assert.False(t, exists(filepath.Join(modDir, "blipped", "pages", RegistryName)))
+
+	// A hand written file is kept
+	writeTemplate(t, filepath.Join(modDir, "blipped", "mail"), RegistryName, "package mail\n")
+	opt.Registry = RegistryPackage
+	diags := buildRegistries(t, modDir, opt)
+	assert.Equal(t, 1, len(diags.list))
+	src, _ := os.ReadFile(filepath.Join(modDir, "blipped", "mail", RegistryName))
+	assert.Equal(t, "package mail\n", string(src))
+}
+
+func TestColocatedRegistry(t *testing.T) {
+	modDir := t.TempDir()
+	writeTemplate(t, modDir, "go.mod", "module example.com/m\n\ngo 1.17\n")
+	views := filepath.Join(modDir, "web", "views")
+	writeTemplate(t, views, "views.go", "package views\n\nvar Templates = []string{}\n\nfunc Render() {}\n")
+	writeTemplate(t, views, "list.blip.html", "@arg items []string\n")
+	writeTemplate(t, filepath.Join(modDir, "template", "pages"), "index.blip.html", "<p>\n")
+	opt := &BlipOptions{SupportBranch: "github.com/samlotti/blip/blipUtil", Registry: RegistryGlobal}
+
+	diags := &diagnostics{}
+	var files []*blipFile
+	collectBlipFiles(filepath.Join(modDir, "blipped"), filepath.Join(modDir, "template"), &files, diags)
+	collectBlipFiles("", views, &files, diags)
+	generated := registries(modDir, loadSymbols(files, diags), opt)
+	assert.Equal(t, 0, len(diags.list))
+
+	// The names of the hand written code of the package are not declared again
+	colocated := string(generated[filepath.Join(views, RegistryName)])
+	assert.Contains(t, colocated, "package views\n")
+	assert.Contains(t, colocated, "var BlipTemplates = blipUtil.NewRegistry(")
+	assert.Contains(t, colocated, "func BlipRender(name string, args map[string]interface{}, c context.Context, w io.Writer) error {\n\treturn BlipTemplates.Render(name, args, c, w)\n}")
+	assert.NotContains(t, colocated, "var Templates")
+
+	pages := string(generated[filepath.Join(modDir, "blipped", "pages", RegistryName)])
+	assert.Contains(t, pages, "var Templates = blipUtil.NewRegistry(")
+
+	global := string(generated[filepath.Join(modDir, "blipped", RegistryName)])
+	assert.Contains(t, global, `	"pages":     pages.Templates,
+	"web/views": views.BlipTemplates,
+`)
+}
+
+func TestCheckRegistry(t *testing.T) {
+	assert.Nil(t, CheckRegistry(""))
+	assert.Nil(t, CheckRegistry(RegistryGlobal))
+	assert.EqualError(t, CheckRegistry("all"), `unknown registry "all", expected package or global`)
+}
@@ -3,34 +3,70 @@ package internal
 import (
 	"bytes"
 	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	"go/token"
 	"io"
 	"sort"
 	"strings"
+	"unicode"
 )
 
 type Render struct {
 	p            *Parser
 	includeDepth int
+	sourceFile   string
+	lineSource   string // The template as named in //line directives, empty for no directives
+	lineGen      string // The generated file as named in //line directives
+	outLine      int    // Line of the next character written
+	outCol       int    // Column of the next character written
+	mapped       bool   // Set when a directive has mapped the output to the template
+	text         *textFilter
+	interpSource string   // The template relative to the generated file, registered for the interpreter. Empty without -interpreter
+	imports      []string // The imports written
 }
 
 func NewRender(p *Parser) *Render {
 	return &Render{
 		p:            p,
 		includeDepth: 0,
+		outLine:      1,
+		outCol:       1,
 	}
 }
 
+// WithLineDirectives
+// Writes //line directives so the go compiler, go vet, panics and coverage report the template position.
+// sourcePath is the template relative to the directory of the generated file, genFile is the generated file name.
+func (r *Render) WithLineDirectives(sourcePath string, genFile string) *Render {
+	r.lineSource = sourcePath
+	r.lineGen = genFile
+	return r
+}
+
+// WithInterpreter
+// Registers the template for the interpreter of the builds with -tags blipdev, the render function interprets the template file.
+// sourcePath is the template relative to the directory of the generated file.
+func (r *Render) WithInterpreter(sourcePath string) *Render {
+	r.interpSource = sourcePath
+	return r
+}
+
 // RenderOutput --
 // Writes the parse treee out as a golang file
 func (r *Render) RenderOutput(o io.Writer, packageName string, templateName string, langType string, sourcefile string, opt *BlipOptions) {
 	r.wStr(o, "package ").wStr(o, packageName).wNL(o)
 
 	r.wStr(o, "// Do Not Edit\n")
-	r.wStr(o, fmt.Sprintf("// Generated by Blip\n"))
+	r.wStr(o, generatedMarker)
 	r.wStr(o, fmt.Sprintf("// source blip: %s\n", sourcefile))
+	r.sourceFile = sourcefile
+	r.text = newTextFilter(opt, langType)
 
 	r.outputImports(o, opt)
 	r.writeFuncts(o)
+	r.wGen(o)
+	r.writeRegistration(o, packageName, templateName, langType, opt)
 
 	r.writeMainFunction(o, templateName, langType, opt)
 }
@@ -43,22 +79,31 @@ func (r *Render) writeMainFunction(o io.Writer, templateName string, langType st
 
 	if !r.p.hasErrors() {
 		r.writeArgVar(o)
+		r.wGen(o)
 	}
 
 	r.wStr(o, "c context.Context, w io.Writer ) ")
 	r.wStr(o, `(terror error) {`)
+	if r.interpSource != "" && !r.p.hasErrors() {
+		r.wStr(o, fmt.Sprintf("\n\tif blipUtil.Interpreted {\n\t\treturn blipUtil.Interpret(%s, %s)\n\t}",
+			r.convertTemplateNameToFunctionName(templateName), strings.Join(append([]string{"c", "w"}, r.argNames()...), ", ")))
+	}
 	r.wStr(o, "\n    start := time.Now()\n")
 	r.wStr(o, `
 	var si = blipUtil.Instance()
+	var tinfo = si.TemplateInfo(`)
+	r.wStr(o, fmt.Sprintf("%q, %q)", templateName, r.sourceFile))
+	r.wStr(o, `
+	// The template line of the node rendering, for the panics
+	var tline = 0
 	var escaper = si.GetEscaperFor( "`)
-	r.wStr(o, langType)
+	r.wStr(o, opt.escaperFor(langType))
 	r.wStr(o, `") 
 	defer func() {
 		if err := recover(); err != nil {
-			fmt.Printf("Catch panic %s: %s\n", "`)
-	r.wStr(o, r.convertTemplateNameToFunctionName(templateName))
-	r.wStr(o, `", err)
-			terror = fmt.Errorf("%v", err)
+			terror = tinfo.Wrap(si.RecoverError("`)
+	r.wStr(o, templateName)
+	r.wStr(o, `", err), tline)
 		}`)
 	r.wStr(o, `
 	`)
@@ -68,15 +113,23 @@ func (r *Render) writeMainFunction(o io.Writer, templateName string, langType st
 `)
 
 	if r.p.hasErrors() {
-		r.wStr(o, "Errors found in transforming the template\n")
+		// Still valid go so the package compiles, the render returns the errors
+		r.wStr(o, "\t// Errors found in transforming the template\n")
+		var msgs []string
 		for _, err := range r.p.errors {
-			r.wStr(o, fmt.Sprintf("Error at %d: %s\n", err.lineNum, err.msg))
+			msg := fmt.Sprintf("Error at %d: %s", err.lineNum, strings.ReplaceAll(err.msg, "\n", " "))
+			r.wStr(o, fmt.Sprintf("\t// %s\n", msg))
+			msgs = append(msgs, msg)
 		}
-		r.wStr(o, "\n")
+		first := r.p.errors[0]
+		r.wSrc(o, first.lineNum, first.linePos)
+		r.wStr(o, fmt.Sprintf("\tterror = tinfo.Wrap(&blipUtil.TemplateError{Template: %q, Err: errors.New(%q)}, %d)\n",
+			templateName, strings.Join(msgs, "\n"), first.lineNum))
 	} else {
 		r.writeContentVar(o)
 		r.writeBody(r.p.root, 1, o, opt)
 	}
+	r.wGen(o)
 
 	r.wStr(o, "\treturn")
 	r.wStr(o, "\n}")
@@ -86,14 +139,15 @@ func (r *Render) writeMainFunction(o io.Writer, templateName string, langType st
 func (r *Render) outputImports(o io.Writer, opt *BlipOptions) {
 
 	imports := make(map[string]string)
+	// The template tokens of the @imports, for the line directives
+	importTokens := make(map[string]*Token)
 
-	if !r.p.hasErrors() {
-		imports["\""+opt.SupportBranch+"\""] = ""
-		// imports["\"github.com/samlotti/blip/blipUtil\""] = ""
+	imports["\""+opt.SupportBranch+"\""] = ""
+	if r.p.hasErrors() {
+		imports["\"errors\""] = ""
 	}
 	imports["\"context\""] = ""
 	imports["\"io\""] = ""
-	imports["\"fmt\""] = ""
 	imports["\"time\""] = ""
 
 	if !r.p.hasErrors() {
@@ -101,6 +155,20 @@ func (r *Render) outputImports(o io.Writer, opt *BlipOptions) {
 			trimmed := strings.Trim(imp.Literal, "\t")
 			trimmed = strings.Trim(trimmed, " ")
 			imports[strings.Trim(trimmed, " ")] = ""
+			importTokens[trimmed] = imp
+		}
+		// The imports of blip.yaml, when the template uses them
+		used := templateIdentifiers(r.p.Template())
+		// fmt can be used without an @import
+		if used["fmt"] && !importsName(imports, "fmt") {
+			imports["\"fmt\""] = ""
+		}
+		for _, spec := range opt.defaultImports() {
+			if name, ok := importName(spec); ok && used[name] {
+				if _, ok := imports[spec]; !ok {
+					imports[spec] = ""
+				}
+			}
 		}
 	}
 
@@ -109,13 +177,32 @@ func (r *Render) outputImports(o io.Writer, opt *BlipOptions) {
 		imports2 = append(imports2, k)
 	}
 	sort.Strings(imports2)
+	r.imports = imports2
 	r.wStr(o, "\nimport (")
 	for _, v := range imports2 {
-		r.wStr(o, "\n\t").wStr(o, v)
+		r.wStr(o, "\n\t")
+		if tok, ok := importTokens[v]; ok {
+			r.wTokenAt(o, tok, strings.Index(tok.Literal, v))
+		} else {
+			r.wGen(o)
+		}
+		r.wStr(o, v)
 	}
+	r.wGen(o)
 	r.wStr(o, "\n)\n\n")
 }
 
+// importsName
+// True if one of the imports is named name.
+func importsName(imports map[string]string, name string) bool {
+	for spec := range imports {
+		if n, ok := importName(spec); ok && n == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Render) writeFuncts(o io.Writer) {
 	if r.p.hasErrors() {
 		return
@@ -123,20 +210,168 @@ func (r *Render) writeFuncts(o io.Writer) {
 	for _, fa := range r.p.functions {
 		for _, ft := range fa.GetChildren() {
 			r.wStr(o, fmt.Sprintf("// Function block from line: %d\n", ft.GetToken().Line))
+			r.wTokenAt(o, ft.GetToken(), 0)
 			r.wStr(o, ft.GetToken().Literal)
 		}
 	}
 }
 
+// writeRegistration
+// With -interpreter, the init registering the template for the interpreter of the builds with -tags blipdev.
+func (r *Render) writeRegistration(o io.Writer, packageName string, templateName string, langType string, opt *BlipOptions) {
+	if r.interpSource == "" || r.p.hasErrors() {
+		return
+	}
+	quoted := func(list []string) string {
+		for idx, s := range list {
+			list[idx] = fmt.Sprintf("%q", s)
+		}
+		return strings.Join(list, ", ")
+	}
+	var funcs []string
+	for _, name := range r.funcNames() {
+		funcs = append(funcs, fmt.Sprintf("%q: %s", name, name))
+	}
+	r.wStr(o, "\nfunc init() {\n\tif blipUtil.Interpreted {\n")
+	r.wStr(o, fmt.Sprintf("\t\tblipUtil.RegisterTemplate(%s, blipUtil.TemplateSource{\n", r.convertTemplateNameToFunctionName(templateName)))
+	r.wStr(o, fmt.Sprintf("\t\t\tName: %q, Source: %q, File: %q,\n", templateName, r.sourceFile, r.interpSource))
+	r.wStr(o, fmt.Sprintf("\t\t\tPackage: %q, FileType: %q, Escaper: %q,\n", packageName, langType, opt.escaperFor(langType)))
+	r.wStr(o, fmt.Sprintf("\t\t\tArgs: []string{%s},\n", quoted(r.argNames())))
+	r.wStr(o, fmt.Sprintf("\t\t\tImports: []string{%s},\n", quoted(append([]string(nil), r.imports...))))
+	r.wStr(o, fmt.Sprintf("\t\t\tFuncs: map[string]interface{}{%s},\n", strings.Join(funcs, ", ")))
+	r.wStr(o, "\t\t})\n\t}\n}\n")
+}
+
+// argNames
+// The names of the @arg, in the order of the render function: @arg a, b string declares a and b.
+func (r *Render) argNames() []string {
+	var names []string
+	for _, arg := range argVars(r.p.args) {
+		names = append(names, arg.name)
+	}
+	return names
+}
+
+// funcNames
+// The functions declared by the @func blocks, the methods are not.
+func (r *Render) funcNames() []string {
+	var names []string
+	for _, fa := range r.p.functions {
+		for _, ft := range fa.GetChildren() {
+			file, err := goparser.ParseFile(token.NewFileSet(), "", "package p\n"+ft.GetToken().Literal, 0)
+			if err != nil {
+				// Reported by the go compiler
+				continue
+			}
+			for _, decl := range file.Decls {
+				if fn, ok := decl.(*goast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name != "init" && fn.Name.Name != "_" {
+					names = append(names, fn.Name.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
 func (r *Render) wStr(o io.Writer, msg string) *Render {
 	o.Write([]byte(msg))
+	for idx := 0; idx < len(msg); idx++ {
+		if msg[idx] == '\n' {
+			r.outLine += 1
+			r.outCol = 1
+		} else {
+			r.outCol += 1
+		}
+	}
 	return r
 }
-func (r *Render) wNL(o io.Writer) *Render {
-	o.Write([]byte("\n"))
+
+// lineAt
+// Returns a /*line*/ directive, the character following it is reported at line:col of the template.
+// Returns an empty string when line directives are not enabled.
+func (r *Render) lineAt(line int, col int) string {
+	if r.lineSource == "" || line <= 0 {
+		return ""
+	}
+	if col <= 0 {
+		col = 1
+	}
+	r.mapped = true
+	return fmt.Sprintf("/*line %s:%d:%d*/", r.lineSource, line, col)
+}
+
+// tokenAt
+// Returns a line directive for the byte offset within the token literal, see lineAt.
+func (r *Render) tokenAt(tok *Token, offset int) string {
+	if offset < 0 {
+		offset = 0
+	}
+	return r.lineAt(tok.PosAt(offset))
+}
+
+// exprAt
+// Returns a line directive for the first non blank character of the token literal, see lineAt.
+func (r *Render) exprAt(tok *Token) string {
+	return r.tokenAt(tok, len(tok.Literal)-len(strings.TrimLeft(tok.Literal, " \t")))
+}
+
+// wSrc
+// Writes a line directive for the template position, see lineAt.
+func (r *Render) wSrc(o io.Writer, line int, col int) *Render {
+	return r.wStr(o, r.lineAt(line, col))
+}
+
+// wTokenAt
+// Writes a line directive for the byte offset within the token literal, see lineAt.
+func (r *Render) wTokenAt(o io.Writer, tok *Token, offset int) *Render {
+	return r.wStr(o, r.tokenAt(tok, offset))
+}
+
+// wGen
+// Writes a line directive returning to the positions of the generated file after the template was mapped.
+func (r *Render) wGen(o io.Writer) *Render {
+	if !r.mapped {
+		return r
+	}
+	r.mapped = false
+	// The column reported is after the directive so depends on its own length
+	col := r.outCol
+	for {
+		directive := fmt.Sprintf("/*line %s:%d:%d*/", r.lineGen, r.outLine, col)
+		if r.outCol+len(directive) == col {
+			return r.wStr(o, directive)
+		}
+		col = r.outCol + len(directive)
+	}
+}
+
+// wCall
+// Writes a runtime call that returns an error, the render returns when it fails.
+// The error is wrapped with the template line of the node.
+func (r *Render) wCall(o io.Writer, tabs string, call string, tok *Token) *Render {
+	r.wLine(o, tabs, tok)
+	r.wStr(o, fmt.Sprintf("%s%sterror = %s\n", tabs, r.lineAt(tok.Line, tok.Pos), call))
+	r.wCheck(o, tabs, tok)
+	return r
+}
+
+// wCheck
+// Returns terror with the template location when set.
+func (r *Render) wCheck(o io.Writer, tabs string, tok *Token) *Render {
+	r.wStr(o, fmt.Sprintf("%s%sif terror != nil { return tinfo.Wrap(terror, %d) }\n", tabs, r.lineAt(tok.Line, tok.Pos), tok.Line))
 	return r
 }
 
+// wLine
+// Sets tline to the template line of the node, a panic from its go code is reported at that line.
+func (r *Render) wLine(o io.Writer, tabs string, tok *Token) *Render {
+	return r.wStr(o, fmt.Sprintf("%s%stline = %d\n", tabs, r.lineAt(tok.Line, tok.Pos), tok.Line))
+}
+
+func (r *Render) wNL(o io.Writer) *Render {
+	return r.wStr(o, "\n")
+}
+
 func (r *Render) writeArgVar(o io.Writer) {
 	for idx, c := range r.p.args {
 		if idx > 0 {
@@ -144,6 +379,7 @@ func (r *Render) writeArgVar(o io.Writer) {
 		}
 		// 	user := c.Value("user").(manual.User)
 		split := r.splitString(c.Literal, 2)
+		r.wStr(o, r.exprAt(c))
 		r.wStr(o, split[0])
 		r.wStr(o, " ")
 		r.wStr(o, split[1])
@@ -162,10 +398,14 @@ func (r *Render) WriteNodeSimpleCall(o io.Writer, node ast, depth int, contextNa
 	// @include Base @
 	r.wNL(o)
 
-	splits := r.splitString(node.GetToken().Literal, 2)
+	tok := node.GetToken()
+	splits := r.splitString(tok.Literal, 2)
 	funName := r.convertTemplateNameToFunctionName(splits[0])
+	r.wLine(o, r.getTabsDepth(depth), tok)
 	r.wStr(o, r.getTabsDepth(depth))
+	r.wSrc(o, tok.Line, tok.Pos)
 	r.wStr(o, "terror = ")
+	r.wStr(o, r.exprAt(tok))
 	r.wStr(o, funName).wStr(o, "(")
 	for idx, s := range splits {
 		if idx == 0 {
@@ -174,6 +414,7 @@ func (r *Render) WriteNodeSimpleCall(o io.Writer, node ast, depth int, contextNa
 		if idx > 1 {
 			r.wStr(o, ", ")
 		}
+		r.wStr(o, r.tokenAt(tok, strings.LastIndex(tok.Literal, s)))
 		r.wStr(o, s)
 	}
 	if len(splits) > 1 {
@@ -181,8 +422,7 @@ func (r *Render) WriteNodeSimpleCall(o io.Writer, node ast, depth int, contextNa
 	}
 	r.wStr(o, fmt.Sprintf("%s, w)\n", contextName))
 
-	r.wStr(o, r.getTabsDepth(depth))
-	r.wStr(o, "if terror != nil { return }\n")
+	r.wCheck(o, r.getTabsDepth(depth), tok)
 
 }
 
@@ -190,11 +430,27 @@ func (r *Render) WriteNodeSimpleCall(o io.Writer, node ast, depth int, contextNa
 // convert   path.path.templateName
 // to        path.path.TemplateNameRender
 func (r *Render) convertTemplateNameToFunctionName(templateName string) string {
+	return templateFunctionName(templateName)
+}
+
+// templateFunctionName
+// The render function of a template, see convertTemplateNameToFunctionName.
+// The characters not allowed in a go name separate the words, ex: user-card -> UserCardRender
+func templateFunctionName(templateName string) string {
 	sects := strings.Split(templateName, ".")
 	last := len(sects) - 1
-	sects[last] = strings.Title(sects[last]) + "Render"
+	words := strings.FieldsFunc(sects[last], func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	name := ""
+	for _, word := range words {
+		name += strings.Title(word)
+	}
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "T" + name
+	}
+	sects[last] = name + "Render"
 	return strings.Join(sects, ".")
-
 }
 
 func (r *Render) getTabsDepth(depth int) string {
@@ -226,22 +482,30 @@ func (r *Render) writeBody(node ast, depth int, o io.Writer, opt *BlipOptions) {
 
 		switch base.nodeType {
 		case NODE_TOKEN_RAW:
+			r.wTokenAt(o, base.token, 0)
 			r.wStr(o, base.token.Literal)
 		case NODE_TOKEN:
-			r.wStr(o, fmt.Sprintf("%ssi.Write(w, []byte(\"%s\"))\n", tabs, r.addSlashes(base.token.Literal)))
+			text := base.token.Literal
+			if parentbase == nil || parentbase.nodeType != NODE_TEXT {
+				text = r.text.filter(text, base.token.Pos == 1)
+			}
+			if text == "" {
+				break
+			}
+			r.wCall(o, tabs, fmt.Sprintf("si.Write(w, []byte(\"%s\"))", r.addSlashes(text)), base.token)
 		case NODE_DISPLAY:
 			// si.WriteStr(w, game.Opponent)
-			r.wStr(o, fmt.Sprintf("%ssi.WriteStrSafe(w, %s, escaper)\n", tabs, r.addSlashes(base.token.Literal)))
+			r.wCall(o, tabs, fmt.Sprintf("si.WriteStrSafe(w, %s%s, escaper)", r.exprAt(base.token), base.token.Literal), base.token)
 		// f.Sp "si.Write(w, indexpage1)")
 		case NODE_DISPLAY_BOOL:
-			r.wStr(o, fmt.Sprintf("%ssi.WriteBool(w, %s)\n", tabs, r.addSlashes(base.token.Literal)))
+			r.wCall(o, tabs, fmt.Sprintf("si.WriteBool(w, %s%s)", r.exprAt(base.token), base.token.Literal), base.token)
 		case NODE_DISPLAY_INT:
-			r.wStr(o, fmt.Sprintf("%ssi.WriteInt(w, %s)\n", tabs, r.addSlashes(base.token.Literal)))
+			r.wCall(o, tabs, fmt.Sprintf("si.WriteInt(w, %s%s)", r.exprAt(base.token), base.token.Literal), base.token)
 		case NODE_DISPLAY_INT64:
-			r.wStr(o, fmt.Sprintf("%ssi.WriteInt64(w, %s)\n", tabs, r.addSlashes(base.token.Literal)))
+			r.wCall(o, tabs, fmt.Sprintf("si.WriteInt64(w, %s%s)", r.exprAt(base.token), base.token.Literal), base.token)
 		case NODE_DISPLAY_RAW:
 			// si.WriteStr(w, game.Opponent)
-			r.wStr(o, fmt.Sprintf("%ssi.WriteStr(w, %s)\n", tabs, r.addSlashes(base.token.Literal)))
+			r.wCall(o, tabs, fmt.Sprintf("si.WriteStr(w, %s%s)", r.exprAt(base.token), base.token.Literal), base.token)
 		// f.Sp "si.Write(w, indexpage1)")
 		case NODE_INCLUDE_SIMPLE:
 			r.WriteNodeSimpleCall(o, base, depth, "c")
@@ -249,35 +513,36 @@ func (r *Render) writeBody(node ast, depth int, o io.Writer, opt *BlipOptions) {
 		case NODE_INCLUDE:
 			r.includeDepth += 1
 			// var c1 context.Context
-			r.wStr(o, fmt.Sprintf("%svar %s = context.WithValue(c, \"__Blip__\", 1)\n", tabs, r.contextVarName()))
+			r.wStr(o, fmt.Sprintf("%s%svar %s = context.WithValue(c, \"__Blip__\", 1)\n", tabs, r.lineAt(base.token.Line, base.token.Pos), r.contextVarName()))
 			// Do After
 		case NODE_CODEBLOCK:
 			r.wStr(o, fmt.Sprintf("%s// Code block follows\n", tabs))
+			r.wLine(o, tabs, base.token)
 		case NODE_TEXT:
 			r.wStr(o, fmt.Sprintf("%s// Text block follows\n", tabs))
 		case NODE_CONTENT:
 			// var f1 = func() {
 			cnum += 1
-			r.wStr(o, fmt.Sprintf("%svar contentF%dS%d = func() (terror error) {\n", tabs, r.includeDepth, cnum))
+			r.wStr(o, fmt.Sprintf("%s%svar contentF%dS%d = func() (terror error) {\n", tabs, r.lineAt(base.token.Line, base.token.Pos), r.includeDepth, cnum))
 		case NODE_YIELD:
 			// 	si.CallCtxFunc(c, "myJavascript")
-			r.wStr(o, fmt.Sprintf("%sterror = si.CallCtxFunc(c, \"%s\")\n", tabs, r.trimAll(base.token.Literal)))
-			r.wStr(o, fmt.Sprintf("%sif terror != nil { return }\n", tabs))
+			r.wCall(o, tabs, fmt.Sprintf("si.CallCtxFunc(c, \"%s\")", r.trimAll(base.token.Literal)), base.token)
 
 		case NODE_IF:
-			r.wStr(o, fmt.Sprintf("%sif %s {\n", tabs, r.trimAll(base.token.Literal)))
+			r.wLine(o, tabs, base.token)
+			r.wStr(o, fmt.Sprintf("%s%sif %s%s {\n", tabs, r.lineAt(base.token.Line, base.token.Pos), r.exprAt(base.token), r.trimAll(base.token.Literal)))
 		case NODE_FOR:
 			r.WriteNodeForStatement(o, base, depth)
 		case NODE_ELSE:
-			r.wStr(o, fmt.Sprintf("%s} else {\n", r.getTabsDepth(depth-1)))
+			r.wStr(o, fmt.Sprintf("%s%s} else {\n", r.getTabsDepth(depth-1), r.lineAt(base.token.Line, base.token.Pos)))
 		case NODE_ENDIF:
-			r.wStr(o, fmt.Sprintf("%s}\n", r.getTabsDepth(depth-1)))
+			r.wStr(o, fmt.Sprintf("%s%s}\n", r.getTabsDepth(depth-1), r.lineAt(base.token.Line, base.token.Pos)))
 		case NODE_END:
 			//if parentbase.nodeType == NODE_CONTENT {
 			//	// We don't actually write the end block when on a content block.
 			//	// due to the way contents are done as lambda functions
 			//} else {
-			r.wStr(o, fmt.Sprintf("%s} // end of %s@%d\n", r.getTabsDepth(depth-1), parentbase.token.Type, parentbase.token.Line))
+			r.wStr(o, fmt.Sprintf("%s%s} // end of %s@%d\n", r.getTabsDepth(depth-1), r.lineAt(base.token.Line, base.token.Pos), parentbase.token.Type, parentbase.token.Line))
 			//}
 
 		default:
@@ -294,6 +559,7 @@ func (r *Render) writeBody(node ast, depth int, o io.Writer, opt *BlipOptions) {
 			r.includeDepth -= 1
 		case NODE_CONTENT:
 			// var f1 = func() {
+			r.wGen(o)
 			r.wStr(o, fmt.Sprintf("%s// End of content block\n", r.getTabsDepth(depth+1)))
 			r.wStr(o, fmt.Sprintf("%sreturn\n", r.getTabsDepth(depth+1)))
 			r.wStr(o, fmt.Sprintf("%s}\n", tabs))
@@ -318,6 +584,7 @@ func (r *Render) writeContentVar(o io.Writer) {
 			eq = " = "
 			// ex: @context errors []string = make([]string,0)
 			r.wStr(o, "\tvar ")
+			r.wTokenAt(o, c, 0)
 			r.wStr(o, c.Literal)
 			r.wStr(o, "\n")
 			addNullCheck = true
@@ -341,6 +608,7 @@ func (r *Render) writeContentVar(o io.Writer) {
 		r.wStr(o, "c.Value(\"")
 		r.wStr(o, split[0])
 		r.wStr(o, "\").(")
+		r.wTokenAt(o, c, strings.Index(c.Literal, " "+split[1])+1)
 		r.wStr(o, split[1])
 		r.wStr(o, ")")
 		r.wNL(o)
@@ -352,6 +620,7 @@ func (r *Render) writeContentVar(o io.Writer) {
 		}
 
 	}
+	r.wGen(o)
 }
 
 func (r *Render) contextVarName() string {
@@ -364,7 +633,10 @@ func (r *Render) trimAll(literal string) string {
 
 func (r *Render) WriteNodeForStatement(o io.Writer, base *astBase, depth int) {
 	sects := r.p.splitFor(base.token)
-	r.wStr(o, fmt.Sprintf("%sfor idx, %s := range %s { _ = idx\n", r.getTabsDepth(depth), sects[0], sects[2]))
+	tok := base.token
+	r.wLine(o, r.getTabsDepth(depth), tok)
+	r.wStr(o, fmt.Sprintf("%s%sfor idx, %s%s := range %s%s { _ = idx\n", r.getTabsDepth(depth), r.lineAt(tok.Line, tok.Pos),
+		r.exprAt(tok), sects[0], r.tokenAt(tok, strings.LastIndex(tok.Literal, sects[2])), sects[2]))
 
 }
 
@@ -0,0 +1,336 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// templateSymbols
+// A parsed template and what the templates calling it need to know.
+type templateSymbols struct {
+	file    *blipFile
+	parser  *Parser
+	args    []*Token
+	context []*Token
+	yields  []string
+	hash    string // Of the source, see the build manifest
+}
+
+// symbolTable
+// All templates of a run by package directory and render function name,
+// used to validate the @include / @extend calls between templates.
+type symbolTable struct {
+	templates map[string]map[string]*templateSymbols // package directory -> render function -> template
+	packages  map[string]string                      // package directory -> package name
+	byFile    map[*blipFile]*templateSymbols
+	functions map[string]map[string]bool // package directory -> functions declared outside of the render functions
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{
+		templates: make(map[string]map[string]*templateSymbols),
+		packages:  make(map[string]string),
+		byFile:    make(map[*blipFile]*templateSymbols),
+		functions: make(map[string]map[string]bool),
+	}
+}
+
+// loadSymbols
+// Parses all templates and validates the calls between them.
+// Templates that cannot be read are added to the diagnostics and left out.
+func loadSymbols(files []*blipFile, diags *diagnostics) *symbolTable {
+	st := newSymbolTable()
+	for _, bf := range files {
+		parser, err := bf.parse()
+		if err != nil {
+			diags.addError(bf.sourceFName, err)
+			continue
+		}
+		st.add(bf, parser)
+	}
+	for _, bf := range files {
+		if ts := st.byFile[bf]; ts != nil {
+			st.validate(ts)
+		}
+	}
+	return st
+}
+
+func (st *symbolTable) add(bf *blipFile, parser *Parser) {
+	ts := &templateSymbols{
+		file:    bf,
+		parser:  parser,
+		args:    parser.args,
+		context: parser.context,
+		yields:  parser.yields(),
+		hash:    sourceHash(parser.lex.input),
+	}
+	if st.templates[bf.destDir] == nil {
+		st.templates[bf.destDir] = make(map[string]*templateSymbols)
+		st.packages[bf.destDir] = bf.packageName()
+	}
+	st.byFile[bf] = ts
+	function := templateFunctionName(bf.renderName(parser))
+	if other := st.templates[bf.destDir][function]; other != nil {
+		// Both would declare the function in the package
+		line, pos := 1, 1
+		if parser.name != nil {
+			line, pos = parser.name.Line, parser.name.Pos
+		}
+		parser.addErrorAt(line, pos, fmt.Sprintf("the render function %s of package %s is also generated from %s, name the template with @name",
+			function, bf.packageName(), other.file.sourceFName))
+		return
+	}
+	st.templates[bf.destDir][function] = ts
+}
+
+// get
+// The template parsed from the file, nil if it could not be read.
+func (st *symbolTable) get(bf *blipFile) *templateSymbols {
+	return st.byFile[bf]
+}
+
+// resolve
+// Finds the template called by an @include / @extend from the template.
+// known is false when the package is not one of the template packages, when the package declares the render function
+// in its hand written go files or in a @func, or when several template packages have the name and none is imported by the template.
+func (st *symbolTable) resolve(from *blipFile, name string) (ts *templateSymbols, known bool) {
+	dir := from.destDir
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		dir = st.packageDir(from, name[0:idx])
+		name = name[idx+1:]
+	}
+	templates, ok := st.templates[dir]
+	if !ok {
+		return nil, false
+	}
+	function := templateFunctionName(name)
+	if ts := templates[function]; ts != nil {
+		return ts, true
+	}
+	if st.declares(dir, function) {
+		return nil, false
+	}
+	return nil, true
+}
+
+// declares
+// True if the package declares the function outside of the render functions, in a hand written go file or a @func.
+func (st *symbolTable) declares(dir string, function string) bool {
+	functions, ok := st.functions[dir]
+	if !ok {
+		functions = goFunctionsOf(dir)
+		for _, ts := range st.templates[dir] {
+			for _, fn := range ts.parser.Template().Funcs {
+				for _, decl := range funcDeclarations(fn) {
+					functions[decl.name] = true
+				}
+			}
+		}
+		st.functions[dir] = functions
+	}
+	return functions[function]
+}
+
+// packageDir
+// The directory of the template package named pkg in the calling template, by its @import when there are several.
+func (st *symbolTable) packageDir(from *blipFile, pkg string) string {
+	var named []string
+	for dir, name := range st.packages {
+		if name == pkg {
+			named = append(named, dir)
+		}
+	}
+	if caller := st.byFile[from]; caller != nil {
+		for _, imp := range caller.parser.imports {
+			fields := strings.Fields(imp.Literal)
+			if len(fields) == 0 {
+				continue
+			}
+			path, err := strconv.Unquote(fields[len(fields)-1])
+			if err != nil {
+				continue
+			}
+			if len(fields) > 1 && fields[0] != pkg {
+				continue
+			}
+			candidates := named
+			if len(fields) > 1 {
+				// Named by the import
+				candidates = nil
+				for dir := range st.packages {
+					candidates = append(candidates, dir)
+				}
+			}
+			if dir := importedDir(path, candidates); dir != "" {
+				return dir
+			}
+		}
+	}
+	if len(named) == 1 {
+		return named[0]
+	}
+	return ""
+}
+
+// importedDir
+// The directory the import path ends with, the one matching the most path elements.
+func importedDir(importPath string, dirs []string) string {
+	elems := strings.Split(importPath, "/")
+	best, bestCount, tie := "", 0, false
+	for _, dir := range dirs {
+		dirElems := strings.Split(filepath.ToSlash(dir), "/")
+		count := 0
+		for count < len(elems) && count < len(dirElems) && elems[len(elems)-1-count] == dirElems[len(dirElems)-1-count] {
+			count++
+		}
+		if count > bestCount {
+			best, bestCount, tie = dir, count, false
+		} else if count == bestCount && count > 0 {
+			tie = true
+		}
+	}
+	if tie {
+		return ""
+	}
+	return best
+}
+
+// validate
+// Checks the @include / @extend calls of the template against the @arg and @yield of the target.
+// Errors are added to the template parser.
+func (st *symbolTable) validate(ts *templateSymbols) {
+	var walk func(node ast)
+	walk = func(node ast) {
+		for _, child := range node.GetChildren() {
+			base := child.(*astBase)
+			if base.nodeType == NODE_INCLUDE_SIMPLE || base.nodeType == NODE_INCLUDE {
+				st.validateCall(ts, base)
+			}
+			walk(child)
+		}
+	}
+	walk(ts.parser.root)
+}
+
+func (st *symbolTable) validateCall(ts *templateSymbols, node *astBase) {
+	p := ts.parser
+	splits := strings.SplitN(strings.TrimSpace(node.token.Literal), " ", 2)
+	name := splits[0]
+	target, known := st.resolve(ts.file, name)
+	if !known {
+		return
+	}
+	if target == nil {
+		p.addError(node.token, fmt.Sprintf("unknown template %s", name))
+		return
+	}
+
+	var args []string
+	if len(splits) > 1 {
+		args = splitCallArgs(splits[1])
+	}
+	if count := len(argVars(target.args)); len(args) != count {
+		p.addError(node.token, fmt.Sprintf("%s expects %d argument(s) %s, found %d", name, count, target.argList(), len(args)))
+	}
+
+	if node.nodeType != NODE_INCLUDE {
+		return
+	}
+	for _, child := range node.children {
+		content := child.(*astBase)
+		if content.nodeType != NODE_CONTENT {
+			continue
+		}
+		contentName := strings.TrimSpace(content.token.Literal)
+		if !target.hasYield(contentName) {
+			p.addError(content.token, fmt.Sprintf("%s has no @yield %s, available: %s", name, contentName, strings.Join(target.yields, ", ")))
+		}
+	}
+}
+
+// argList
+// The @arg declarations as written in a go function signature.
+func (ts *templateSymbols) argList() string {
+	decls := make([]string, 0, len(ts.args))
+	for _, arg := range ts.args {
+		decls = append(decls, strings.TrimSpace(arg.Literal))
+	}
+	return "(" + strings.Join(decls, ", ") + ")"
+}
+
+func (ts *templateSymbols) hasYield(name string) bool {
+	for _, yield := range ts.yields {
+		if yield == name {
+			return true
+		}
+	}
+	return false
+}
+
+// yields
+// The names of the @yield in the template, sorted.
+func (p *Parser) yields() []string {
+	found := make(map[string]bool)
+	var walk func(node ast)
+	walk = func(node ast) {
+		for _, child := range node.GetChildren() {
+			base := child.(*astBase)
+			if base.nodeType == NODE_YIELD {
+				found[strings.TrimSpace(base.token.Literal)] = true
+			}
+			walk(child)
+		}
+	}
+	walk(p.root)
+
+	yields := make([]string, 0, len(found))
+	for name := range found {
+		yields = append(yields, name)
+	}
+	sort.Strings(yields)
+	return yields
+}
+
+// splitCallArgs
+// Splits the arguments of an @include / @extend at the top level commas.
+// Commas inside brackets, braces, parentheses and literals are part of the argument.
+func splitCallArgs(text string) []string {
+	var args []string
+	depth := 0
+	var quote rune
+	start := 0
+	escaped := false
+	for idx, ch := range text {
+		if quote != 0 {
+			if escaped {
+				escaped = false
+			} else if ch == '\\' && quote != '`' {
+				escaped = true
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case '"', '\'', '`':
+			quote = ch
+		case '(', '[', '{':
+			depth += 1
+		case ')', ']', '}':
+			depth -= 1
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(text[start:idx]))
+				start = idx + 1
+			}
+		}
+	}
+	if last := strings.TrimSpace(text[start:]); last != "" || len(args) > 0 {
+		args = append(args, last)
+	}
+	return args
+}
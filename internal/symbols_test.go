@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitCallArgs(t *testing.T) {
+	assert.Equal(t, 0, len(splitCallArgs("")))
+	assert.Equal(t, []string{"a"}, splitCallArgs(" a "))
+	assert.Equal(t, []string{"a", "f(b, c)", "[]int{1, 2}", `"x, y"`}, splitCallArgs(`a, f(b, c), []int{1, 2}, "x, y"`))
+}
+
+func TestSymbolValidation(t *testing.T) {
+	root := t.TempDir()
+	layout := filepath.Join(root, "symlayout")
+	pages := filepath.Join(root, "sympages")
+	writeTemplate(t, layout, "base.blip.html", `@arg title string
+@context user string
+<title>@= title @</title>
+@yield body
+@yield scripts
+`)
+	writeTemplate(t, pages, "card.blip.html", `@arg name string
+@arg count int
+<div>@= name @</div>
+`)
+	writeTemplate(t, pages, "pair.blip.html", "@arg first, last string\n")
+	writeTemplate(t, pages, "index.blip.html", `@include card "bob"
+@include card "bob", 2
+@include cards "bob", 2
+@include other.thing 1, 2
+@extend symlayout.base "Index"
+	@content body
+	@end
+	@content footer
+	@end
+@end
+@include pair "a", "b"
+`)
+
+	var files []*blipFile
+	diags := &diagnostics{}
+	collectBlipFiles(root, root, &files, diags)
+	st := loadSymbols(files, diags)
+	assert.Equal(t, 0, len(diags.list))
+
+	var index *templateSymbols
+	for _, bf := range files {
+		if bf.name == "index.blip.html" {
+			index = st.get(bf)
+		}
+	}
+
+	base, known := st.resolve(index.file, "symlayout.base")
+	assert.True(t, known)
+	assert.Equal(t, []string{"body", "scripts"}, base.yields)
+	assert.Equal(t, 1, len(base.context))
+	var msgs []string
+	for _, err := range index.parser.errors {
+		msgs = append(msgs, err.msg)
+	}
+	assert.Equal(t, 3, len(msgs), strings.Join(msgs, "\n"))
+	assert.Equal(t, 1, index.parser.errors[0].lineNum)
+	assert.Contains(t, msgs[0], "card expects 2 argument(s) (name string, count int), found 1")
+	assert.Contains(t, msgs[1], "unknown template cards")
+	assert.Equal(t, 8, index.parser.errors[2].lineNum)
+	assert.Contains(t, msgs[2], "symlayout.base has no @yield footer, available: body, scripts")
+}
+
+func TestSymbolHandWrittenRender(t *testing.T) {
+	views := filepath.Join(t.TempDir(), "views")
+	writeTemplate(t, views, "widget.go", "package views\n\nimport (\n\t\"context\"\n\t\"io\"\n)\n\nfunc WidgetRender(c context.Context, w io.Writer) (terror error) {\n\treturn nil\n}\n")
+	writeTemplate(t, views, "page.blip.html", "@func\nfunc BadgeRender(c context.Context, w io.Writer) (terror error) {\n\treturn nil\n}\n@end\n@include widget\n@include badge\n@include missing\n")
+
+	var files []*blipFile
+	diags := &diagnostics{}
+	collectBlipFiles("", views, &files, diags)
+	st := loadSymbols(files, diags)
+	assert.Equal(t, 0, len(diags.list))
+
+	// The render functions of the package are left to the go compiler
+	page := st.get(files[0])
+	assert.Equal(t, 1, len(page.parser.errors))
+	assert.Contains(t, page.parser.errors[0].msg, "unknown template missing")
+}
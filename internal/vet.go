@@ -0,0 +1,453 @@
+package internal
+
+import (
+	"fmt"
+	goast "go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	blipast "github.com/samlotti/blip/ast"
+)
+
+// GteVet
+// Reports suspicious constructs in the templates that compile but are likely mistakes.
+// The template errors are reported as well. Returns the number of diagnostics.
+func GteVet(opt *BlipOptions) int {
+	diags := &diagnostics{}
+	vetTemplates(opt, diags)
+	return reportDiagnostics(diags, opt)
+}
+
+func vetTemplates(opt *BlipOptions, diags *diagnostics) {
+	// Nothing is generated, the go.mod is not needed
+	files := collectRoots("", opt, diags)
+	symbols := loadSymbols(files, diags)
+
+	v := &vetter{
+		symbols:   symbols,
+		diags:     diags,
+		templates: make(map[*templateSymbols]*blipast.Template),
+		callers:   make(map[*templateSymbols]int),
+		provided:  make(map[*templateSymbols]map[string]bool),
+	}
+	for _, bf := range files {
+		if ts := symbols.get(bf); ts != nil {
+			diags.addParserErrors(bf.sourceFName, ts.parser)
+			v.order = append(v.order, ts)
+			v.templates[ts] = ts.parser.Template()
+		}
+	}
+	for _, ts := range v.order {
+		v.vetTemplate(ts)
+	}
+	v.vetYields()
+	v.vetFuncs()
+}
+
+// vetter
+// The state of a vet run over all templates.
+type vetter struct {
+	symbols   *symbolTable
+	diags     *diagnostics
+	order     []*templateSymbols
+	templates map[*templateSymbols]*blipast.Template
+	callers   map[*templateSymbols]int             // How many @include / @extend call the template
+	provided  map[*templateSymbols]map[string]bool // The @content given to the template by its callers
+}
+
+// warn
+// A finding of vet, reported as a warning.
+func (v *vetter) warn(ts *templateSymbols, pos blipast.Pos, msg string) {
+	v.diags.add(Diagnostic{
+		File:     ts.file.sourceFName,
+		Line:     pos.Line,
+		Col:      pos.Col,
+		Severity: "warning",
+		Message:  msg,
+	})
+}
+
+// vetTemplate
+// The checks within one template: unused declarations, @== of args and the calls to other templates.
+func (v *vetter) vetTemplate(ts *templateSymbols) {
+	tmpl := v.templates[ts]
+
+	used := templateIdentifiers(tmpl)
+
+	// Values derived from the args, by name
+	derived := make(map[string]string)
+	for _, arg := range tmpl.Args {
+		derived[arg.Name] = arg.Name
+	}
+
+	var walk func(nodes []blipast.Node)
+	walk = func(nodes []blipast.Node) {
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case *blipast.Display:
+				if n.Kind == blipast.DisplayRaw {
+					if arg := derivedFrom(n.Expr, derived); arg != "" {
+						v.warn(ts, n.Pos(), fmt.Sprintf("@== writes %s without escaping, it is derived from @arg %s", n.Expr, arg))
+					}
+				}
+			case *blipast.If:
+				walk(n.Then)
+				walk(n.Else)
+			case *blipast.For:
+				if arg := derivedFrom(n.Range, derived); arg != "" {
+					derived[n.Var] = arg
+				}
+				walk(n.Body)
+			case *blipast.Code:
+				deriveAssignments(n.Code, derived)
+			case *blipast.Include:
+				v.addCall(ts, n.Template, nil)
+			case *blipast.Extend:
+				v.addCall(ts, n.Template, n.Contents)
+				for _, content := range n.Contents {
+					walk(content.Body)
+				}
+			}
+		}
+	}
+	walk(tmpl.Body)
+
+	for _, arg := range tmpl.Args {
+		if !used[arg.Name] {
+			v.warn(ts, arg.Pos(), fmt.Sprintf("@arg %s is never used", arg.Name))
+		}
+	}
+	for _, ctx := range tmpl.Context {
+		if !used[ctx.Name] {
+			v.warn(ts, ctx.Pos(), fmt.Sprintf("@context %s is never used", ctx.Name))
+		}
+	}
+	for _, imp := range tmpl.Imports {
+		name, ok := importName(imp.Spec)
+		if ok && !used[name] {
+			v.warn(ts, imp.Pos(), fmt.Sprintf("@import %s is never used", imp.Spec))
+		}
+	}
+}
+
+// addCall
+// Records the @include / @extend of a template for the @yield check.
+func (v *vetter) addCall(ts *templateSymbols, name string, contents []*blipast.Content) {
+	target, _ := v.symbols.resolve(ts.file, name)
+	if target == nil {
+		return
+	}
+	v.callers[target]++
+	if v.provided[target] == nil {
+		v.provided[target] = make(map[string]bool)
+	}
+	for _, content := range contents {
+		v.provided[target][content.Name] = true
+	}
+}
+
+// vetYields
+// A @yield of a template that is called by other templates, none of which gives its @content.
+// Templates that are not called from a template may be rendered from go, they are not checked.
+func (v *vetter) vetYields() {
+	for _, ts := range v.order {
+		if v.callers[ts] == 0 {
+			continue
+		}
+		blipast.Inspect(v.templates[ts], func(node blipast.Node) bool {
+			if yield, ok := node.(*blipast.Yield); ok && !v.provided[ts][yield.Name] {
+				v.warn(ts, yield.Pos(), fmt.Sprintf("no caller of %s provides @content %s", ts.file.templateName(), yield.Name))
+			}
+			return true
+		})
+	}
+}
+
+// funcDecl
+// A declaration of a @func block.
+type funcDecl struct {
+	ts  *templateSymbols
+	pos blipast.Pos
+}
+
+// vetFuncs
+// The declarations of the @func blocks are in the same go package for all templates of a directory,
+// a name declared twice does not compile.
+func (v *vetter) vetFuncs() {
+	declared := make(map[string]map[string]funcDecl) // package directory -> name -> first declaration
+	for _, ts := range v.order {
+		dir := ts.file.destDir
+		if declared[dir] == nil {
+			declared[dir] = make(map[string]funcDecl)
+		}
+		for _, fn := range v.templates[ts].Funcs {
+			for _, decl := range funcDeclarations(fn) {
+				prior, found := declared[dir][decl.name]
+				if !found {
+					declared[dir][decl.name] = funcDecl{ts: ts, pos: decl.pos}
+					continue
+				}
+				v.warn(ts, decl.pos, fmt.Sprintf("%s is redeclared in package %s, declared before at %s:%s",
+					decl.name, ts.file.packageName(), prior.ts.file.sourceFName, prior.pos))
+			}
+		}
+	}
+}
+
+// namedPos
+// A name declared in go code and its position in the template.
+type namedPos struct {
+	name string
+	pos  blipast.Pos
+}
+
+// funcDeclarations
+// The package level names declared by the @func, methods are named Type.Method.
+// Returns nothing when the code does not parse, the go compiler reports it.
+func funcDeclarations(fn *blipast.Func) []namedPos {
+	const prefix = "package p\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", prefix+fn.Code, 0)
+	if err != nil {
+		return nil
+	}
+	var found []namedPos
+	add := func(ident *goast.Ident, name string) {
+		if ident.Name == "_" || ident.Name == "init" {
+			return
+		}
+		offset := fset.Position(ident.Pos()).Offset - len(prefix)
+		found = append(found, namedPos{name: name, pos: offsetPos(fn.CodePos, fn.Code, offset)})
+	}
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *goast.FuncDecl:
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				name = receiverType(d.Recv.List[0].Type) + "." + name
+			}
+			add(d.Name, name)
+		case *goast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *goast.TypeSpec:
+					add(s.Name, s.Name.Name)
+				case *goast.ValueSpec:
+					for _, ident := range s.Names {
+						add(ident, ident.Name)
+					}
+				}
+			}
+		}
+	}
+	return found
+}
+
+// receiverType
+// The type name of a method receiver, ex: *list -> list
+func receiverType(expr goast.Expr) string {
+	switch e := expr.(type) {
+	case *goast.StarExpr:
+		return receiverType(e.X)
+	case *goast.IndexExpr:
+		return receiverType(e.X)
+	case *goast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+// offsetPos
+// The template position of the byte offset in text that starts at start.
+func offsetPos(start blipast.Pos, text string, offset int) blipast.Pos {
+	pos := start
+	for idx, ch := range text {
+		if idx >= offset {
+			break
+		}
+		if ch == '\n' {
+			pos.Line++
+			pos.Col = 1
+		} else {
+			pos.Col++
+		}
+		pos.Offset += utf8.RuneLen(ch)
+	}
+	return pos
+}
+
+// templateIdentifiers
+// The identifiers used by the go code of the template.
+func templateIdentifiers(tmpl *blipast.Template) map[string]bool {
+	used := make(map[string]bool)
+	addUsed := func(code string) {
+		for name := range goIdentifiers(code) {
+			used[name] = true
+		}
+	}
+	blipast.Inspect(tmpl, func(node blipast.Node) bool {
+		switch n := node.(type) {
+		case *blipast.Arg:
+			addUsed(n.Type)
+		case *blipast.Context:
+			addUsed(n.Type)
+			addUsed(n.Value)
+		case *blipast.Func:
+			addUsed(n.Code)
+		case *blipast.Display:
+			addUsed(n.Expr)
+		case *blipast.If:
+			addUsed(n.Cond)
+		case *blipast.For:
+			addUsed(n.Range)
+		case *blipast.Code:
+			addUsed(n.Code)
+		case *blipast.Include:
+			addUsed(n.Template)
+			addUsed(strings.Join(n.Args, ","))
+		case *blipast.Extend:
+			// The package of a template in another package is imported, ex: layout.base
+			addUsed(n.Template)
+			addUsed(strings.Join(n.Args, ","))
+		}
+		return true
+	})
+	return used
+}
+
+// goIdentifiers
+// The identifiers of go code, not counting the field and method names after a dot.
+func goIdentifiers(code string) map[string]bool {
+	found := make(map[string]bool)
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	src := []byte(code)
+	s.Init(fset.AddFile("", fset.Base(), len(src)), src, nil, 0)
+	prior := token.ILLEGAL
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.IDENT && prior != token.PERIOD {
+			found[lit] = true
+		}
+		prior = tok
+	}
+	return found
+}
+
+// derivedFrom
+// The arg the expression is derived from, empty when it uses none of the derived values.
+func derivedFrom(expr string, derived map[string]string) string {
+	var args []string
+	for name := range goIdentifiers(expr) {
+		if arg, ok := derived[name]; ok {
+			args = append(args, arg)
+		}
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	sort.Strings(args)
+	return args[0]
+}
+
+// deriveAssignments
+// Variables assigned in @code from a derived value are derived as well, ex: html := strings.ToUpper(arg)
+func deriveAssignments(code string, derived map[string]string) {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\nfunc _() {\n"+code+"\n}", 0)
+	if err != nil {
+		return
+	}
+	goast.Inspect(file, func(node goast.Node) bool {
+		switch n := node.(type) {
+		case *goast.AssignStmt:
+			derive(n.Lhs, n.Rhs, derived)
+		case *goast.ValueSpec:
+			var lhs []goast.Expr
+			for _, name := range n.Names {
+				lhs = append(lhs, name)
+			}
+			derive(lhs, n.Values, derived)
+		}
+		return true
+	})
+}
+
+func derive(lhs []goast.Expr, rhs []goast.Expr, derived map[string]string) {
+	var arg string
+	for _, expr := range rhs {
+		if arg = derivedFrom(exprSource(expr), derived); arg != "" {
+			break
+		}
+	}
+	if arg == "" {
+		return
+	}
+	for _, expr := range lhs {
+		if ident, ok := expr.(*goast.Ident); ok && ident.Name != "_" {
+			derived[ident.Name] = arg
+		}
+	}
+}
+
+// exprSource
+// The identifiers of the expression separated by spaces, enough for derivedFrom.
+func exprSource(expr goast.Expr) string {
+	var names []string
+	goast.Inspect(expr, func(node goast.Node) bool {
+		switch n := node.(type) {
+		case *goast.SelectorExpr:
+			// The selected field is not a variable
+			goast.Inspect(n.X, func(node goast.Node) bool {
+				if ident, ok := node.(*goast.Ident); ok {
+					names = append(names, ident.Name)
+				}
+				return true
+			})
+			return false
+		case *goast.Ident:
+			names = append(names, n.Name)
+		}
+		return true
+	})
+	return strings.Join(names, " ")
+}
+
+var importVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// importName
+// The name an @import is used by: the alias, or the last element of the path.
+// ok is false for the blank and dot imports, they are not used by name.
+// The package name is guessed from the path: gopkg.in/yaml.v3 is yaml, github.com/x/go-difflib is difflib.
+func importName(spec string) (string, bool) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return "", false
+	}
+	if len(fields) > 1 {
+		return fields[0], fields[0] != "_" && fields[0] != "."
+	}
+	path, err := strconv.Unquote(fields[0])
+	if err != nil {
+		return "", false
+	}
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if importVersion.MatchString(name) && len(elems) > 1 {
+		name = elems[len(elems)-2]
+	}
+	if idx := strings.Index(name, "."); idx > 0 {
+		name = name[0:idx]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	name = strings.TrimSuffix(name, "-go")
+	return strings.ReplaceAll(name, "-", ""), true
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func vetOutput(t *testing.T, sdir string) (int, string) {
+	diags := &diagnostics{}
+	vetTemplates(&BlipOptions{Sdir: sdir}, diags)
+	var out bytes.Buffer
+	list := diags.sorted()
+	WriteDiagnostics(&out, list, FormatGcc)
+	return len(list), out.String()
+}
+
+func TestVet(t *testing.T) {
+	root := t.TempDir()
+	sdir := filepath.Join(root, "pages")
+	writeTemplate(t, filepath.Join(root, "layout"), "base.blip.html", `@arg title string
+<title>@= title @</title>
+@yield body
+@yield footer
+`)
+	writeTemplate(t, sdir, "index.blip.html", `@import "strings"
+@import "sort"
+@arg name string
+@arg unused int
+@arg items []string
+@context user string
+@code
+	upper := strings.ToUpper(name)
+@end
+@import "example.com/x/layout"
+@extend layout.base "Home"
+	@content body
+	@== upper @
+	@for i in items
+		@== i @
+	@end
+	@== "<br>" @
+	@end
+@end
+`)
+	writeTemplate(t, sdir, "helpers.blip.html", `@func
+func activeDesc(active bool) string {
+	return "active"
+}
+@end
+`)
+	writeTemplate(t, sdir, "more.blip.html", `@func
+func activeDesc(active bool) string {
+	return "yes"
+}
+
+type item struct{}
+
+func (i *item) Name() string { return "" }
+@end
+@func
+func (i item) Name() string { return "" }
+@end
+`)
+
+	count, out := vetOutput(t, root)
+	index := filepath.Join(sdir, "index.blip.html")
+	assert.Contains(t, out, index+":2:1: warning: @import \"sort\" is never used\n")
+	assert.Contains(t, out, index+":4:1: warning: @arg unused is never used\n")
+	assert.Contains(t, out, index+":6:1: warning: @context user is never used\n")
+	assert.Contains(t, out, index+":13:2: warning: @== writes upper without escaping, it is derived from @arg name\n")
+	assert.Contains(t, out, index+":15:3: warning: @== writes i without escaping, it is derived from @arg items\n")
+	assert.Contains(t, out, filepath.Join(root, "layout", "base.blip.html")+":4:1: warning: no caller of base provides @content footer\n")
+	assert.Contains(t, out, filepath.Join(sdir, "more.blip.html")+":2:6: warning: activeDesc is redeclared in package pages, declared before at "+filepath.Join(sdir, "helpers.blip.html")+":2:6\n")
+	assert.Contains(t, out, filepath.Join(sdir, "more.blip.html")+":11:15: warning: item.Name is redeclared in package pages, declared before at "+filepath.Join(sdir, "more.blip.html")+":8:16\n")
+	assert.Equal(t, 8, count, out)
+}
+
+func TestVetContentErrors(t *testing.T) {
+	sdir := filepath.Join(t.TempDir(), "pages")
+	writeTemplate(t, sdir, "layout.blip.html", `@yield body
+`)
+	writeTemplate(t, sdir, "index.blip.html", `@extend layout
+	@content body
+	x
+	@end
+	@content missing
+	y
+	@end
+@end
+`)
+
+	count, out := vetOutput(t, sdir)
+	assert.Equal(t, 1, count, out)
+	assert.Contains(t, out, "index.blip.html:5:2: error: @content : layout has no @yield missing, available: body\n")
+}
+
+func TestImportName(t *testing.T) {
+	for spec, expected := range map[string]string{
+		`"strings"`:          "strings",
+		`"net/http"`:         "http",
+		`h "net/http"`:       "h",
+		`"gopkg.in/yaml.v3"`: "yaml",
+		`"github.com/pmezard/go-difflib/difflib"`: "difflib",
+		`"github.com/x/go-thing"`:                 "thing",
+		`"github.com/x/y/v2"`:                     "y",
+	} {
+		name, ok := importName(spec)
+		assert.True(t, ok, spec)
+		assert.Equal(t, expected, name, spec)
+	}
+	_, ok := importName(`_ "embed"`)
+	assert.False(t, ok)
+}
@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDelay
+// How long a file is quiet before it is built, editors save with several writes.
+var watchDelay = 100 * time.Millisecond
+
+// watchFiles
+// Transpiles the templates when they change, first is the diagnostics of the first build.
+// Returns when the watch cannot start, or on an interrupt once the -exec command stopped.
+func watchFiles(opt *BlipOptions, first []Diagnostic, reload *reloadServer) error {
+	fmt.Fprintf(opt.progress(), "---Watching for file changes in %s\n", strings.Join(opt.roots(), ", "))
+	w, err := newTemplateWatcher(opt)
+	if err != nil {
+		return err
+	}
+	var runner *execRunner
+	if opt.Exec != "" {
+		// The command runs in its own process group, it does not get the interrupt of the terminal
+		runner = newExecRunner(opt.Exec, w.out)
+		defer runner.close()
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		go func() {
+			<-signals
+			w.close()
+		}()
+	}
+	w.afterBuild = func(list []Diagnostic) {
+		// The command is restarted before the pages reload
+		if runner != nil {
+			runner.afterBuild(len(list))
+		}
+		if reload != nil {
+			reload.afterBuild(list)
+		}
+	}
+	w.afterBuild(first)
+	w.watch()
+	return nil
+}
+
+// templateWatcher
+// Watches the source directories and their new sub directories.
+// The events of a file are debounced, the builds run one at a time: the files changed during a build are built by the next one.
+type templateWatcher struct {
+	opt     *BlipOptions
+	out     io.Writer
+	watcher *fsnotify.Watcher
+
+	mu      sync.Mutex
+	dirs    map[string]bool        // The watched directories
+	timers  map[string]*time.Timer // The debounce of the files by name
+	changed map[string]bool        // The templates to build
+	removed bool                   // A template or directory was removed since the last build
+	wake    chan struct{}
+	stopped bool
+
+	// afterBuild is called after each build with its diagnostics, ex: to run -exec
+	afterBuild func(list []Diagnostic)
+}
+
+func newTemplateWatcher(opt *BlipOptions) (*templateWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &templateWatcher{
+		opt:     opt,
+		out:     opt.progress(),
+		watcher: watcher,
+		dirs:    make(map[string]bool),
+		timers:  make(map[string]*time.Timer),
+		changed: make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+	}
+	for _, root := range opt.roots() {
+		if err := w.addDir(filepath.Clean(root), false); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// addDir
+// Watches the directory and its sub directories. With build, the templates found are built, ex: a directory moved in.
+func (w *templateWatcher) addDir(dir string, build bool) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if err := w.watcher.Add(dir); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.dirs[dir] = true
+	w.mu.Unlock()
+	fmt.Fprintf(w.out, "Watching dir: %s\n", dir)
+
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if err := w.addDir(name, build); err != nil {
+				return err
+			}
+		} else if build && IsTemplateFile(entry.Name()) {
+			w.schedule(name)
+		}
+	}
+	return nil
+}
+
+// removeDir
+// Stops watching the removed or renamed directory and its sub directories.
+func (w *templateWatcher) removeDir(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for watched := range w.dirs {
+		if watched == dir || strings.HasPrefix(watched, dir+string(filepath.Separator)) {
+			// Already gone when the directory was removed
+			_ = w.watcher.Remove(watched)
+			delete(w.dirs, watched)
+		}
+	}
+}
+
+// watch
+// Handles the events until the watcher is closed.
+func (w *templateWatcher) watch() {
+	go w.build()
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				w.stop()
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				w.stop()
+				return
+			}
+			fmt.Fprintf(os.Stderr, "blip: watch: %s\n", err)
+		}
+	}
+}
+
+// close
+// Stops the watch.
+func (w *templateWatcher) close() error {
+	return w.watcher.Close()
+}
+
+// stop
+// Ends the builds once the watcher is closed.
+func (w *templateWatcher) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.wake)
+	}
+}
+
+// handle
+// A rename is a Rename of the old name followed by the Create of the new name.
+func (w *templateWatcher) handle(event fsnotify.Event) {
+	name := filepath.Clean(event.Name)
+	w.mu.Lock()
+	isDir := w.dirs[name]
+	w.mu.Unlock()
+
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		if isDir {
+			w.removeDir(name)
+			w.schedule(name)
+		} else if IsTemplateFile(filepath.Base(name)) {
+			w.schedule(name)
+		}
+		return
+	}
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		// Removed since, its Remove event follows
+		return
+	}
+	if fi.IsDir() {
+		if event.Has(fsnotify.Create) && !isDir {
+			if err := w.addDir(name, true); err != nil {
+				fmt.Fprintf(os.Stderr, "blip: watch: %s\n", err)
+			}
+		}
+		return
+	}
+	if IsTemplateFile(fi.Name()) {
+		w.schedule(name)
+	}
+}
+
+// schedule
+// Builds the file, or removes its generated files, once it has no events for watchDelay.
+func (w *templateWatcher) schedule(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if timer, ok := w.timers[name]; ok {
+		timer.Reset(watchDelay)
+		return
+	}
+	w.timers[name] = time.AfterFunc(watchDelay, func() {
+		w.mu.Lock()
+		delete(w.timers, name)
+		if fi, err := os.Stat(name); err == nil && !fi.IsDir() {
+			w.changed[name] = true
+		} else if err != nil {
+			w.removed = true
+		}
+		if !w.stopped {
+			select {
+			case w.wake <- struct{}{}:
+			default:
+				// A build is already waiting
+			}
+		}
+		w.mu.Unlock()
+	})
+}
+
+// build
+// Builds the pending changes, one build at a time.
+func (w *templateWatcher) build() {
+	for range w.wake {
+		w.mu.Lock()
+		changed, removed := w.changed, w.removed
+		w.changed, w.removed = make(map[string]bool), false
+		w.mu.Unlock()
+		if len(changed) == 0 && !removed {
+			continue
+		}
+
+		diags := &diagnostics{}
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					diags.addError(strings.Join(sortedNames(changed), ", "), fmt.Errorf("%v", err))
+				}
+			}()
+			processChanged(changed, w.opt, diags)
+		}()
+		fmt.Fprintf(w.out, "\n")
+		list := diags.sorted()
+		reportDiagnostics(diags, w.opt)
+		if w.afterBuild != nil {
+			w.afterBuild(list)
+		}
+	}
+}
+
+// sortedNames
+// The names of the set, sorted.
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor
+// Polls the condition until it holds or the test times out.
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", msg)
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func TestWatch(t *testing.T) {
+	modDir := t.TempDir()
+	writeTemplate(t, modDir, "go.mod", "module example.com/watch\n")
+	root := filepath.Join(modDir, "template")
+	writeTemplate(t, filepath.Join(root, "pages"), "index.blip.html", "<p>index</p>\n")
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(modDir))
+	defer os.Chdir(cwd)
+
+	defer func(delay time.Duration) { watchDelay = delay }(watchDelay)
+	watchDelay = 50 * time.Millisecond
+
+	opt := &BlipOptions{Sdir: root, SupportBranch: "github.com/samlotti/blip/blipUtil"}
+	diags := &diagnostics{}
+	processDir(opt, diags)
+	assert.Equal(t, 0, len(diags.list), diags.sorted())
+
+	w, err := newTemplateWatcher(opt)
+	assert.Nil(t, err)
+	w.out = ioutil.Discard
+	var mu sync.Mutex
+	builds := 0
+	w.afterBuild = func(list []Diagnostic) {
+		mu.Lock()
+		builds++
+		mu.Unlock()
+	}
+	buildCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return builds
+	}
+	go w.watch()
+	defer w.close()
+
+	// A new directory is watched and its templates built
+	out := filepath.Join(modDir, "blipped")
+	writeTemplate(t, filepath.Join(root, "cards"), "user.blip.html", "<p>user</p>\n")
+	waitFor(t, "the new directory", func() bool { return exists(filepath.Join(out, "cards", "user.blip.go")) })
+	writeTemplate(t, filepath.Join(root, "cards"), "team.blip.html", "<p>team</p>\n")
+	waitFor(t, "the template of the new directory", func() bool { return exists(filepath.Join(out, "cards", "team.blip.go")) })
+
+	// Several writes of a file are one build
+	waitFor(t, "the builds", func() bool { return buildCount() > 0 })
+	time.Sleep(3 * watchDelay)
+	before := buildCount()
+	for i := 0; i < 5; i++ {
+		writeTemplate(t, filepath.Join(root, "pages"), "index.blip.html", "<p>index</p>\n<p>edited</p>\n")
+	}
+	waitFor(t, "the edit", func() bool {
+		code, _ := ioutil.ReadFile(filepath.Join(out, "pages", "index.blip.go"))
+		return strings.Contains(string(code), "edited")
+	})
+	time.Sleep(3 * watchDelay)
+	assert.Equal(t, before+1, buildCount())
+
+	// A rename generates the new name and removes the old output
+	assert.Nil(t, os.Rename(filepath.Join(root, "cards", "team.blip.html"), filepath.Join(root, "cards", "group.blip.html")))
+	waitFor(t, "the rename", func() bool {
+		return exists(filepath.Join(out, "cards", "group.blip.go")) && !exists(filepath.Join(out, "cards", "team.blip.go"))
+	})
+
+	// A removed directory removes its outputs
+	assert.Nil(t, os.RemoveAll(filepath.Join(root, "cards")))
+	waitFor(t, "the removed directory", func() bool { return !exists(filepath.Join(out, "cards")) })
+	assert.True(t, exists(filepath.Join(out, "pages", "index.blip.go")))
+}